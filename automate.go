@@ -0,0 +1,451 @@
+// Copyright (c) 2020-2024 Andrew Stormont
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"beoutil/clients/beoremote"
+	"beoutil/clients/beoremote/models"
+
+	"github.com/urfave/cli/v2"
+)
+
+// duration is a time.Duration that is written as a string, e.g. "30s".
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
+
+// timeOfDay is a time of day written as "HH:MM".
+type timeOfDay struct {
+	Set     bool
+	Minutes int
+}
+
+func (t *timeOfDay) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.Parse("15:04", s)
+	if err != nil {
+		return fmt.Errorf("invalid time of day %q, expected HH:MM", s)
+	}
+	*t = timeOfDay{Set: true, Minutes: v.Hour()*60 + v.Minute()}
+	return nil
+}
+
+// automationMatch describes the notifications a rule fires on. Empty
+// fields match anything. State, Source and Power are matched against what
+// the product is doing, and only when a notification changes it into the
+// wanted value, so a rule fires once each time a product starts playing
+// rather than on every notification while it plays.
+type automationMatch struct {
+	Type    models.NotificationType `json:"type,omitempty"`
+	State   models.State            `json:"state,omitempty"`   // State is the play state, e.g. "play".
+	Source  string                  `json:"source,omitempty"`  // Source is a source type, name or ID, e.g. "DEEZER".
+	Power   models.PowerState       `json:"power,omitempty"`   // Power is "on" or "standby".
+	Product string                  `json:"product,omitempty"` // Product is a product name, alias, JID or IP.
+	After   timeOfDay               `json:"after"`             // After is the start of the time window.
+	Before  timeOfDay               `json:"before"`            // Before is the end of the time window, which may wrap past midnight.
+}
+
+// automationAction is run against Product, or the product that sent
+// the notification if Product is empty.
+type automationAction struct {
+	Action  string `json:"action"`
+	Product string `json:"product,omitempty"`
+	Value   string `json:"value,omitempty"`
+}
+
+type automationRule struct {
+	Name     string             `json:"name"`
+	When     automationMatch    `json:"when"`
+	Actions  []automationAction `json:"actions"`
+	Debounce duration           `json:"debounce,omitempty"` // Debounce is how long to ignore matches for after the rule fires.
+
+	productAddr string
+}
+
+type automationRules struct {
+	Rules []*automationRule `json:"rules"`
+}
+
+// automationFunc runs an action against br. from is the product that
+// sent the notification, and value is the action's Value, which only
+// some actions use.
+type automationFunc func(ctx context.Context, br, from *beoremote.Client, value string) error
+
+// automationSetVolume sets the volume of br, keeping to its volume limit.
+func automationSetVolume(ctx context.Context, br *beoremote.Client, value string) error {
+	v, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+	if limit, ok := volumeLimit(br.Addr()); ok && v > limit {
+		v = limit
+	}
+	return br.BeoZone.SetVolume(ctx, v)
+}
+
+// automationActions are the actions a rule may take.
+var automationActions = map[string]automationFunc{
+	"standby": func(ctx context.Context, br, _ *beoremote.Client, _ string) error {
+		return br.BeoDevice.Standby(ctx)
+	},
+	"all-standby": func(ctx context.Context, br, _ *beoremote.Client, _ string) error {
+		return br.BeoDevice.AllStandby(ctx)
+	},
+	"power-on": func(ctx context.Context, br, _ *beoremote.Client, _ string) error {
+		return br.BeoDevice.PowerOn(ctx)
+	},
+	"play": func(ctx context.Context, br, _ *beoremote.Client, _ string) error {
+		return br.BeoZone.Play(ctx)
+	},
+	"pause": func(ctx context.Context, br, _ *beoremote.Client, _ string) error {
+		return br.BeoZone.Pause(ctx)
+	},
+	"stop": func(ctx context.Context, br, _ *beoremote.Client, _ string) error {
+		return br.BeoZone.Stop(ctx)
+	},
+	"forward": func(ctx context.Context, br, _ *beoremote.Client, _ string) error {
+		return br.BeoZone.Forward(ctx)
+	},
+	"backward": func(ctx context.Context, br, _ *beoremote.Client, _ string) error {
+		return br.BeoZone.Backward(ctx)
+	},
+	"set-volume": func(ctx context.Context, br, _ *beoremote.Client, value string) error {
+		return automationSetVolume(ctx, br, value)
+	},
+	"set-muted": func(ctx context.Context, br, _ *beoremote.Client, value string) error {
+		m, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		return br.BeoZone.SetMuted(ctx, m)
+	},
+	"set-source": func(ctx context.Context, br, _ *beoremote.Client, value string) error {
+		id, err := resolveSource(ctx, br, value)
+		if err != nil {
+			return err
+		}
+		return br.BeoZone.PlaySource(ctx, id)
+	},
+	// join adds br as a listener to the experience of the product that
+	// sent the notification, and sets its volume if value is given.
+	"join": func(ctx context.Context, br, from *beoremote.Client, value string) error {
+		if br.Addr() == from.Addr() {
+			return errors.New("a product can't join itself")
+		}
+		jid := productJid(br.Addr())
+		if jid == "" {
+			return fmt.Errorf("%s isn't in the product cache", br.Addr())
+		}
+		if value != "" {
+			if _, err := strconv.Atoi(value); err != nil {
+				return err
+			}
+		}
+		if err := from.BeoZone.AddListener(ctx, models.Jid(jid)); err != nil {
+			return err
+		}
+		if value == "" {
+			return nil
+		}
+		return automationSetVolume(ctx, br, value)
+	},
+	"end-experience": func(ctx context.Context, br, _ *beoremote.Client, _ string) error {
+		return br.BeoZone.EndExperience(ctx)
+	},
+}
+
+func getRulesPath(c *cli.Context) (string, error) {
+	if c.Args().Present() {
+		return c.Args().First(), nil
+	}
+	dir, err := getConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "automate.json"), nil
+}
+
+func loadAutomationRules(path string) ([]*automationRule, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules automationRules
+	if err = json.Unmarshal(b, &rules); err != nil {
+		return nil, fmt.Errorf("invalid rules file %s: %w", path, err)
+	}
+	for i, r := range rules.Rules {
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("rule %d", i+1)
+		}
+		switch r.When.Power {
+		case "", models.PowerStateOn, models.PowerStateStandby:
+		default:
+			return nil, fmt.Errorf("%s: power must be %q or %q", r.Name, models.PowerStateOn, models.PowerStateStandby)
+		}
+		if r.When.After.Set != r.When.Before.Set {
+			return nil, fmt.Errorf("%s: after and before must be used together", r.Name)
+		}
+		if r.When.Product != "" {
+			if r.productAddr, err = resolveProduct(r.When.Product); err != nil {
+				return nil, fmt.Errorf("%s: %w", r.Name, err)
+			}
+		}
+		if len(r.Actions) == 0 {
+			return nil, fmt.Errorf("%s: no actions", r.Name)
+		}
+		for _, a := range r.Actions {
+			if _, ok := automationActions[a.Action]; !ok {
+				return nil, fmt.Errorf("%s: unknown action %q", r.Name, a.Action)
+			}
+			if a.Action == "join" && a.Product == "" {
+				return nil, fmt.Errorf("%s: join needs the product to join", r.Name)
+			}
+			if a.Product != "" {
+				if _, err = resolveProduct(a.Product); err != nil {
+					return nil, fmt.Errorf("%s: %w", r.Name, err)
+				}
+			}
+		}
+	}
+	return rules.Rules, nil
+}
+
+// inWindow reports whether t falls within the time window, which may
+// wrap past midnight, e.g. 22:00 to 07:00.
+func inWindow(after, before timeOfDay, t time.Time) bool {
+	if !after.Set {
+		return true
+	}
+	m := t.Hour()*60 + t.Minute()
+	if after.Minutes <= before.Minutes {
+		return m >= after.Minutes && m < before.Minutes
+	}
+	return m >= after.Minutes || m < before.Minutes
+}
+
+// productActivity is what a product is doing, as far as we can tell from
+// its notifications. Empty fields aren't known yet.
+type productActivity struct {
+	State  models.State
+	Source models.Source
+	Power  models.PowerState
+
+	sourceKnown bool // sourceKnown is set once Source has been reported, as it's empty in standby.
+}
+
+// update records anything n says about what the product is doing. A
+// SOURCE notification without a primary source means the product has
+// gone to standby.
+func (a *productActivity) update(n *models.Notification) {
+	v, err := n.Decode()
+	if err != nil {
+		return
+	}
+	switch d := v.(type) {
+	case *models.ProgressInformationData:
+		a.State = d.State
+	case *models.SourceData:
+		a.Source = d.PrimaryExperience.Source
+		a.sourceKnown = true
+		if d.Primary == "" {
+			a.Power = models.PowerStateStandby
+			a.State = models.StateIdle
+		} else {
+			a.Power = models.PowerStateOn
+			if d.PrimaryExperience.State != "" {
+				a.State = d.PrimaryExperience.State
+			}
+		}
+	case *models.SourceExperienceChangedData:
+		a.Source = d.PrimaryExperience.Source
+		a.sourceKnown = true
+	}
+}
+
+func matchSource(s models.Source, want string) bool {
+	return strings.EqualFold(s.SourceType.Type, want) ||
+		strings.EqualFold(s.FriendlyName, want) ||
+		string(s.Id) == want
+}
+
+// activityMatches reports whether a has the state, source and power
+// wanted by w.
+func (w *automationMatch) activityMatches(a productActivity) bool {
+	return (w.State == "" || w.State == a.State) &&
+		(w.Source == "" || matchSource(a.Source, w.Source)) &&
+		(w.Power == "" || w.Power == a.Power)
+}
+
+// entered reports whether the activity changed from prev into one that w
+// wants. Changes from an unknown value don't count, so that rules don't
+// fire for what products were already doing when they're first watched.
+// If w doesn't care about the activity, every notification counts.
+func (w *automationMatch) entered(prev, a productActivity) bool {
+	if !w.activityMatches(a) {
+		return false
+	}
+	if w.State == "" && w.Source == "" && w.Power == "" {
+		return true
+	}
+	return (w.State != "" && prev.State != "" && prev.State != w.State) ||
+		(w.Source != "" && prev.sourceKnown && !matchSource(prev.Source, w.Source)) ||
+		(w.Power != "" && prev.Power != "" && prev.Power != w.Power)
+}
+
+// matches reports whether n, which changed what the product is doing from
+// prev to a, fires the rule.
+func (r *automationRule) matches(p *watchedProduct, n *models.Notification, prev, a productActivity, now time.Time) bool {
+	w := r.When
+	return (w.Type == "" || strings.EqualFold(string(w.Type), string(n.Type))) &&
+		(r.productAddr == "" || r.productAddr == p.Addr) &&
+		w.entered(prev, a) &&
+		inWindow(w.After, w.Before, now)
+}
+
+// firedKey identifies a rule firing for a product, for debouncing.
+type firedKey struct {
+	rule int
+	addr string
+}
+
+type automation struct {
+	rules  []*automationRule
+	dryRun bool
+	wg     sync.WaitGroup // wg tracks the rules being run.
+
+	mu       sync.Mutex
+	activity map[string]productActivity
+	fired    map[firedKey]time.Time
+}
+
+func newAutomation(rules []*automationRule, dryRun bool) *automation {
+	return &automation{
+		rules:    rules,
+		dryRun:   dryRun,
+		activity: make(map[string]productActivity),
+		fired:    make(map[firedKey]time.Time),
+	}
+}
+
+// handle runs the rules matching n, which was received at now.
+func (au *automation) handle(ctx context.Context, p *watchedProduct, n *models.Notification, now time.Time) {
+	au.mu.Lock()
+	prev := au.activity[p.Addr]
+	a := prev
+	a.update(n)
+	au.activity[p.Addr] = a
+	var matched []*automationRule
+	for i, r := range au.rules {
+		if !r.matches(p, n, prev, a, now) {
+			continue
+		}
+		key := firedKey{rule: i, addr: p.Addr}
+		if last, ok := au.fired[key]; ok && now.Sub(last) < time.Duration(r.Debounce) {
+			continue
+		}
+		au.fired[key] = now
+		matched = append(matched, r)
+	}
+	au.mu.Unlock()
+	for _, r := range matched {
+		log.Printf("%s: %s matched %s", p.Name, r.Name, n.Type)
+		au.wg.Add(1)
+		go au.run(ctx, p, r)
+	}
+}
+
+func (au *automation) run(ctx context.Context, p *watchedProduct, r *automationRule) {
+	defer au.wg.Done()
+	for _, a := range r.Actions {
+		target := p.Addr
+		if a.Product != "" {
+			target = a.Product
+		}
+		desc := strings.TrimSpace(a.Action + " " + a.Value)
+		if au.dryRun {
+			log.Printf("%s: would run %s on %s", r.Name, desc, target)
+			continue
+		}
+		br, err := getClient(target)
+		if err == nil {
+			actx, cancel := context.WithTimeout(ctx, defaultProductTimeout)
+			err = automationActions[a.Action](actx, br, p.Client, a.Value)
+			cancel()
+		}
+		if err != nil {
+			log.Printf("%s: %s on %s failed: %v", r.Name, desc, target, err)
+			return
+		}
+		log.Printf("%s: ran %s on %s", r.Name, desc, target)
+	}
+}
+
+func doAutomate(c *cli.Context) error {
+	if c.Args().Len() > 1 {
+		cli.ShowSubcommandHelpAndExit(c, 1)
+	}
+	path, err := getRulesPath(c)
+	if err != nil {
+		return err
+	}
+	rules, err := loadAutomationRules(path)
+	if err != nil {
+		return err
+	}
+	products, err := getWatchedProducts(c.StringSlice("product"))
+	if err != nil {
+		return err
+	}
+	log.Printf("Loaded %d rules from %s, watching %d products", len(rules), path, len(products))
+	au := newAutomation(rules, c.Bool("dry-run"))
+	watchProducts(c.Context, products, func(p *watchedProduct, n *models.Notification) {
+		au.handle(c.Context, p, n, time.Now())
+	})
+	au.wg.Wait()
+	return nil
+}
@@ -0,0 +1,282 @@
+// Copyright (c) 2020-2024 Andrew Stormont
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"beoutil/clients/beoremote/models"
+)
+
+func testNotification(typ models.NotificationType, data string) *models.Notification {
+	return &models.Notification{Type: typ, Data: json.RawMessage(data)}
+}
+
+func progressNotification(state models.State) *models.Notification {
+	return testNotification(models.NotificationTypeProgressInformation, `{"state": "`+string(state)+`"}`)
+}
+
+// sourceNotification returns a SOURCE notification for sourceType, or
+// one for standby if sourceType is empty.
+func sourceNotification(sourceType string) *models.Notification {
+	if sourceType == "" {
+		return testNotification(models.NotificationTypeSource, `{"primary": "", "primaryExperience": {}}`)
+	}
+	return testNotification(models.NotificationTypeSource, `{"primary": "`+sourceType+`:1.2.3", "primaryExperience":
+		{"source": {"id": "`+sourceType+`:1.2.3", "sourceType": {"type": "`+sourceType+`"}}, "state": "play"}}`)
+}
+
+func testTime(hhmm string) time.Time {
+	t, err := time.Parse("2006-01-02 15:04", "2024-03-01 "+hhmm)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func testTimeOfDay(hhmm string) timeOfDay {
+	t := testTime(hhmm)
+	return timeOfDay{Set: true, Minutes: t.Hour()*60 + t.Minute()}
+}
+
+func TestInWindow(t *testing.T) {
+	tests := []struct {
+		after, before, now string
+		want               bool
+	}{
+		{"", "", "12:00", true},
+		{"09:00", "17:00", "08:59", false},
+		{"09:00", "17:00", "09:00", true},
+		{"09:00", "17:00", "16:59", true},
+		{"09:00", "17:00", "17:00", false},
+		{"22:00", "07:00", "21:59", false},
+		{"22:00", "07:00", "22:00", true},
+		{"22:00", "07:00", "23:59", true},
+		{"22:00", "07:00", "00:00", true},
+		{"22:00", "07:00", "06:59", true},
+		{"22:00", "07:00", "07:00", false},
+		{"22:00", "07:00", "12:00", false},
+		{"00:00", "00:00", "12:00", false},
+	}
+	for _, tt := range tests {
+		var after, before timeOfDay
+		if tt.after != "" {
+			after, before = testTimeOfDay(tt.after), testTimeOfDay(tt.before)
+		}
+		if got := inWindow(after, before, testTime(tt.now)); got != tt.want {
+			t.Errorf("inWindow(%q, %q, %s) = %t, want %t", tt.after, tt.before, tt.now, got, tt.want)
+		}
+	}
+}
+
+func TestProductActivityUpdate(t *testing.T) {
+	var a productActivity
+	a.update(progressNotification(models.StatePlay))
+	if a.State != models.StatePlay || a.Power != "" || a.sourceKnown {
+		t.Errorf("after progress: %+v", a)
+	}
+	a.update(sourceNotification("DEEZER"))
+	if a.Power != models.PowerStateOn || a.Source.SourceType.Type != "DEEZER" || !a.sourceKnown {
+		t.Errorf("after source: %+v", a)
+	}
+	a.update(sourceNotification(""))
+	if a.Power != models.PowerStateStandby || a.State != models.StateIdle || a.Source.Id != "" {
+		t.Errorf("after standby: %+v", a)
+	}
+	a.update(testNotification(models.NotificationTypeProgressInformation, "{"))
+	if a.State != models.StateIdle {
+		t.Errorf("invalid notification changed state to %q", a.State)
+	}
+}
+
+func TestAutomationRuleMatches(t *testing.T) {
+	kitchen := &watchedProduct{Addr: "192.0.2.1", Name: "Kitchen"}
+	lounge := &watchedProduct{Addr: "192.0.2.2", Name: "Lounge"}
+	deezer := models.Source{Id: "deezer:1.2.3", FriendlyName: "Deezer", SourceType: models.SourceType{Type: "DEEZER"}}
+	radio := models.Source{Id: "radio:1.2.3", FriendlyName: "TuneIn", SourceType: models.SourceType{Type: "RADIO"}}
+	on := productActivity{State: models.StatePause, Source: deezer, Power: models.PowerStateOn, sourceKnown: true}
+	playing := on
+	playing.State = models.StatePlay
+	onRadio := playing
+	onRadio.Source = radio
+	standby := productActivity{State: models.StateIdle, Power: models.PowerStateStandby, sourceKnown: true}
+	tests := []struct {
+		name        string
+		when        automationMatch
+		productAddr string
+		p           *watchedProduct
+		n           *models.Notification
+		prev, a     productActivity
+		now         string
+		want        bool
+	}{
+		{"any", automationMatch{}, "", kitchen, progressNotification(models.StatePlay), playing, playing, "12:00", true},
+		{"type", automationMatch{Type: "volume"}, "", kitchen, testNotification(models.NotificationTypeVolume, "{}"), on, on, "12:00", true},
+		{"other type", automationMatch{Type: "VOLUME"}, "", kitchen, progressNotification(models.StatePlay), on, on, "12:00", false},
+		{"product", automationMatch{}, kitchen.Addr, kitchen, progressNotification(models.StatePlay), on, on, "12:00", true},
+		{"other product", automationMatch{}, kitchen.Addr, lounge, progressNotification(models.StatePlay), on, on, "12:00", false},
+		{"starts playing", automationMatch{State: models.StatePlay}, "", kitchen, progressNotification(models.StatePlay), on, playing, "12:00", true},
+		{"still playing", automationMatch{State: models.StatePlay}, "", kitchen, progressNotification(models.StatePlay), playing, playing, "12:00", false},
+		{"stops playing", automationMatch{State: models.StatePlay}, "", kitchen, progressNotification(models.StatePause), playing, on, "12:00", false},
+		{"state unknown", automationMatch{State: models.StatePlay}, "", kitchen, progressNotification(models.StatePlay), productActivity{}, playing, "12:00", false},
+		{"type and state", automationMatch{Type: "PROGRESS_INFORMATION", State: models.StatePlay}, "", kitchen, progressNotification(models.StatePlay), on, playing, "12:00", true},
+		{"source changes", automationMatch{Source: "deezer"}, "", kitchen, sourceNotification("DEEZER"), onRadio, playing, "12:00", true},
+		{"source by name", automationMatch{Source: "Deezer"}, "", kitchen, sourceNotification("DEEZER"), onRadio, playing, "12:00", true},
+		{"source unchanged", automationMatch{Source: "DEEZER"}, "", kitchen, progressNotification(models.StatePlay), on, playing, "12:00", false},
+		{"source from standby", automationMatch{Source: "DEEZER"}, "", kitchen, sourceNotification("DEEZER"), standby, playing, "12:00", true},
+		{"source unknown", automationMatch{Source: "DEEZER"}, "", kitchen, sourceNotification("DEEZER"), productActivity{State: models.StatePlay}, playing, "12:00", false},
+		{"source and state", automationMatch{Source: "DEEZER", State: models.StatePlay}, "", kitchen, progressNotification(models.StatePlay), on, playing, "12:00", true},
+		{"source and wrong state", automationMatch{Source: "DEEZER", State: models.StatePlay}, "", kitchen, sourceNotification("DEEZER"), onRadio, on, "12:00", false},
+		{"goes to standby", automationMatch{Power: models.PowerStateStandby}, "", kitchen, sourceNotification(""), playing, standby, "23:30", true},
+		{"still in standby", automationMatch{Power: models.PowerStateStandby}, "", kitchen, sourceNotification(""), standby, standby, "23:30", false},
+		{"standby unknown", automationMatch{Power: models.PowerStateStandby}, "", kitchen, sourceNotification(""), productActivity{}, standby, "23:30", false},
+		{"turns on", automationMatch{Power: models.PowerStateOn}, "", kitchen, sourceNotification("DEEZER"), standby, playing, "12:00", true},
+		{"standby in window", automationMatch{Power: models.PowerStateStandby, After: testTimeOfDay("23:00"), Before: testTimeOfDay("05:00")}, "", kitchen, sourceNotification(""), playing, standby, "01:00", true},
+		{"standby outside window", automationMatch{Power: models.PowerStateStandby, After: testTimeOfDay("23:00"), Before: testTimeOfDay("05:00")}, "", kitchen, sourceNotification(""), playing, standby, "22:59", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &automationRule{When: tt.when, productAddr: tt.productAddr}
+			if got := r.matches(tt.p, tt.n, tt.prev, tt.a, testTime(tt.now)); got != tt.want {
+				t.Errorf("matches() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAutomationHandle(t *testing.T) {
+	kitchen := &watchedProduct{Addr: "192.0.2.1", Name: "Kitchen"}
+	rules := []*automationRule{
+		{Name: "same", When: automationMatch{State: models.StatePlay}, Actions: []automationAction{{Action: "pause"}},
+			Debounce: duration(time.Minute)},
+		{Name: "same", When: automationMatch{State: models.StatePlay}, Actions: []automationAction{{Action: "stop"}}},
+	}
+	au := newAutomation(rules, true)
+	start := testTime("12:00")
+	steps := []struct {
+		n             *models.Notification
+		at            time.Duration
+		first, second bool // first and second are whether each rule fires.
+	}{
+		{progressNotification(models.StatePause), 0, false, false},
+		{progressNotification(models.StatePlay), time.Second, true, true},
+		{progressNotification(models.StatePlay), 2 * time.Second, false, false},
+		{progressNotification(models.StatePause), 10 * time.Second, false, false},
+		{progressNotification(models.StatePlay), 20 * time.Second, false, true},
+		{progressNotification(models.StatePause), 2 * time.Minute, false, false},
+		{progressNotification(models.StatePlay), 3 * time.Minute, true, true},
+	}
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+	for i, s := range steps {
+		now := start.Add(s.at)
+		au.handle(context.Background(), kitchen, s.n, now)
+		au.wg.Wait()
+		for rule, want := range []bool{s.first, s.second} {
+			fired := au.fired[firedKey{rule: rule, addr: kitchen.Addr}].Equal(now)
+			if fired != want {
+				t.Errorf("step %d: rule %d fired = %t, want %t", i, rule, fired, want)
+			}
+		}
+	}
+	if got := strings.Count(logged.String(), "would run stop"); got != 3 {
+		t.Errorf("stop ran %d times, want 3", got)
+	}
+}
+
+func TestLoadAutomationRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   string
+		wantErr string
+	}{
+		{"valid", `{"rules": [{"name": "Night", "when": {"power": "standby", "after": "23:00", "before": "05:00",
+			"product": "192.0.2.1"}, "actions": [{"action": "all-standby"}], "debounce": "1m"}]}`, ""},
+		{"join", `{"rules": [{"when": {"state": "play"}, "actions": [{"action": "join", "product": "192.0.2.2", "value": "20"}]}]}`, ""},
+		{"invalid json", `{"rules": [`, "invalid rules file"},
+		{"invalid time", `{"rules": [{"when": {"after": "25:00", "before": "05:00"}, "actions": [{"action": "stop"}]}]}`, "invalid time of day"},
+		{"invalid debounce", `{"rules": [{"actions": [{"action": "stop"}], "debounce": "soon"}]}`, "invalid rules file"},
+		{"after only", `{"rules": [{"when": {"after": "23:00"}, "actions": [{"action": "stop"}]}]}`, "rule 1: after and before"},
+		{"invalid power", `{"rules": [{"when": {"power": "off"}, "actions": [{"action": "stop"}]}]}`, "rule 1: power must be"},
+		{"no actions", `{"rules": [{"name": "Empty", "when": {"state": "play"}}]}`, "Empty: no actions"},
+		{"unknown action", `{"rules": [{"actions": [{"action": "stop"}]}, {"actions": [{"action": "explode"}]}]}`, `rule 2: unknown action "explode"`},
+		{"join without product", `{"rules": [{"actions": [{"action": "join"}]}]}`, "rule 1: join needs"},
+	}
+	dir := t.TempDir()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, "automate.json")
+			if err := os.WriteFile(path, []byte(tt.rules), 0644); err != nil {
+				t.Fatal(err)
+			}
+			rules, err := loadAutomationRules(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("loadAutomationRules() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rules) != 1 {
+				t.Fatalf("got %d rules, want 1", len(rules))
+			}
+		})
+	}
+}
+
+func TestLoadAutomationRulesFields(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "automate.json")
+	b := []byte(`{"rules": [{"when": {"power": "standby", "after": "23:00", "before": "05:30", "product": "192.0.2.1"},
+		"actions": [{"action": "all-standby"}], "debounce": "90s"}]}`)
+	if err := os.WriteFile(path, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	rules, err := loadAutomationRules(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := rules[0]
+	if r.Name != "rule 1" {
+		t.Errorf("Name = %q, want %q", r.Name, "rule 1")
+	}
+	if r.productAddr != "192.0.2.1" {
+		t.Errorf("productAddr = %q, want %q", r.productAddr, "192.0.2.1")
+	}
+	if r.When.Power != models.PowerStateStandby {
+		t.Errorf("Power = %q, want %q", r.When.Power, models.PowerStateStandby)
+	}
+	if r.When.After.Minutes != 23*60 || r.When.Before.Minutes != 5*60+30 {
+		t.Errorf("window = %d-%d minutes, want %d-%d", r.When.After.Minutes, r.When.Before.Minutes, 23*60, 5*60+30)
+	}
+	if time.Duration(r.Debounce) != 90*time.Second {
+		t.Errorf("Debounce = %s, want 90s", time.Duration(r.Debounce))
+	}
+}
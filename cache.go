@@ -0,0 +1,90 @@
+// Copyright (c) 2020-2024 Andrew Stormont
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"beoutil/clients/deezer"
+
+	"github.com/urfave/cli/v2"
+)
+
+// getDeezerCacheDir returns $XDG_CACHE_HOME/beoutil/deezer, or the
+// platform equivalent.
+func getDeezerCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get user cache dir: %w", err)
+	}
+	return filepath.Join(dir, "beoutil", "deezer"), nil
+}
+
+// getDeezerClient returns a Deezer client that caches responses on disk,
+// unless --no-cache is given.
+func getDeezerClient(c *cli.Context) *deezer.Client {
+	opts := &deezer.ClientOptions{TTL: c.Duration("cache-ttl")}
+	if !c.Bool("no-cache") {
+		// Without a cache dir we can still talk to Deezer, just more slowly.
+		opts.CacheDir, _ = getDeezerCacheDir()
+	}
+	return deezer.NewClientWithOptions(opts)
+}
+
+func doCacheStats(_ *cli.Context) error {
+	dir, err := getDeezerCacheDir()
+	if err != nil {
+		return err
+	}
+	stats, err := deezer.GetCacheStats(dir)
+	if err != nil {
+		return err
+	}
+	formatTime := func(t time.Time) string {
+		if t.IsZero() {
+			return "-"
+		}
+		return t.Local().Format("2006-01-02 15:04:05")
+	}
+	tw := new(tabwriter.Writer)
+	tw.Init(os.Stdout, 8, 4, 1, ' ', 0)
+	_, _ = fmt.Fprintf(tw, "Directory:\t%s\n", dir)
+	_, _ = fmt.Fprintf(tw, "Entries:\t%d (%d fresh)\n", stats.Entries, stats.Fresh)
+	_, _ = fmt.Fprintf(tw, "Size:\t%.1f KiB\n", float64(stats.Size)/1024)
+	_, _ = fmt.Fprintf(tw, "Oldest:\t%s\n", formatTime(stats.Oldest))
+	_, _ = fmt.Fprintf(tw, "Newest:\t%s\n", formatTime(stats.Newest))
+	_, _ = fmt.Fprintf(tw, "Hits:\t%d\n", stats.Hits)
+	_, _ = fmt.Fprintf(tw, "Revalidated:\t%d\n", stats.Revalidated)
+	_, _ = fmt.Fprintf(tw, "Misses:\t%d\n", stats.Misses)
+	return tw.Flush()
+}
+
+func doCacheClear(_ *cli.Context) error {
+	dir, err := getDeezerCacheDir()
+	if err != nil {
+		return err
+	}
+	return deezer.ClearCache(dir)
+}
@@ -0,0 +1,68 @@
+// Copyright (c) 2020-2024 Andrew Stormont
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package beoremote
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"beoutil/clients/beoremote/models"
+	"beoutil/clients/rest"
+)
+
+type BeoContent interface {
+	GetStations(ctx context.Context, offset, count int) (*models.StationList, error)
+	SearchStations(ctx context.Context, query string, offset, count int) (*models.StationList, error)
+	GetStation(ctx context.Context, id string) (*models.Station, error)
+}
+
+type beoContent struct {
+	client  rest.Client
+	baseURL string
+}
+
+func (b *beoContent) getStationList(ctx context.Context, endPoint string, offset, count int) (*models.StationList, error) {
+	var r models.StationListResponse
+	endPoint += fmt.Sprintf("offset=%d&count=%d", offset, count)
+	if err := b.client.DoGet(ctx, b.baseURL+endPoint, &r); err != nil {
+		return nil, err
+	}
+	return &r.StationList, nil
+}
+
+func (b *beoContent) GetStations(ctx context.Context, offset, count int) (*models.StationList, error) {
+	return b.getStationList(ctx, "/BeoContent/radio/netRadioProfile/stationList?", offset, count)
+}
+
+func (b *beoContent) SearchStations(ctx context.Context, query string, offset, count int) (*models.StationList, error) {
+	endPoint := "/BeoContent/radio/netRadioProfile/search?q=" + url.QueryEscape(query) + "&"
+	return b.getStationList(ctx, endPoint, offset, count)
+}
+
+func (b *beoContent) GetStation(ctx context.Context, id string) (*models.Station, error) {
+	var r models.StationResponse
+	endPoint := "/BeoContent/radio/netRadioProfile/station/" + url.PathEscape(id)
+	if err := b.client.DoGet(ctx, b.baseURL+endPoint, &r); err != nil {
+		return nil, err
+	}
+	return &r.Station, nil
+}
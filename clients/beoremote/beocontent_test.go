@@ -0,0 +1,106 @@
+// Copyright (c) 2020-2024 Andrew Stormont
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package beoremote
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"beoutil/clients/rest"
+)
+
+const testStationList = `{"stationList": {"offset": 10, "count": 2, "total": 42, "station": [
+	{"id": "s24861", "name": "BBC Radio 4", "beoradio": {"stationId": "s24861"},
+	 "image": [{"url": "http://example.com/bbc4.png", "size": "medium", "mediatype": "image/png"}]},
+	{"id": "s17077", "name": "Radio Paradise", "beoradio": {}}
+]}}`
+
+const testStation = `{"station": {"id": "s24861", "name": "BBC Radio 4", "beoradio": {"stationId": "s24861"}}}`
+
+func newTestBeoContent(t *testing.T, h http.HandlerFunc) *beoContent {
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	return &beoContent{client: &errorClient{rest.NewJSONClient()}, baseURL: srv.URL}
+}
+
+func TestGetStations(t *testing.T) {
+	var got string
+	b := newTestBeoContent(t, func(w http.ResponseWriter, r *http.Request) {
+		got = r.URL.RequestURI()
+		_, _ = w.Write([]byte(testStationList))
+	})
+	l, err := b.GetStations(context.Background(), 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "/BeoContent/radio/netRadioProfile/stationList?offset=10&count=2"; got != want {
+		t.Errorf("request = %q, want %q", got, want)
+	}
+	if l.Offset != 10 || l.Count != 2 || l.Total != 42 {
+		t.Errorf("offset, count, total = %d, %d, %d, want 10, 2, 42", l.Offset, l.Count, l.Total)
+	}
+	if len(l.Station) != 2 {
+		t.Fatalf("got %d stations, want 2", len(l.Station))
+	}
+	s := l.Station[0]
+	if s.Id != "s24861" || s.Name != "BBC Radio 4" || s.BeoRadio.StationId != "s24861" {
+		t.Errorf("station = %+v", s)
+	}
+	if len(s.Image) != 1 || s.Image[0].URL != "http://example.com/bbc4.png" {
+		t.Errorf("image = %+v", s.Image)
+	}
+	if l.Station[1].BeoRadio.StationId != "" {
+		t.Errorf("stationId = %q, want empty", l.Station[1].BeoRadio.StationId)
+	}
+}
+
+func TestSearchStations(t *testing.T) {
+	var got string
+	b := newTestBeoContent(t, func(w http.ResponseWriter, r *http.Request) {
+		got = r.URL.RequestURI()
+		_, _ = w.Write([]byte(testStationList))
+	})
+	if _, err := b.SearchStations(context.Background(), "radio 4&more", 0, 20); err != nil {
+		t.Fatal(err)
+	}
+	if want := "/BeoContent/radio/netRadioProfile/search?q=radio+4%26more&offset=0&count=20"; got != want {
+		t.Errorf("request = %q, want %q", got, want)
+	}
+}
+
+func TestGetStation(t *testing.T) {
+	var got string
+	b := newTestBeoContent(t, func(w http.ResponseWriter, r *http.Request) {
+		got = r.URL.EscapedPath()
+		_, _ = w.Write([]byte(testStation))
+	})
+	s, err := b.GetStation(context.Background(), "s24861/x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "/BeoContent/radio/netRadioProfile/station/s24861%2Fx"; got != want {
+		t.Errorf("request = %q, want %q", got, want)
+	}
+	if s.Id != "s24861" || s.Name != "BBC Radio 4" || s.BeoRadio.StationId != "s24861" {
+		t.Errorf("station = %+v", s)
+	}
+}
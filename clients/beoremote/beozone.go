@@ -22,8 +22,12 @@ package beoremote
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/url"
+	"strings"
 
 	"beoutil/clients/beoremote/models"
 	"beoutil/clients/rest"
@@ -34,8 +38,54 @@ type When string
 const (
 	Now  When = "now"
 	Next When = "next"
+	Last When = "last"
 )
 
+// Position is where items are added to a play queue, which is either
+// relative to the item playing, or before or after another item.
+type Position struct {
+	When   When   // When is ignored if Anchor is set.
+	Anchor string // Anchor is the ID of the queue item to insert next to.
+	After  bool   // After inserts after the anchor rather than before it.
+}
+
+// At returns the position to add items when to play them.
+func At(when When) Position {
+	return Position{When: when}
+}
+
+// Before returns the position before the queue item with the given ID.
+func Before(id string) Position {
+	return Position{Anchor: strings.TrimPrefix(id, "plid-")}
+}
+
+// After returns the position after the queue item with the given ID.
+func After(id string) Position {
+	return Position{Anchor: strings.TrimPrefix(id, "plid-"), After: true}
+}
+
+// query returns the query string that adds items at p.
+func (p Position) query() string {
+	switch {
+	case p.Anchor != "" && p.After:
+		return "?id=plid-" + p.Anchor + "&insert=after"
+	case p.Anchor != "":
+		return "?id=plid-" + p.Anchor
+	case p.When == Now:
+		return "?instantplay"
+	case p.When == Next:
+		return "?id=&insert=after"
+	}
+	return ""
+}
+
+// ErrSeekNotSupported is returned by Seek when the source that's playing
+// doesn't support seeking. It matches ErrNotSupported.
+var ErrSeekNotSupported error = &Error{
+	Kind: ErrNotSupported,
+	Err:  errors.New("seeking is not supported by the current source"),
+}
+
 type BeoZone interface {
 	Play(ctx context.Context) error
 	Pause(ctx context.Context) error
@@ -51,10 +101,12 @@ type BeoZone interface {
 	GetPlayQueue(ctx context.Context, offset, count int) (*models.PlayQueue, error)
 	ClearPlayQueue(ctx context.Context) error
 	RemoveQueueItem(ctx context.Context, id string) error
-	AddQueueItem(ctx context.Context, qi models.PlayQueueItem, play When) error
-	AddDeezerTracks(ctx context.Context, qi []models.PlayQueueItem, play When) error
+	AddQueueItem(ctx context.Context, qi models.PlayQueueItem, pos Position) error
+	AddDeezerTracks(ctx context.Context, qi []models.PlayQueueItem, pos Position) error
 	MoveQueueItem(ctx context.Context, id, bid string) error
 	PlayQueueItem(ctx context.Context, id string) error
+	SetPlayPointer(ctx context.Context, id string, position int) error
+	Seek(ctx context.Context, offset int, whence int) error
 	SetQueueRepeat(ctx context.Context, repeat models.Repeat) error
 	SetQueueRandom(ctx context.Context, random models.Random) error
 	GetActiveSources(ctx context.Context) (*models.ActiveSourcesResponse, error)
@@ -64,6 +116,8 @@ type BeoZone interface {
 	EndExperience(ctx context.Context) error
 	GetSystemProducts(ctx context.Context) ([]models.Product, error)
 	OpenNotificationStream(ctx context.Context) (<-chan rest.Event, error)
+	WaitForNotification(ctx context.Context, types ...models.NotificationType) (*models.Notification, error)
+	GetProgress(ctx context.Context) (*models.ProgressInformationData, error)
 }
 
 type beoZone struct {
@@ -185,15 +239,46 @@ func (z *beoZone) MoveQueueItem(ctx context.Context, id, bid string) error {
 }
 
 func (z *beoZone) PlayQueueItem(ctx context.Context, id string) error {
+	return z.SetPlayPointer(ctx, id, 0)
+}
+
+func (z *beoZone) SetPlayPointer(ctx context.Context, id string, position int) error {
 	r := models.PlayPointerRequest{
 		PlayPointer: models.PlayPointer{
 			PlayQueueItemId: "plid-" + id,
+			Position:        position,
 		},
 	}
 	_, err := z.client.DoPost(ctx, z.baseURL+"/BeoZone/Zone/PlayQueue/PlayPointer", r)
 	return err
 }
 
+// Seek moves the play position within the current track to offset seconds,
+// interpreted according to whence as with io.Seeker.
+func (z *beoZone) Seek(ctx context.Context, offset int, whence int) error {
+	p, err := z.GetProgress(ctx)
+	if err != nil {
+		return err
+	}
+	if !p.SeekSupported {
+		return ErrSeekNotSupported
+	}
+	position := offset
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		position += p.Position
+	case io.SeekEnd:
+		position += p.TotalDuration
+	default:
+		return errors.New("invalid whence")
+	}
+	if position < 0 || (p.TotalDuration > 0 && position > p.TotalDuration) {
+		return fmt.Errorf("position %ds is outside of track (duration %ds)", position, p.TotalDuration)
+	}
+	return z.SetPlayPointer(ctx, strings.TrimPrefix(string(p.PlayQueueItemID), "plid-"), position)
+}
+
 func (z *beoZone) SetQueueRepeat(ctx context.Context, repeat models.Repeat) error {
 	return z.setPlayQueue(ctx, &models.PlayQueue{Repeat: repeat})
 }
@@ -202,14 +287,11 @@ func (z *beoZone) SetQueueRandom(ctx context.Context, random models.Random) erro
 	return z.setPlayQueue(ctx, &models.PlayQueue{Random: random})
 }
 
-func (z *beoZone) AddQueueItem(ctx context.Context, qi models.PlayQueueItem, play When) error {
-	endpoint := "/BeoZone/Zone/PlayQueue/"
-	switch play {
-	case Now:
-		endpoint += "?instantplay"
-	case Next:
-		endpoint += "?id=&insert=after"
+func (z *beoZone) AddQueueItem(ctx context.Context, qi models.PlayQueueItem, pos Position) error {
+	if qi.Track == nil && qi.Station == nil {
+		return ErrInvalidQueueItem
 	}
+	endpoint := "/BeoZone/Zone/PlayQueue/" + pos.query()
 	r := models.PlayQueueItemRequest{
 		PlayQueueItem: qi,
 	}
@@ -217,17 +299,16 @@ func (z *beoZone) AddQueueItem(ctx context.Context, qi models.PlayQueueItem, pla
 	return err
 }
 
-func (z *beoZone) AddDeezerTracks(ctx context.Context, qi []models.PlayQueueItem, play When) error {
-	endpoint := "/BeoZone/Zone/PlayQueue/"
-	// Note: it's possible to insert a track of playlist in the queue
-	// before another queue item by specifying the id parameter, but this
-	// isn't something we expose right now.
-	switch play {
-	case Now:
-		endpoint += "?instantplay"
-	case Next:
-		endpoint += "?id=&insert=after"
+func (z *beoZone) AddDeezerTracks(ctx context.Context, qi []models.PlayQueueItem, pos Position) error {
+	for _, i := range qi {
+		if i.Track == nil || i.Track.Deezer == nil {
+			return ErrInvalidQueueItem
+		}
 	}
+	if len(qi) == 0 {
+		return ErrInvalidQueueItem
+	}
+	endpoint := "/BeoZone/Zone/PlayQueue/" + pos.query()
 	r := models.PlayQueue{
 		PlayQueueItem: qi,
 		Container: models.Container{
@@ -294,3 +375,45 @@ func (z *beoZone) GetSystemProducts(ctx context.Context) ([]models.Product, erro
 func (z *beoZone) OpenNotificationStream(ctx context.Context) (<-chan rest.Event, error) {
 	return z.client.OpenEventStream(ctx, z.baseURL+"/BeoNotify/Notifications")
 }
+
+// WaitForNotification returns the first notification of one of the given
+// types. Products send their current state when a stream is opened, so this
+// can also be used to query state that isn't available via a GET request.
+func (z *beoZone) WaitForNotification(ctx context.Context, types ...models.NotificationType) (*models.Notification, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	events, err := z.OpenNotificationStream(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for event := range events {
+		if event.Err != nil {
+			return nil, mapError(event.Err)
+		}
+		var n models.NotificationWrapper
+		if err = json.Unmarshal(event.Value, &n); err != nil {
+			return nil, err
+		}
+		for _, t := range types {
+			if n.Notification.Type == t {
+				return &n.Notification, nil
+			}
+		}
+	}
+	if err = ctx.Err(); err != nil {
+		return nil, mapError(err)
+	}
+	return nil, io.EOF
+}
+
+func (z *beoZone) GetProgress(ctx context.Context) (*models.ProgressInformationData, error) {
+	n, err := z.WaitForNotification(ctx, models.NotificationTypeProgressInformation)
+	if err != nil {
+		return nil, err
+	}
+	var d models.ProgressInformationData
+	if err = json.Unmarshal(n.Data, &d); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
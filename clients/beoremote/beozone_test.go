@@ -0,0 +1,69 @@
+// Copyright (c) 2020-2024 Andrew Stormont
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package beoremote
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"beoutil/clients/beoremote/models"
+	"beoutil/clients/rest"
+)
+
+func TestPositionQuery(t *testing.T) {
+	tests := []struct {
+		name string
+		pos  Position
+		want string
+	}{
+		{"now", At(Now), "?instantplay"},
+		{"next", At(Next), "?id=&insert=after"},
+		{"last", At(Last), ""},
+		{"zero", Position{}, ""},
+		{"before", Before("1234.5678"), "?id=plid-1234.5678"},
+		{"before plid", Before("plid-1234.5678"), "?id=plid-1234.5678"},
+		{"after", After("1234.5678"), "?id=plid-1234.5678&insert=after"},
+		{"after plid", After("plid-1234.5678"), "?id=plid-1234.5678&insert=after"},
+		{"anchor wins", Position{When: Now, Anchor: "1", After: true}, "?id=plid-1&insert=after"},
+	}
+	for _, tt := range tests {
+		if got := tt.pos.query(); got != tt.want {
+			t.Errorf("%s: query() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAddQueueItemPosition(t *testing.T) {
+	var got string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.URL.RequestURI()
+	}))
+	defer srv.Close()
+	z := &beoZone{client: &errorClient{rest.NewJSONClient()}, baseURL: srv.URL}
+	qi := models.PlayQueueItem{Track: &models.Track{Name: "Schism"}}
+	if err := z.AddQueueItem(context.Background(), qi, After("plid-42")); err != nil {
+		t.Fatal(err)
+	}
+	if want := "/BeoZone/Zone/PlayQueue/?id=plid-42&insert=after"; got != want {
+		t.Errorf("request = %q, want %q", got, want)
+	}
+}
@@ -29,18 +29,21 @@ import (
 
 type Client struct {
 	client      rest.Client
+	addr        string
 	baseURL     string
 	BeoZone     BeoZone
 	BeoDevice   BeoDevice
 	BeoSecurity *BeoSecurity
 	BeoHome     BeoHome
+	BeoContent  BeoContent
 }
 
 func NewClient(addr string) *Client {
-	c := rest.NewJSONClient()
+	c := &errorClient{rest.NewJSONClient()}
 	baseURL := "http://" + addr + ":8080"
 	return &Client{
 		client:  c,
+		addr:    addr,
 		baseURL: baseURL,
 		BeoDevice: &beoDevice{
 			client:  c,
@@ -55,12 +58,21 @@ func NewClient(addr string) *Client {
 			client:  c,
 			baseURL: baseURL,
 		},
+		BeoContent: &beoContent{
+			client:  c,
+			baseURL: baseURL,
+		},
 	}
 }
 
+// Addr returns the address the client was created with.
+func (l *Client) Addr() string {
+	return l.addr
+}
+
 func (l *Client) GetBeoDevice(ctx context.Context) (*models.BeoDeviceInfo, error) {
 	var r models.BeoDeviceResponse
-	err := l.client.DoGet(ctx, "/BeoDevice", &r)
+	err := l.client.DoGet(ctx, l.baseURL+"/BeoDevice", &r)
 	if err != nil {
 		return nil, err
 	}
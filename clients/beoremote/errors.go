@@ -0,0 +1,183 @@
+// Copyright (c) 2020-2024 Andrew Stormont
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package beoremote
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	"beoutil/clients/beoremote/models"
+	"beoutil/clients/rest"
+)
+
+// Errors returned by the client are mapped to one of these where possible,
+// so callers can use errors.Is to find out why a request failed.
+var (
+	ErrUnreachable      = errors.New("product unreachable")
+	ErrStandby          = errors.New("product is in standby")
+	ErrNotSupported     = errors.New("not supported by product")
+	ErrInvalidQueueItem = errors.New("invalid queue item")
+	ErrNotLinkable      = errors.New("source is not linkable")
+	ErrTimeout          = errors.New("timed out")
+	ErrSourceNotFound   = errors.New("source not found")
+)
+
+// Error is returned when an error has been mapped to one of the sentinel
+// errors above. Err is the error returned by the product or network.
+type Error struct {
+	Kind error
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Kind.Error() + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (e *Error) Is(target error) bool {
+	return target == e.Kind
+}
+
+// errorTypeKinds maps the error types reported by products.
+var errorTypeKinds = map[string]error{
+	"PRODUCT_IN_STANDBY":  ErrStandby,
+	"STANDBY":             ErrStandby,
+	"SOURCE_NOT_LINKABLE": ErrNotLinkable,
+	"NOT_LINKABLE":        ErrNotLinkable,
+	"NOT_SUPPORTED":       ErrNotSupported,
+	"NOT_IMPLEMENTED":     ErrNotSupported,
+	"INVALID_QUEUE_ITEM":  ErrInvalidQueueItem,
+	"INVALID_TRACK":       ErrInvalidQueueItem,
+}
+
+// kindFromErrorType maps the type of error reported by a product, or
+// failing that the status of the response it came in.
+func kindFromErrorType(e *models.Error) error {
+	if kind, ok := errorTypeKinds[strings.ToUpper(e.Type)]; ok {
+		return kind
+	}
+	return kindFromStatus(e.StatusCode, e.Path)
+}
+
+// itemCollections are the endpoints whose members are addressed by ID.
+var itemCollections = []string{
+	"/BeoZone/Zone/PlayQueue/plid-",
+	"/BeoContent/radio/netRadioProfile/station/",
+	"/BeoHome/trigger/timerList/",
+}
+
+// isItemPath reports whether path addresses a single item of a collection,
+// where a 404 means the item doesn't exist rather than the endpoint.
+func isItemPath(path string) bool {
+	for _, prefix := range itemCollections {
+		if strings.HasPrefix(path, prefix) && len(path) > len(prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// kindFromStatus maps the status of a failed request to path. A 404 is only
+// taken to mean that the product doesn't support the endpoint when path is
+// known and doesn't address an item.
+func kindFromStatus(status int, path string) error {
+	switch status {
+	case http.StatusNotFound:
+		if path != "" && !isItemPath(path) {
+			return ErrNotSupported
+		}
+	case http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		return ErrNotSupported
+	case http.StatusServiceUnavailable:
+		return ErrStandby
+	case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+		return ErrTimeout
+	}
+	return nil
+}
+
+// mapError wraps err in an Error if it can be mapped to a sentinel error.
+func mapError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var (
+		kind    error
+		mapped  *Error
+		modelE  *models.Error
+		httpE   *rest.HttpError
+		netE    net.Error
+		opError *net.OpError
+	)
+	switch {
+	case errors.As(err, &mapped):
+		return err
+	case errors.As(err, &modelE):
+		kind = kindFromErrorType(modelE)
+	case errors.As(err, &httpE):
+		kind = kindFromStatus(httpE.StatusCode, httpE.Path)
+	case errors.Is(err, context.DeadlineExceeded):
+		kind = ErrTimeout
+	case errors.As(err, &netE) && netE.Timeout():
+		kind = ErrTimeout
+	case errors.As(err, &opError) && opError.Op == "dial":
+		kind = ErrUnreachable
+	}
+	if kind == nil {
+		return err
+	}
+	return &Error{Kind: kind, Err: err}
+}
+
+// errorClient maps the errors returned by a rest.Client.
+type errorClient struct {
+	rest.Client
+}
+
+func (c *errorClient) DoGet(ctx context.Context, endPoint string, v interface{}) error {
+	return mapError(c.Client.DoGet(ctx, endPoint, v))
+}
+
+func (c *errorClient) DoPost(ctx context.Context, endPoint string, v interface{}) ([]byte, error) {
+	b, err := c.Client.DoPost(ctx, endPoint, v)
+	return b, mapError(err)
+}
+
+func (c *errorClient) DoPut(ctx context.Context, endPoint string, v interface{}) ([]byte, error) {
+	b, err := c.Client.DoPut(ctx, endPoint, v)
+	return b, mapError(err)
+}
+
+func (c *errorClient) DoDelete(ctx context.Context, endPoint string) ([]byte, error) {
+	b, err := c.Client.DoDelete(ctx, endPoint)
+	return b, mapError(err)
+}
+
+func (c *errorClient) OpenEventStream(ctx context.Context, endPoint string) (<-chan rest.Event, error) {
+	events, err := c.Client.OpenEventStream(ctx, endPoint)
+	return events, mapError(err)
+}
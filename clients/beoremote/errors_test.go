@@ -0,0 +1,153 @@
+// Copyright (c) 2020-2024 Andrew Stormont
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package beoremote
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"beoutil/clients/beoremote/models"
+	"beoutil/clients/rest"
+)
+
+func TestKindFromStatus(t *testing.T) {
+	const volume = "/BeoZone/Zone/Sound/Volume/Speaker/Level"
+	tests := []struct {
+		status int
+		path   string
+		want   error
+	}{
+		{http.StatusOK, volume, nil},
+		{http.StatusBadRequest, volume, nil},
+		{http.StatusNotFound, volume, ErrNotSupported},
+		{http.StatusNotFound, "/BeoZone/Zone/PlayQueue/", ErrNotSupported},
+		{http.StatusNotFound, "/BeoZone/Zone/PlayQueue/PlayPointer", ErrNotSupported},
+		{http.StatusNotFound, "/BeoZone/Zone/PlayQueue/plid-1234.5678", nil},
+		{http.StatusNotFound, "/BeoContent/radio/netRadioProfile/station/", ErrNotSupported},
+		{http.StatusNotFound, "/BeoContent/radio/netRadioProfile/station/s24861", nil},
+		{http.StatusNotFound, "/BeoHome/trigger/timerList/", ErrNotSupported},
+		{http.StatusNotFound, "/BeoHome/trigger/timerList/42", nil},
+		{http.StatusNotFound, "", nil},
+		{http.StatusMethodNotAllowed, volume, ErrNotSupported},
+		{http.StatusMethodNotAllowed, "/BeoZone/Zone/PlayQueue/plid-1", ErrNotSupported},
+		{http.StatusNotImplemented, "", ErrNotSupported},
+		{http.StatusServiceUnavailable, volume, ErrStandby},
+		{http.StatusRequestTimeout, volume, ErrTimeout},
+		{http.StatusGatewayTimeout, volume, ErrTimeout},
+		{http.StatusInternalServerError, volume, nil},
+	}
+	for _, tt := range tests {
+		if got := kindFromStatus(tt.status, tt.path); got != tt.want {
+			t.Errorf("kindFromStatus(%d, %q) = %v, want %v", tt.status, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestKindFromErrorType(t *testing.T) {
+	tests := []struct {
+		name string
+		err  models.Error
+		want error
+	}{
+		{"standby", models.Error{Type: "PRODUCT_IN_STANDBY"}, ErrStandby},
+		{"lower case", models.Error{Type: "product_in_standby"}, ErrStandby},
+		{"not linkable", models.Error{Type: "SOURCE_NOT_LINKABLE"}, ErrNotLinkable},
+		{"not supported", models.Error{Type: "NOT_SUPPORTED"}, ErrNotSupported},
+		{"invalid track", models.Error{Type: "INVALID_TRACK"}, ErrInvalidQueueItem},
+		{"message ignored", models.Error{Type: "UNKNOWN", Message: "queue is in standby"}, nil},
+		{"type substring", models.Error{Type: "TRACK_CHANGED"}, nil},
+		{"status fallback", models.Error{Type: "UNKNOWN", StatusCode: http.StatusServiceUnavailable}, ErrStandby},
+		{"no type", models.Error{StatusCode: http.StatusNotFound, Path: "/BeoZone/Zone/Stream/Play"}, ErrNotSupported},
+		{"missing item", models.Error{StatusCode: http.StatusNotFound, Path: "/BeoZone/Zone/PlayQueue/plid-1"}, nil},
+		{"type wins", models.Error{Type: "INVALID_QUEUE_ITEM", StatusCode: http.StatusNotFound}, ErrInvalidQueueItem},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := kindFromErrorType(&tt.err); got != tt.want {
+				t.Errorf("kindFromErrorType(%+v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMapError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"product error", fmt.Errorf("post: %w", &models.Error{Type: "STANDBY"}), ErrStandby},
+		{"http error", &rest.HttpError{StatusCode: http.StatusNotImplemented}, ErrNotSupported},
+		{"deadline", context.DeadlineExceeded, ErrTimeout},
+		{"already mapped", &Error{Kind: ErrNotLinkable, Err: errors.New("x")}, ErrNotLinkable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := mapError(tt.err)
+			if !errors.Is(got, tt.want) {
+				t.Errorf("mapError(%v) = %v, want it to match %v", tt.err, got, tt.want)
+			}
+			if !errors.Is(got, tt.err) {
+				t.Errorf("mapError(%v) = %v, want it to wrap the original error", tt.err, got)
+			}
+		})
+	}
+	if err := mapError(errors.New("other")); errors.As(err, new(*Error)) {
+		t.Errorf("mapError mapped an unknown error: %v", err)
+	}
+}
+
+func TestErrorClientNotFound(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("body") != "" {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error":{"type":"UNKNOWN","message":"not found"}}`))
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+	c := &errorClient{rest.NewJSONClient()}
+	tests := []struct {
+		name string
+		path string
+		want bool
+	}{
+		{"missing endpoint", "/BeoZone/Zone/Sound/Volume/Speaker/Level", true},
+		{"missing item", "/BeoZone/Zone/PlayQueue/plid-1234", false},
+		{"missing endpoint with body", "/BeoZone/Zone/Stream/Play?body=1", true},
+		{"missing item with body", "/BeoZone/Zone/PlayQueue/plid-1234?body=1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := c.DoDelete(context.Background(), srv.URL+tt.path)
+			if err == nil {
+				t.Fatal("DoDelete succeeded")
+			}
+			if got := errors.Is(err, ErrNotSupported); got != tt.want {
+				t.Errorf("errors.Is(%v, ErrNotSupported) = %v, want %v", err, got, tt.want)
+			}
+		})
+	}
+}
@@ -52,6 +52,42 @@ type Notification struct {
 	Data      json.RawMessage  `json:"data"`
 }
 
+// Decode decodes the notification's data into the type matching its Type,
+// e.g. *ProgressInformationData for PROGRESS_INFORMATION notifications.
+// Notifications of unknown types decode to nil.
+func (n *Notification) Decode() (interface{}, error) {
+	var v interface{}
+	switch n.Type {
+	case NotificationTypeSource:
+		v = &SourceData{}
+	case NotificationTypeSourceExperienceChanged:
+		v = &SourceExperienceChangedData{}
+	case NotificationTypeNowPlayingEnded:
+		return nil, nil
+	case NotificationTypeNowPlayingStoredMusic:
+		v = &NowPlayingStoredMusicData{}
+	case NotificationTypeNowPlayingNetRadio:
+		v = &NowPlayingNetRadioData{}
+	case NotificationTypePlayQueueChanged:
+		v = &PlayQueueChangedData{}
+	case NotificationTypeProgressInformation:
+		v = &ProgressInformationData{}
+	case NotificationTypeVolume:
+		v = &VolumeData{}
+	case NotificationTypeSoftwareUpdateStatus:
+		v = &SoftwareUpdateStatusData{}
+	default:
+		return nil, nil
+	}
+	if len(n.Data) == 0 {
+		return v, nil
+	}
+	if err := json.Unmarshal(n.Data, v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
 type NotificationWrapper struct {
 	Notification Notification `json:"notification"`
 }
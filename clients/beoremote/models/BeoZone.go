@@ -148,6 +148,7 @@ type Track struct {
 	Duration             int      `json:"duration"`
 	ArtistName           string   `json:"artistName,omitempty"`
 	ArtistNameNormalized string   `json:"artistNameNormalized,omitempty"`
+	Album                string   `json:"album,omitempty"`
 	Artist               []Artist `json:"artist"`
 	Dlna                 *Dlna    `json:"dlna,omitempty"`
 	Image                []Image  `json:"image"`
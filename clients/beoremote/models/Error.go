@@ -23,6 +23,10 @@ package models
 type Error struct {
 	Type    string `json:"type"`
 	Message string `json:"message"`
+	// StatusCode is the HTTP status of the response the error came in.
+	StatusCode int `json:"-"`
+	// Path is the path of the request that failed.
+	Path string `json:"-"`
 }
 
 func (e *Error) Error() string {
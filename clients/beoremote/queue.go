@@ -0,0 +1,228 @@
+// Copyright (c) 2020-2024 Andrew Stormont
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package beoremote
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+	"sync"
+
+	"beoutil/clients/beoremote/models"
+)
+
+// QueueIter pages through a play queue from the start.
+type QueueIter interface {
+	// Next returns the next page of items, or io.EOF after the last.
+	Next(ctx context.Context) ([]models.PlayQueueItem, error)
+	// Read returns how many items have been read so far.
+	Read() int
+	// Queue returns the queue as of the last page, without its items.
+	Queue() *models.PlayQueue
+}
+
+type queueIterImpl struct {
+	zone     BeoZone
+	pageSize int
+	queue    *models.PlayQueue
+	read     int
+	done     bool
+}
+
+// NewQueueIter returns an iterator that fetches pageSize items at a time.
+func NewQueueIter(z BeoZone, pageSize int) QueueIter {
+	return &queueIterImpl{zone: z, pageSize: pageSize}
+}
+
+func (i *queueIterImpl) Next(ctx context.Context) ([]models.PlayQueueItem, error) {
+	if i.done || (i.queue != nil && i.read >= i.queue.Total) {
+		return nil, io.EOF
+	}
+	page, err := i.zone.GetPlayQueue(ctx, i.read, i.pageSize)
+	if err != nil {
+		return nil, err
+	}
+	items := page.PlayQueueItem
+	page.PlayQueueItem = nil
+	i.queue = page
+	// Don't loop forever if the queue shrinks while being read.
+	if len(items) == 0 {
+		i.done = true
+		return nil, io.EOF
+	}
+	i.read += len(items)
+	return items, nil
+}
+
+func (i *queueIterImpl) Read() int {
+	return i.read
+}
+
+func (i *queueIterImpl) Queue() *models.PlayQueue {
+	return i.queue
+}
+
+// GetFullPlayQueue fetches every item in a play queue, a page at a time.
+func GetFullPlayQueue(ctx context.Context, z BeoZone) (*models.PlayQueue, error) {
+	iter := NewQueueIter(z, 100)
+	var items []models.PlayQueueItem
+	for {
+		page, err := iter.Next(ctx)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, page...)
+	}
+	q := iter.Queue()
+	if q == nil {
+		q = &models.PlayQueue{}
+	}
+	q.PlayQueueItem = items
+	return q, nil
+}
+
+// QueueMirror keeps a local copy of a product's play queue, which is
+// fetched again whenever the product reports a new queue revision.
+type QueueMirror struct {
+	zone     BeoZone
+	mu       sync.Mutex
+	queue    *models.PlayQueue
+	revision int
+}
+
+func NewQueueMirror(z BeoZone) *QueueMirror {
+	return &QueueMirror{zone: z}
+}
+
+// Queue returns a copy of the queue, or nil if it hasn't been fetched.
+func (m *QueueMirror) Queue() *models.PlayQueue {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.queue == nil {
+		return nil
+	}
+	q := *m.queue
+	q.PlayQueueItem = append([]models.PlayQueueItem(nil), m.queue.PlayQueueItem...)
+	return &q
+}
+
+// Revision returns the last queue revision reported by the product.
+func (m *QueueMirror) Revision() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.revision
+}
+
+// Refresh fetches the whole queue.
+func (m *QueueMirror) Refresh(ctx context.Context) error {
+	q, err := GetFullPlayQueue(ctx, m.zone)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.queue = q
+	m.mu.Unlock()
+	return nil
+}
+
+// Handle updates the queue from a notification, and reports whether it
+// changed. The queue is fetched again on PLAY_QUEUE_CHANGED notifications
+// with a new revision, and the play pointer follows the item being played.
+func (m *QueueMirror) Handle(ctx context.Context, n *models.Notification) (bool, error) {
+	switch n.Type {
+	case models.NotificationTypePlayQueueChanged:
+		var d models.PlayQueueChangedData
+		if err := json.Unmarshal(n.Data, &d); err != nil {
+			return false, err
+		}
+		m.mu.Lock()
+		stale := m.queue == nil || d.Revision != m.revision
+		m.revision = d.Revision
+		m.mu.Unlock()
+		if !stale {
+			return false, nil
+		}
+		old := m.Queue()
+		if err := m.Refresh(ctx); err != nil {
+			return false, err
+		}
+		// Products report the current revision when a stream is opened,
+		// which needn't have changed anything.
+		return !reflect.DeepEqual(old, m.Queue()), nil
+	case models.NotificationTypeNowPlayingStoredMusic:
+		var d models.NowPlayingStoredMusicData
+		if err := json.Unmarshal(n.Data, &d); err != nil {
+			return false, err
+		}
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if m.queue == nil || d.PlayQueueItemID == "" || d.PlayQueueItemID == m.queue.PlayNowId {
+			return false, nil
+		}
+		m.queue.PlayNowId = d.PlayQueueItemID
+		return true, nil
+	}
+	return false, nil
+}
+
+// Run fetches the queue and keeps it up to date until ctx is done or the
+// notification stream fails, calling onChange with a copy of the queue
+// whenever it changes. The queue is fetched again whenever the stream has
+// to be reopened, as changes may have been missed.
+func (m *QueueMirror) Run(ctx context.Context, onChange func(q *models.PlayQueue)) error {
+	for {
+		events, err := m.zone.OpenNotificationStream(ctx)
+		if err != nil {
+			return mapError(err)
+		}
+		if err = m.Refresh(ctx); err != nil {
+			return err
+		}
+		onChange(m.Queue())
+		for event := range events {
+			if errors.Is(event.Err, io.EOF) || errors.Is(event.Err, io.ErrUnexpectedEOF) {
+				break
+			}
+			if event.Err != nil {
+				return mapError(event.Err)
+			}
+			var n models.NotificationWrapper
+			if err = json.Unmarshal(event.Value, &n); err != nil {
+				return err
+			}
+			changed, err := m.Handle(ctx, &n.Notification)
+			if err != nil {
+				return err
+			}
+			if changed {
+				onChange(m.Queue())
+			}
+		}
+		if err = ctx.Err(); err != nil {
+			return mapError(err)
+		}
+	}
+}
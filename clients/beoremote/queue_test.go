@@ -0,0 +1,280 @@
+// Copyright (c) 2020-2024 Andrew Stormont
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package beoremote
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+	"testing"
+
+	"beoutil/clients/beoremote/models"
+	"beoutil/clients/rest"
+)
+
+// fakeQueueZone serves a play queue a page at a time, and notifications
+// from events.
+type fakeQueueZone struct {
+	BeoZone
+	mu      sync.Mutex
+	items   []models.PlayQueueItemID
+	playNow models.PlayQueueItemID
+	fetches int
+	events  chan rest.Event
+}
+
+func newFakeQueueZone(n int) *fakeQueueZone {
+	z := &fakeQueueZone{}
+	z.setItems(n)
+	return z
+}
+
+func (z *fakeQueueZone) setItems(n int) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	z.items = nil
+	for i := 0; i < n; i++ {
+		z.items = append(z.items, models.PlayQueueItemID(fmt.Sprintf("plid-%d", i)))
+	}
+}
+
+func (z *fakeQueueZone) GetPlayQueue(ctx context.Context, offset, count int) (*models.PlayQueue, error) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	z.fetches++
+	q := &models.PlayQueue{Offset: offset, Total: len(z.items), PlayNowId: z.playNow}
+	for i := offset; i < len(z.items) && i < offset+count; i++ {
+		q.PlayQueueItem = append(q.PlayQueueItem, models.PlayQueueItem{Id: z.items[i]})
+	}
+	q.Count = len(q.PlayQueueItem)
+	return q, nil
+}
+
+func (z *fakeQueueZone) OpenNotificationStream(ctx context.Context) (<-chan rest.Event, error) {
+	return z.events, nil
+}
+
+func queueItemIDs(q *models.PlayQueue) []models.PlayQueueItemID {
+	if q == nil {
+		return nil
+	}
+	var ids []models.PlayQueueItemID
+	for _, qi := range q.PlayQueueItem {
+		ids = append(ids, qi.Id)
+	}
+	return ids
+}
+
+func TestGetFullPlayQueue(t *testing.T) {
+	tests := []struct {
+		items   int
+		fetches int
+	}{
+		{0, 1},
+		{1, 1},
+		{100, 1},
+		{101, 2},
+		{250, 3},
+	}
+	for _, tt := range tests {
+		z := newFakeQueueZone(tt.items)
+		q, err := GetFullPlayQueue(context.Background(), z)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ids := queueItemIDs(q); len(ids) != tt.items || (tt.items > 0 && ids[tt.items-1] != z.items[tt.items-1]) {
+			t.Errorf("%d items: got %d items", tt.items, len(ids))
+		}
+		if z.fetches != tt.fetches {
+			t.Errorf("%d items: %d fetches, want %d", tt.items, z.fetches, tt.fetches)
+		}
+	}
+}
+
+func queueChanged(revision int) models.Notification {
+	return models.Notification{
+		Type: models.NotificationTypePlayQueueChanged,
+		Data: json.RawMessage(fmt.Sprintf(`{"revision": %d}`, revision)),
+	}
+}
+
+func nowPlaying(id string) models.Notification {
+	return models.Notification{
+		Type: models.NotificationTypeNowPlayingStoredMusic,
+		Data: json.RawMessage(fmt.Sprintf(`{"name": "Schism", "playQueueItemId": %q}`, id)),
+	}
+}
+
+func TestQueueMirrorHandle(t *testing.T) {
+	// Each step changes the queue to items long before handling n,
+	// unless items is negative.
+	type step struct {
+		items   int
+		n       models.Notification
+		changed bool
+	}
+	tests := []struct {
+		name    string
+		steps   []step
+		fetches int
+		playNow models.PlayQueueItemID
+		items   int
+	}{
+		{
+			name:    "first revision",
+			steps:   []step{{3, queueChanged(1), true}},
+			fetches: 1,
+			items:   3,
+		},
+		{
+			name:    "same revision",
+			steps:   []step{{3, queueChanged(1), true}, {-1, queueChanged(1), false}},
+			fetches: 1,
+			items:   3,
+		},
+		{
+			name:    "new revision",
+			steps:   []step{{3, queueChanged(1), true}, {5, queueChanged(2), true}},
+			fetches: 2,
+			items:   5,
+		},
+		{
+			name:    "new revision, same queue",
+			steps:   []step{{3, queueChanged(1), true}, {-1, queueChanged(2), false}},
+			fetches: 2,
+			items:   3,
+		},
+		{
+			name:    "now playing before fetch",
+			steps:   []step{{3, nowPlaying("plid-1"), false}},
+			fetches: 0,
+		},
+		{
+			name:    "now playing",
+			steps:   []step{{3, queueChanged(1), true}, {-1, nowPlaying("plid-1"), true}, {-1, nowPlaying("plid-1"), false}},
+			fetches: 1,
+			playNow: "plid-1",
+			items:   3,
+		},
+		{
+			name:    "radio",
+			steps:   []step{{3, queueChanged(1), true}, {-1, nowPlaying(""), false}},
+			fetches: 1,
+			items:   3,
+		},
+		{
+			name:    "other notification",
+			steps:   []step{{3, queueChanged(1), true}, {-1, models.Notification{Type: models.NotificationTypeVolume}, false}},
+			fetches: 1,
+			items:   3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			z := newFakeQueueZone(0)
+			m := NewQueueMirror(z)
+			for i, s := range tt.steps {
+				if s.items >= 0 {
+					z.setItems(s.items)
+				}
+				changed, err := m.Handle(context.Background(), &s.n)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if changed != s.changed {
+					t.Errorf("step %d: changed = %t, want %t", i, changed, s.changed)
+				}
+			}
+			q := m.Queue()
+			if z.fetches != tt.fetches {
+				t.Errorf("%d fetches, want %d", z.fetches, tt.fetches)
+			}
+			if len(queueItemIDs(q)) != tt.items {
+				t.Errorf("%d items, want %d", len(queueItemIDs(q)), tt.items)
+			}
+			if q != nil && q.PlayNowId != tt.playNow {
+				t.Errorf("PlayNowId = %q, want %q", q.PlayNowId, tt.playNow)
+			}
+		})
+	}
+}
+
+func TestQueueMirrorQueueIsCopy(t *testing.T) {
+	m := NewQueueMirror(newFakeQueueZone(2))
+	if m.Queue() != nil {
+		t.Fatal("expected no queue before the first fetch")
+	}
+	if err := m.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	q := m.Queue()
+	q.PlayQueueItem[0].Id = "changed"
+	if got := queueItemIDs(m.Queue()); got[0] != "plid-0" {
+		t.Errorf("changing a copy changed the mirror: %q", got)
+	}
+}
+
+func TestQueueMirrorRun(t *testing.T) {
+	z := newFakeQueueZone(2)
+	z.events = make(chan rest.Event)
+	m := NewQueueMirror(z)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes := make(chan []models.PlayQueueItemID)
+	done := make(chan error)
+	go func() {
+		done <- m.Run(ctx, func(q *models.PlayQueue) { changes <- queueItemIDs(q) })
+	}()
+	send := func(n models.Notification) {
+		b, err := json.Marshal(models.NotificationWrapper{Notification: n})
+		if err != nil {
+			t.Fatal(err)
+		}
+		z.events <- rest.Event{Value: b}
+	}
+	want := func(ids ...models.PlayQueueItemID) {
+		t.Helper()
+		if got := <-changes; !reflect.DeepEqual(got, ids) {
+			t.Errorf("onChange got %q, want %q", got, ids)
+		}
+	}
+	// The queue is fetched when the stream opens.
+	want("plid-0", "plid-1")
+	z.setItems(1)
+	send(queueChanged(1))
+	want("plid-0")
+	// Nothing changes for a revision already seen, so the next change
+	// seen is the play pointer moving.
+	send(queueChanged(1))
+	send(nowPlaying("plid-0"))
+	want("plid-0")
+	// The stream ending is followed by a fresh fetch.
+	z.setItems(3)
+	z.events <- rest.Event{Err: io.EOF}
+	want("plid-0", "plid-1", "plid-2")
+	z.events <- rest.Event{Err: errors.New("broken")}
+	if err := <-done; err == nil || err.Error() != "broken" {
+		t.Errorf("Run() = %v, want the stream error", err)
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright (c) 2020-2024 Andrew Stormont
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package beoremote
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"beoutil/clients/beoremote/models"
+)
+
+// ParseSourceRef splits a source reference of the form
+// "<product>/<source>", such as "kitchen/Deezer". The product is empty
+// if the reference doesn't name one.
+func ParseSourceRef(ref string) (product, source string) {
+	if i := strings.Index(ref, "/"); i >= 0 {
+		return ref[:i], ref[i+1:]
+	}
+	return "", ref
+}
+
+// IsLocal reports whether a source listed for product belongs to it,
+// rather than being borrowed from another product.
+func IsLocal(product *models.Product, source *models.Source) bool {
+	return source.Product.Jid == "" || source.Product.Jid == product.Jid
+}
+
+// FindProduct finds a product by JID or friendly name.
+func FindProduct(products []models.Product, product string) *models.Product {
+	for i := range products {
+		if string(products[i].Jid) == product || strings.EqualFold(products[i].FriendlyName, product) {
+			return &products[i]
+		}
+	}
+	return nil
+}
+
+// FindSource finds a source available to product, which is a JID or
+// friendly name, by its ID, friendly name or source type, in that order
+// of preference. If more than one source matches, the product's own
+// sources are preferred to borrowed ones. If product is empty the
+// sources of every product are searched.
+func FindSource(products []models.Product, product, source string) (*models.Source, error) {
+	candidates := products
+	if product != "" {
+		p := FindProduct(products, product)
+		if p == nil {
+			return nil, &Error{Kind: ErrSourceNotFound, Err: fmt.Errorf("no product %q in the system", product)}
+		}
+		candidates = []models.Product{*p}
+	}
+	matchers := []func(s *models.Source) bool{
+		func(s *models.Source) bool { return string(s.Id) == source },
+		func(s *models.Source) bool { return strings.EqualFold(s.FriendlyName, source) },
+		func(s *models.Source) bool { return strings.EqualFold(s.SourceType.Type, source) },
+	}
+	for _, match := range matchers {
+		var found *models.Source
+		for i := range candidates {
+			p := &candidates[i]
+			for j := range p.Source {
+				s := &p.Source[j]
+				if !match(s) {
+					continue
+				}
+				if IsLocal(p, s) {
+					return s, nil
+				}
+				if found == nil {
+					found = s
+				}
+			}
+		}
+		if found != nil {
+			return found, nil
+		}
+	}
+	if product == "" {
+		return nil, &Error{Kind: ErrSourceNotFound, Err: fmt.Errorf("no source matching %q", source)}
+	}
+	return nil, &Error{Kind: ErrSourceNotFound, Err: fmt.Errorf("no source matching %q on %s", source, product)}
+}
+
+// ResolveSource finds a source available to product as FindSource does,
+// using the products known to this product.
+func (c *Client) ResolveSource(ctx context.Context, product, source string) (*models.Source, error) {
+	products, err := c.BeoZone.GetSystemProducts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return FindSource(products, product, source)
+}
@@ -0,0 +1,122 @@
+// Copyright (c) 2020-2024 Andrew Stormont
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package beoremote
+
+import (
+	"errors"
+	"testing"
+
+	"beoutil/clients/beoremote/models"
+)
+
+func TestParseSourceRef(t *testing.T) {
+	tests := []struct {
+		ref, product, source string
+	}{
+		{"kitchen/Deezer", "kitchen", "Deezer"},
+		{"Deezer", "", "Deezer"},
+		{"deezer:1.2.3@products.bang-olufsen.com", "", "deezer:1.2.3@products.bang-olufsen.com"},
+		{"kitchen/a/b", "kitchen", "a/b"},
+		{"/Deezer", "", "Deezer"},
+		{"", "", ""},
+	}
+	for _, tt := range tests {
+		product, source := ParseSourceRef(tt.ref)
+		if product != tt.product || source != tt.source {
+			t.Errorf("ParseSourceRef(%q) = %q, %q, want %q, %q", tt.ref, product, source, tt.product, tt.source)
+		}
+	}
+}
+
+func testProducts() []models.Product {
+	kitchen := models.ShortProduct{Jid: "1.1.1@products.bang-olufsen.com", FriendlyName: "Kitchen"}
+	lounge := models.ShortProduct{Jid: "2.2.2@products.bang-olufsen.com", FriendlyName: "Lounge"}
+	source := func(id, name, typ string, p models.ShortProduct) models.Source {
+		return models.Source{
+			Id:           models.SourceID(id),
+			FriendlyName: name,
+			SourceType:   models.SourceType{Type: typ},
+			Product:      p,
+		}
+	}
+	return []models.Product{
+		{Jid: kitchen.Jid, FriendlyName: kitchen.FriendlyName, Source: []models.Source{
+			source("radio:1.1.1", "Radio", "TUNEIN", kitchen),
+			source("deezer:1.1.1", "Deezer", "DEEZER", kitchen),
+			source("tunein:1.1.1", "TuneIn", "NET_RADIO", kitchen),
+			source("linein:2.2.2", "Line-In", "LINE_IN", lounge),
+			source("linein:1.1.1", "Line-In", "LINE_IN", kitchen),
+			source("spdif:2.2.2", "Optical", "SPDIF", lounge),
+		}},
+		{Jid: lounge.Jid, FriendlyName: lounge.FriendlyName, Source: []models.Source{
+			source("deezer:2.2.2", "Deezer", "DEEZER", lounge),
+			source("linein:2.2.2", "Line-In", "LINE_IN", lounge),
+			source("spdif:2.2.2", "Optical", "SPDIF", lounge),
+		}},
+	}
+}
+
+func TestFindSource(t *testing.T) {
+	products := testProducts()
+	tests := []struct {
+		name    string
+		product string
+		source  string
+		want    models.SourceID
+	}{
+		{"by id", "kitchen", "deezer:1.1.1", "deezer:1.1.1"},
+		{"by name", "Kitchen", "deezer", "deezer:1.1.1"},
+		{"by type", "kitchen", "spdif", "spdif:2.2.2"},
+		{"name before type", "kitchen", "tunein", "tunein:1.1.1"},
+		{"own before borrowed", "kitchen", "Line-In", "linein:1.1.1"},
+		{"borrowed", "kitchen", "optical", "spdif:2.2.2"},
+		{"by jid", "2.2.2@products.bang-olufsen.com", "deezer", "deezer:2.2.2"},
+		{"any product", "", "deezer", "deezer:1.1.1"},
+		{"any product by id", "", "deezer:2.2.2", "deezer:2.2.2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := FindSource(products, tt.product, tt.source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s.Id != tt.want {
+				t.Errorf("FindSource(%q, %q) = %s, want %s", tt.product, tt.source, s.Id, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindSourceNotFound(t *testing.T) {
+	products := testProducts()
+	tests := []struct {
+		product, source string
+	}{
+		{"kitchen", "bluetooth"},
+		{"kitchen", "deezer:2.2.2"},
+		{"bedroom", "deezer"},
+		{"", "bluetooth"},
+	}
+	for _, tt := range tests {
+		if _, err := FindSource(products, tt.product, tt.source); !errors.Is(err, ErrSourceNotFound) {
+			t.Errorf("FindSource(%q, %q) error = %v, want ErrSourceNotFound", tt.product, tt.source, err)
+		}
+	}
+}
@@ -50,7 +50,7 @@ func (i *albumIterImpl) Next(ctx context.Context) ([]models.Album, error) {
 	if i.started && i.read == i.total {
 		return nil, io.EOF
 	}
-	if err := i.client.DoGet(ctx, i.endpoint, &resp); err != nil {
+	if err := getJSON(ctx, i.client, i.endpoint, &resp); err != nil {
 		return nil, err
 	}
 	i.read += len(resp.Data)
@@ -0,0 +1,305 @@
+// Copyright (c) 2020-2024 Andrew Stormont
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package deezer
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultFreshness is how long responses are kept when the server
+// doesn't say, but does give an ETag or Last-Modified to check them with.
+const defaultFreshness = 10 * time.Minute
+
+const cacheCountersFile = "counters.json"
+
+// cacheEntry is a response stored on disk.
+type cacheEntry struct {
+	URL     string      `json:"url"`
+	Header  http.Header `json:"header"`
+	Body    []byte      `json:"body"`
+	Stored  time.Time   `json:"stored"`
+	Expires time.Time   `json:"expires"`
+}
+
+// CacheCounters count how requests were answered.
+type CacheCounters struct {
+	Hits        int `json:"hits"`        // Hits were answered from the cache.
+	Revalidated int `json:"revalidated"` // Revalidated were confirmed unchanged by the server.
+	Misses      int `json:"misses"`      // Misses were fetched from the server.
+}
+
+// cache is a http.RoundTripper that keeps GET responses on disk,
+// honouring Cache-Control, Expires, ETag and Last-Modified.
+type cache struct {
+	next http.RoundTripper
+	dir  string
+	ttl  time.Duration
+
+	mu sync.Mutex
+}
+
+func (c *cache) path(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+func (c *cache) load(url string) *cacheEntry {
+	b, err := os.ReadFile(c.path(url))
+	if err != nil {
+		return nil
+	}
+	var e cacheEntry
+	if json.Unmarshal(b, &e) != nil || e.URL != url {
+		return nil
+	}
+	return &e
+}
+
+func (c *cache) store(e *cacheEntry) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	if os.MkdirAll(c.dir, 0755) != nil {
+		return
+	}
+	path := c.path(e.URL)
+	tmp := path + ".tmp"
+	if os.WriteFile(tmp, b, 0644) == nil {
+		_ = os.Rename(tmp, path)
+	}
+}
+
+func (c *cache) count(fn func(*CacheCounters)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	path := filepath.Join(c.dir, cacheCountersFile)
+	var counters CacheCounters
+	if b, err := os.ReadFile(path); err == nil {
+		_ = json.Unmarshal(b, &counters)
+	}
+	fn(&counters)
+	if b, err := json.Marshal(&counters); err == nil && os.MkdirAll(c.dir, 0755) == nil {
+		_ = os.WriteFile(path, b, 0644)
+	}
+}
+
+// cacheControl parses a Cache-Control header into its directives.
+func cacheControl(h http.Header) map[string]string {
+	cc := make(map[string]string)
+	for _, v := range h.Values("Cache-Control") {
+		for _, d := range strings.Split(v, ",") {
+			kv := strings.SplitN(strings.TrimSpace(d), "=", 2)
+			if kv[0] == "" {
+				continue
+			}
+			if len(kv) == 2 {
+				cc[strings.ToLower(kv[0])] = strings.Trim(kv[1], `"`)
+			} else {
+				cc[strings.ToLower(kv[0])] = ""
+			}
+		}
+	}
+	return cc
+}
+
+// freshness returns how long a response may be used without checking
+// with the server, and whether it may be stored at all.
+func (c *cache) freshness(h http.Header, now time.Time) (time.Duration, bool) {
+	cc := cacheControl(h)
+	if _, ok := cc["no-store"]; ok {
+		return 0, false
+	}
+	if c.ttl > 0 {
+		return c.ttl, true
+	}
+	hasValidator := h.Get("ETag") != "" || h.Get("Last-Modified") != ""
+	if _, ok := cc["no-cache"]; ok {
+		return 0, hasValidator
+	}
+	if v, ok := cc["max-age"]; ok {
+		if secs, err := strconv.Atoi(v); err == nil {
+			return time.Duration(secs) * time.Second, secs > 0 || hasValidator
+		}
+	}
+	if v := h.Get("Expires"); v != "" {
+		if t, err := http.ParseTime(v); err == nil && t.After(now) {
+			return t.Sub(now), true
+		}
+		return 0, hasValidator
+	}
+	if hasValidator {
+		return defaultFreshness, true
+	}
+	return 0, false
+}
+
+// isErrorBody reports whether a response is a Deezer error, such as
+// a quota error, which the API reports with a 200 status.
+func isErrorBody(b []byte) bool {
+	var resp struct {
+		Error json.RawMessage `json:"error"`
+	}
+	return json.Unmarshal(b, &resp) == nil && len(resp.Error) > 0
+}
+
+func response(req *http.Request, e *cacheEntry) *http.Response {
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        e.Header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(e.Body)),
+		ContentLength: int64(len(e.Body)),
+		Request:       req,
+	}
+}
+
+func (c *cache) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet {
+		return c.next.RoundTrip(req)
+	}
+	url := req.URL.String()
+	now := time.Now()
+	e := c.load(url)
+	if e != nil && now.Before(e.Expires) {
+		c.count(func(cc *CacheCounters) { cc.Hits++ })
+		return response(req, e), nil
+	}
+	if e != nil {
+		req = req.Clone(req.Context())
+		if etag := e.Header.Get("ETag"); etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		if lm := e.Header.Get("Last-Modified"); lm != "" {
+			req.Header.Set("If-Modified-Since", lm)
+		}
+	}
+	resp, err := c.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotModified && e != nil {
+		_ = resp.Body.Close()
+		if d, ok := c.freshness(resp.Header, now); ok {
+			e.Expires = now.Add(d)
+			c.store(e)
+		}
+		c.count(func(cc *CacheCounters) { cc.Revalidated++ })
+		return response(req, e), nil
+	}
+	c.count(func(cc *CacheCounters) { cc.Misses++ })
+	if resp.StatusCode != http.StatusOK {
+		return resp, nil
+	}
+	d, ok := c.freshness(resp.Header, now)
+	if !ok {
+		return resp, nil
+	}
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	if !isErrorBody(body) {
+		c.store(&cacheEntry{URL: url, Header: resp.Header, Body: body, Stored: now, Expires: now.Add(d)})
+	}
+	return resp, nil
+}
+
+// CacheStats describes what's in a cache directory.
+type CacheStats struct {
+	CacheCounters
+	Entries int
+	Fresh   int
+	Size    int64
+	Oldest  time.Time
+	Newest  time.Time
+}
+
+// GetCacheStats reports on the cache in dir.
+func GetCacheStats(dir string) (*CacheStats, error) {
+	stats := new(CacheStats)
+	if b, err := os.ReadFile(filepath.Join(dir, cacheCountersFile)); err == nil {
+		_ = json.Unmarshal(b, &stats.CacheCounters)
+	}
+	files, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return stats, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	for _, f := range files {
+		if f.Name() == cacheCountersFile || !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+		file, err := os.Open(filepath.Join(dir, f.Name()))
+		if err != nil {
+			continue
+		}
+		var e cacheEntry
+		err = json.NewDecoder(bufio.NewReader(file)).Decode(&e)
+		_ = file.Close()
+		if err != nil {
+			continue
+		}
+		if info, err := f.Info(); err == nil {
+			stats.Size += info.Size()
+		}
+		stats.Entries++
+		if now.Before(e.Expires) {
+			stats.Fresh++
+		}
+		if stats.Oldest.IsZero() || e.Stored.Before(stats.Oldest) {
+			stats.Oldest = e.Stored
+		}
+		if e.Stored.After(stats.Newest) {
+			stats.Newest = e.Stored
+		}
+	}
+	return stats, nil
+}
+
+// ClearCache removes everything from the cache in dir.
+func ClearCache(dir string) error {
+	err := os.RemoveAll(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
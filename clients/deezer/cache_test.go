@@ -0,0 +1,169 @@
+// Copyright (c) 2020-2024 Andrew Stormont
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package deezer
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestFreshness(t *testing.T) {
+	now := time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC)
+	header := func(kv ...string) http.Header {
+		h := make(http.Header)
+		for i := 0; i < len(kv); i += 2 {
+			h.Add(kv[i], kv[i+1])
+		}
+		return h
+	}
+	tests := []struct {
+		name   string
+		ttl    time.Duration
+		header http.Header
+		want   time.Duration
+		store  bool
+	}{
+		{name: "nothing", header: header(), store: false},
+		{name: "max-age", header: header("Cache-Control", "public, max-age=60"), want: time.Minute, store: true},
+		{name: "quoted max-age", header: header("Cache-Control", `max-age="60"`), want: time.Minute, store: true},
+		{name: "max-age zero", header: header("Cache-Control", "max-age=0"), store: false},
+		{name: "max-age zero with etag", header: header("Cache-Control", "max-age=0", "ETag", `"x"`), store: true},
+		{name: "no-store", header: header("Cache-Control", "no-store", "ETag", `"x"`), store: false},
+		{name: "no-store beats ttl", ttl: time.Hour, header: header("Cache-Control", "No-Store"), store: false},
+		{name: "no-cache", header: header("Cache-Control", "no-cache"), store: false},
+		{name: "no-cache with etag", header: header("Cache-Control", "no-cache", "ETag", `"x"`), store: true},
+		{name: "expires", header: header("Expires", now.Add(time.Hour).Format(http.TimeFormat)), want: time.Hour, store: true},
+		{name: "expired", header: header("Expires", now.Add(-time.Hour).Format(http.TimeFormat)), store: false},
+		{name: "invalid expires", header: header("Expires", "0"), store: false},
+		{name: "max-age beats expires", header: header("Cache-Control", "max-age=5",
+			"Expires", now.Add(time.Hour).Format(http.TimeFormat)), want: 5 * time.Second, store: true},
+		{name: "etag", header: header("ETag", `"x"`), want: defaultFreshness, store: true},
+		{name: "last-modified", header: header("Last-Modified", now.Format(http.TimeFormat)), want: defaultFreshness, store: true},
+		{name: "ttl", ttl: time.Hour, header: header("Cache-Control", "max-age=5"), want: time.Hour, store: true},
+		{name: "ttl beats no-cache", ttl: time.Hour, header: header("Cache-Control", "no-cache"), want: time.Hour, store: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &cache{ttl: tt.ttl}
+			got, store := c.freshness(tt.header, now)
+			if store != tt.store || (store && got != tt.want) {
+				t.Errorf("freshness() = %v, %t, want %v, %t", got, store, tt.want, tt.store)
+			}
+		})
+	}
+}
+
+// testOrigin serves body with header, answering conditional requests
+// with 304 when etag matches, and counts the requests it's sent.
+type testOrigin struct {
+	header   http.Header
+	body     string
+	etag     string
+	requests int
+}
+
+func (o *testOrigin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	o.requests++
+	for k, v := range o.header {
+		w.Header()[k] = v
+	}
+	if o.etag != "" {
+		w.Header().Set("ETag", o.etag)
+		if r.Header.Get("If-None-Match") == o.etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	_, _ = io.WriteString(w, o.body)
+}
+
+func TestCacheRoundTrip(t *testing.T) {
+	get := func(t *testing.T, client *http.Client, url string) string {
+		t.Helper()
+		resp, err := client.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+	tests := []struct {
+		name     string
+		origin   *testOrigin
+		requests int
+		counters CacheCounters
+	}{
+		{
+			name:     "fresh",
+			origin:   &testOrigin{header: http.Header{"Cache-Control": {"max-age=60"}}, body: `{"id": 1}`},
+			requests: 1,
+			counters: CacheCounters{Hits: 2, Misses: 1},
+		},
+		{
+			name:     "revalidated",
+			origin:   &testOrigin{header: http.Header{"Cache-Control": {"no-cache"}}, body: `{"id": 1}`, etag: `"v1"`},
+			requests: 3,
+			counters: CacheCounters{Revalidated: 2, Misses: 1},
+		},
+		{
+			name:     "not cacheable",
+			origin:   &testOrigin{body: `{"id": 1}`},
+			requests: 3,
+			counters: CacheCounters{Misses: 3},
+		},
+		{
+			name: "quota error",
+			origin: &testOrigin{header: http.Header{"Cache-Control": {"max-age=60"}},
+				body: `{"error": {"type": "Exception", "message": "Quota limit exceeded", "code": 4}}`},
+			requests: 3,
+			counters: CacheCounters{Misses: 3},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(tt.origin)
+			defer srv.Close()
+			dir := t.TempDir()
+			client := &http.Client{Transport: &cache{next: http.DefaultTransport, dir: dir}}
+			for i := 0; i < 3; i++ {
+				if got := get(t, client, srv.URL+"/track/1"); got != tt.origin.body {
+					t.Fatalf("request %d: body = %q, want %q", i, got, tt.origin.body)
+				}
+			}
+			if tt.origin.requests != tt.requests {
+				t.Errorf("origin saw %d requests, want %d", tt.origin.requests, tt.requests)
+			}
+			stats, err := GetCacheStats(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stats.CacheCounters != tt.counters {
+				t.Errorf("counters = %+v, want %+v", stats.CacheCounters, tt.counters)
+			}
+		})
+	}
+}
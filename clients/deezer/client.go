@@ -22,8 +22,11 @@ package deezer
 
 import (
 	"context"
+	"fmt"
+	"net/http"
 	"net/url"
 	"strconv"
+	"time"
 
 	"beoutil/clients/deezer/models"
 	"beoutil/clients/rest"
@@ -35,10 +38,30 @@ type Client struct {
 }
 
 func NewClient() *Client {
-	return &Client{
-		client:  rest.NewJSONClient(),
+	return NewClientWithOptions(&ClientOptions{})
+}
+
+type ClientOptions struct {
+	CacheDir string        // CacheDir is where responses are cached. Nothing is cached if it's empty.
+	TTL      time.Duration // TTL overrides how long the server says responses may be cached for.
+	BaseURL  string        // BaseURL overrides the URL of the API, e.g. for testing.
+}
+
+// NewClientWithOptions returns a client that keeps to Deezer's rate
+// limit, and optionally caches responses on disk.
+func NewClientWithOptions(opts *ClientOptions) *Client {
+	var rt http.RoundTripper = newRateLimiter(http.DefaultTransport)
+	if opts.CacheDir != "" {
+		rt = &cache{next: rt, dir: opts.CacheDir, ttl: opts.TTL}
+	}
+	c := &Client{
+		client:  rest.NewJSONClientWithTransport(rt),
 		baseURL: "https://api.deezer.com",
 	}
+	if opts.BaseURL != "" {
+		c.baseURL = opts.BaseURL
+	}
+	return c
 }
 
 type SearchOptions struct {
@@ -58,7 +81,7 @@ func (c *Client) SearchArtist(ctx context.Context, opts *SearchOptions) ([]model
 	if opts.Limit != 0 {
 		reqURL += "&limit=" + strconv.Itoa(opts.Limit)
 	}
-	if err := c.client.DoGet(ctx, c.baseURL+reqURL, &resp); err != nil {
+	if err := getJSON(ctx, c.client, c.baseURL+reqURL, &resp); err != nil {
 		return nil, err
 	}
 	return resp.Data, nil
@@ -71,20 +94,116 @@ func (c *Client) NewAlbumIter(artistID string) AlbumIter {
 	}
 }
 
+// GetAlbumTracks returns every track on an album, fetching them a page
+// at a time.
 func (c *Client) GetAlbumTracks(ctx context.Context, albumID string) ([]models.Track, error) {
+	return c.getTracks(ctx, "/album/"+albumID+"/tracks", "album "+albumID)
+}
+
+// getTracks fetches every page of a list of tracks.
+func (c *Client) getTracks(ctx context.Context, path, what string) ([]models.Track, error) {
+	var tracks []models.Track
+	endpoint := c.baseURL + path
+	for endpoint != "" {
+		var resp struct {
+			Data []models.Track `json:"data"`
+			Next string         `json:"next"`
+		}
+		if err := getJSON(ctx, c.client, endpoint, &resp); err != nil {
+			return nil, fmt.Errorf("failed to get tracks of %s: %w", what, err)
+		}
+		tracks = append(tracks, resp.Data...)
+		endpoint = resp.Next
+	}
+	return tracks, nil
+}
+
+func (c *Client) GetTrack(ctx context.Context, trackID string) (models.Track, error) {
+	var resp models.Track
+	if err := getJSON(ctx, c.client, c.baseURL+"/track/"+trackID, &resp); err != nil {
+		return models.Track{}, fmt.Errorf("failed to get track %s: %w", trackID, err)
+	}
+	return resp, nil
+}
+
+func (c *Client) GetPlaylist(ctx context.Context, playlistID string) (*models.Playlist, error) {
+	var resp models.Playlist
+	if err := getJSON(ctx, c.client, c.baseURL+"/playlist/"+playlistID, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// GetPlaylistTracks returns every track on a playlist, fetching them a
+// page at a time.
+func (c *Client) GetPlaylistTracks(ctx context.Context, playlistID string) ([]models.Track, error) {
+	return c.getTracks(ctx, "/playlist/"+playlistID+"/tracks", "playlist "+playlistID)
+}
+
+// GetArtistTopTracks returns the most popular tracks of an artist.
+func (c *Client) GetArtistTopTracks(ctx context.Context, artistID string, limit int) ([]models.Track, error) {
 	var resp struct {
 		Data []models.Track `json:"data"`
 	}
-	if err := c.client.DoGet(ctx, c.baseURL+"/album/"+albumID+"/tracks", &resp); err != nil {
+	endpoint := c.baseURL + "/artist/" + artistID + "/top?limit=" + strconv.Itoa(limit)
+	if err := getJSON(ctx, c.client, endpoint, &resp); err != nil {
+		return nil, fmt.Errorf("failed to get top tracks of artist %s: %w", artistID, err)
+	}
+	return resp.Data, nil
+}
+
+// GetRadioTracks returns the tracks a radio is playing right now.
+func (c *Client) GetRadioTracks(ctx context.Context, radioID string) ([]models.Track, error) {
+	return c.getTracks(ctx, "/radio/"+radioID+"/tracks", "radio "+radioID)
+}
+
+// GetChart returns the top tracks, albums, artists, playlists and podcasts
+// in a genre. Genre "0" is every genre.
+func (c *Client) GetChart(ctx context.Context, genreID string) (*models.Chart, error) {
+	var resp models.Chart
+	if err := getJSON(ctx, c.client, c.baseURL+"/chart/"+genreID, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (c *Client) GetGenres(ctx context.Context) ([]models.Genre, error) {
+	var resp struct {
+		Data []models.Genre `json:"data"`
+	}
+	if err := getJSON(ctx, c.client, c.baseURL+"/genre", &resp); err != nil {
 		return nil, err
 	}
 	return resp.Data, nil
 }
 
-func (c *Client) GetTrack(ctx context.Context, trackID string) (models.Track, error) {
-	var resp models.Track
-	if err := c.client.DoGet(ctx, c.baseURL+"/track/"+trackID, &resp); err != nil {
-		return models.Track{}, nil
+// GetEditorialReleases returns the new releases picked by an editorial,
+// which have the same IDs as genres.
+func (c *Client) GetEditorialReleases(ctx context.Context, editorialID string) ([]models.Album, error) {
+	var resp struct {
+		Data []models.Album `json:"data"`
 	}
-	return resp, nil
+	if err := getJSON(ctx, c.client, c.baseURL+"/editorial/"+editorialID+"/releases", &resp); err != nil {
+		return nil, err
+	}
+	return resp.Data, nil
+}
+
+// GetUserPlaylists returns every public playlist of a user, fetching
+// them a page at a time.
+func (c *Client) GetUserPlaylists(ctx context.Context, userID string) ([]models.Playlist, error) {
+	var playlists []models.Playlist
+	endpoint := c.baseURL + "/user/" + userID + "/playlists"
+	for endpoint != "" {
+		var resp struct {
+			Data []models.Playlist `json:"data"`
+			Next string            `json:"next"`
+		}
+		if err := getJSON(ctx, c.client, endpoint, &resp); err != nil {
+			return nil, err
+		}
+		playlists = append(playlists, resp.Data...)
+		endpoint = resp.Next
+	}
+	return playlists, nil
 }
@@ -0,0 +1,73 @@
+// Copyright (c) 2020-2024 Andrew Stormont
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package deezer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"beoutil/clients/rest"
+)
+
+var (
+	ErrNotFound      = errors.New("not found")
+	ErrQuotaExceeded = errors.New("quota exceeded")
+)
+
+// Error is an error reported by the Deezer API. These come back with a
+// 200 status, so have to be looked for in every response.
+type Error struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+	Code    int    `json:"code"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("deezer: %s: %s (code %d)", e.Type, e.Message, e.Code)
+}
+
+// Is allows errors.Is to match common errors by their code.
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.Code == 800
+	case ErrQuotaExceeded:
+		return e.Code == 4
+	}
+	return false
+}
+
+// getJSON fetches endpoint into v, returning any error in the response.
+func getJSON(ctx context.Context, client rest.Client, endpoint string, v interface{}) error {
+	var raw json.RawMessage
+	if err := client.DoGet(ctx, endpoint, &raw); err != nil {
+		return err
+	}
+	var resp struct {
+		Error *Error `json:"error"`
+	}
+	if json.Unmarshal(raw, &resp) == nil && resp.Error != nil {
+		return resp.Error
+	}
+	return json.Unmarshal(raw, v)
+}
@@ -0,0 +1,151 @@
+// Copyright (c) 2020-2024 Andrew Stormont
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package deezer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+type LinkType string
+
+const (
+	LinkTrack    LinkType = "track"
+	LinkAlbum    LinkType = "album"
+	LinkPlaylist LinkType = "playlist"
+	LinkArtist   LinkType = "artist"
+	LinkRadio    LinkType = "radio"
+)
+
+var ErrInvalidLink = errors.New("not a deezer track, album, playlist, artist or radio link")
+
+// Link is what a Deezer URL or URI points at.
+type Link struct {
+	Type LinkType
+	ID   string
+}
+
+func (l Link) String() string {
+	return string(l.Type) + " " + l.ID
+}
+
+// shortLinkHosts serve redirects to the links they stand for.
+var shortLinkHosts = []string{"deezer.page.link", "link.deezer.com"}
+
+// ParseLink parses links such as https://www.deezer.com/en/track/3135556
+// and deezer://www.deezer.com/album/302127. Short links have to be
+// resolved with ResolveLink.
+func ParseLink(s string) (Link, error) {
+	u, err := url.Parse(strings.TrimSpace(s))
+	if err != nil {
+		return Link{}, fmt.Errorf("%w: %s", ErrInvalidLink, s)
+	}
+	var path []string
+	switch {
+	case u.Scheme == "deezer":
+		// Both deezer://www.deezer.com/track/1 and deezer://track/1 are seen.
+		if u.Host != "" && !isDeezerHost(u.Host) {
+			path = append(path, u.Host)
+		}
+	case (u.Scheme == "http" || u.Scheme == "https") && isDeezerHost(u.Host):
+	default:
+		return Link{}, fmt.Errorf("%w: %s", ErrInvalidLink, s)
+	}
+	for _, p := range strings.Split(u.Path, "/") {
+		if p != "" {
+			path = append(path, p)
+		}
+	}
+	// Skip the language, as in /en/track/1.
+	if len(path) == 3 && len(path[0]) == 2 {
+		path = path[1:]
+	}
+	if len(path) != 2 {
+		return Link{}, fmt.Errorf("%w: %s", ErrInvalidLink, s)
+	}
+	if _, err := strconv.Atoi(path[1]); err != nil {
+		return Link{}, fmt.Errorf("%w: %s", ErrInvalidLink, s)
+	}
+	switch t := LinkType(path[0]); t {
+	case LinkTrack, LinkAlbum, LinkPlaylist, LinkArtist, LinkRadio:
+		return Link{Type: t, ID: path[1]}, nil
+	}
+	return Link{}, fmt.Errorf("%w: %s", ErrInvalidLink, s)
+}
+
+func isDeezerHost(host string) bool {
+	return host == "deezer.com" || strings.HasSuffix(host, ".deezer.com")
+}
+
+func isShortLink(u *url.URL) bool {
+	for _, h := range shortLinkHosts {
+		if u.Host == h {
+			return true
+		}
+	}
+	return false
+}
+
+// ResolveLink parses a link like ParseLink, following the redirects of
+// short links such as https://deezer.page.link/... to the link they
+// stand for.
+func (c *Client) ResolveLink(ctx context.Context, s string) (Link, error) {
+	u, err := url.Parse(strings.TrimSpace(s))
+	if err != nil || !isShortLink(u) {
+		return ParseLink(s)
+	}
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	for i := 0; i < 10; i++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+		if err != nil {
+			return Link{}, err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return Link{}, fmt.Errorf("failed to resolve %s: %w", s, err)
+		}
+		_ = resp.Body.Close()
+		loc, err := resp.Location()
+		if err != nil {
+			return Link{}, fmt.Errorf("failed to resolve %s: no redirect (%s)", s, resp.Status)
+		}
+		// Firebase links may redirect with the real link in a parameter.
+		if l := loc.Query().Get("link"); l != "" {
+			if link, err := ParseLink(l); err == nil {
+				return link, nil
+			}
+		}
+		if !isShortLink(loc) {
+			return ParseLink(loc.String())
+		}
+		u = loc
+	}
+	return Link{}, fmt.Errorf("failed to resolve %s: too many redirects", s)
+}
@@ -0,0 +1,113 @@
+// Copyright (c) 2020-2024 Andrew Stormont
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package deezer
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestParseLink(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Link
+		wantErr bool
+	}{
+		{in: "https://www.deezer.com/track/3135556", want: Link{LinkTrack, "3135556"}},
+		{in: "https://www.deezer.com/en/track/3135556", want: Link{LinkTrack, "3135556"}},
+		{in: "  https://www.deezer.com/fr/album/302127/  ", want: Link{LinkAlbum, "302127"}},
+		{in: "http://deezer.com/playlist/908622995", want: Link{LinkPlaylist, "908622995"}},
+		{in: "https://www.deezer.com/artist/27?utm_source=share", want: Link{LinkArtist, "27"}},
+		{in: "https://www.deezer.com/radio/30771", want: Link{LinkRadio, "30771"}},
+		{in: "deezer://www.deezer.com/album/302127", want: Link{LinkAlbum, "302127"}},
+		{in: "deezer://track/3135556", want: Link{LinkTrack, "3135556"}},
+		{in: "3135556", wantErr: true},
+		{in: "", wantErr: true},
+		{in: "https://www.example.com/track/3135556", wantErr: true},
+		{in: "https://notdeezer.com/track/3135556", wantErr: true},
+		{in: "ftp://www.deezer.com/track/3135556", wantErr: true},
+		{in: "https://www.deezer.com/show/3135556", wantErr: true},
+		{in: "https://www.deezer.com/track/abc", wantErr: true},
+		{in: "https://www.deezer.com/track", wantErr: true},
+		{in: "https://www.deezer.com/en/us/track/1", wantErr: true},
+		{in: "https://deezer.page.link/abc123", wantErr: true},
+		{in: "://bad", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseLink(tt.in)
+		if tt.wantErr {
+			if !errors.Is(err, ErrInvalidLink) {
+				t.Errorf("ParseLink(%q) = %v, %v, want ErrInvalidLink", tt.in, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ParseLink(%q) = %v, %v, want %v", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestResolveLink(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/direct", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "https://www.deezer.com/en/album/302127?utm_source=share", http.StatusFound)
+	})
+	mux.HandleFunc("/firebase", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "https://example.com/?link="+url.QueryEscape("https://www.deezer.com/track/3135556"), http.StatusFound)
+	})
+	mux.HandleFunc("/chain", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/direct", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/loop", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/loop", http.StatusFound)
+	})
+	mux.HandleFunc("/gone", func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	hosts := shortLinkHosts
+	defer func() { shortLinkHosts = hosts }()
+	shortLinkHosts = []string{srv.Listener.Addr().String()}
+
+	tests := []struct {
+		in      string
+		want    Link
+		wantErr bool
+	}{
+		{in: srv.URL + "/direct", want: Link{LinkAlbum, "302127"}},
+		{in: srv.URL + "/firebase", want: Link{LinkTrack, "3135556"}},
+		{in: srv.URL + "/chain", want: Link{LinkAlbum, "302127"}},
+		{in: "https://www.deezer.com/radio/30771", want: Link{LinkRadio, "30771"}},
+		{in: srv.URL + "/loop", wantErr: true},
+		{in: srv.URL + "/gone", wantErr: true},
+	}
+	c := NewClient()
+	for _, tt := range tests {
+		got, err := c.ResolveLink(context.Background(), tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ResolveLink(%q) = %v, %v, want %v, error %t", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
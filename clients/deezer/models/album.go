@@ -21,7 +21,8 @@
 package models
 
 type TrackData struct {
-	Data []Track `json:"data"`
+	Data  []Track `json:"data"`
+	Total int     `json:"total"`
 }
 
 type Album struct {
@@ -33,7 +34,7 @@ type Album struct {
 	Cover                 string        `json:"cover"`
 	CoverSmall            string        `json:"cover_small"`
 	CoverMedium           string        `json:"cover_medium"`
-	CoverLarge            string        `json:"cover_large"`
+	CoverBig              string        `json:"cover_big"`
 	CoverXL               string        `json:"cover_xl"`
 	MD5Image              string        `json:"md5_image"`
 	GenreID               int           `json:"genre_id"`
@@ -0,0 +1,49 @@
+// Copyright (c) 2020-2024 Andrew Stormont
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package models
+
+type AlbumData struct {
+	Data  []Album `json:"data"`
+	Total int     `json:"total"`
+}
+
+type ArtistData struct {
+	Data  []Artist `json:"data"`
+	Total int      `json:"total"`
+}
+
+type PlaylistData struct {
+	Data  []Playlist `json:"data"`
+	Total int        `json:"total"`
+}
+
+type PodcastData struct {
+	Data  []Podcast `json:"data"`
+	Total int       `json:"total"`
+}
+
+type Chart struct {
+	Tracks    TrackData    `json:"tracks"`
+	Albums    AlbumData    `json:"albums"`
+	Artists   ArtistData   `json:"artists"`
+	Playlists PlaylistData `json:"playlists"`
+	Podcasts  PodcastData  `json:"podcasts"`
+}
@@ -21,11 +21,12 @@
 package models
 
 type Genre struct {
-	ID            string `json:"id"`
+	ID            int    `json:"id"`
 	Name          string `json:"name"`
 	Picture       string `json:"picture"`
 	PictureSmall  string `json:"picture_small"`
 	PictureMedium string `json:"picture_medium"`
 	PictureBig    string `json:"picture_big"`
 	PictureXL     string `json:"picture_xl"`
+	Type          string `json:"type"`
 }
@@ -0,0 +1,55 @@
+// Copyright (c) 2020-2024 Andrew Stormont
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package models
+
+type Podcast struct {
+	ID            int    `json:"id"`
+	Title         string `json:"title"`
+	Description   string `json:"description"`
+	Available     bool   `json:"available"`
+	Fans          int    `json:"fans"`
+	Link          string `json:"link"`
+	Share         string `json:"share"`
+	Picture       string `json:"picture"`
+	PictureSmall  string `json:"picture_small"`
+	PictureMedium string `json:"picture_medium"`
+	PictureBig    string `json:"picture_big"`
+	PictureXL     string `json:"picture_xl"`
+	Type          string `json:"type"`
+}
+
+type Episode struct {
+	ID            int      `json:"id"`
+	Title         string   `json:"title"`
+	Description   string   `json:"description"`
+	Available     bool     `json:"available"`
+	ReleaseDate   string   `json:"release_date"`
+	Duration      int      `json:"duration"`
+	Link          string   `json:"link"`
+	Share         string   `json:"share"`
+	Picture       string   `json:"picture"`
+	PictureSmall  string   `json:"picture_small"`
+	PictureMedium string   `json:"picture_medium"`
+	PictureBig    string   `json:"picture_big"`
+	PictureXL     string   `json:"picture_xl"`
+	Podcast       *Podcast `json:"podcast"`
+	Type          string   `json:"type"`
+}
@@ -0,0 +1,76 @@
+// Copyright (c) 2020-2024 Andrew Stormont
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package deezer
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Deezer allows 50 requests every 5 seconds, and answers any more
+// with a quota error.
+const (
+	rateLimitRequests = 50
+	rateLimitPeriod   = 5 * time.Second
+)
+
+// rateLimiter is a http.RoundTripper that holds requests back until
+// they're within the rate limit, rather than letting them fail.
+type rateLimiter struct {
+	next http.RoundTripper
+
+	mu   sync.Mutex
+	sent []time.Time // sent holds the times of the last requests, oldest first.
+}
+
+func newRateLimiter(next http.RoundTripper) *rateLimiter {
+	return &rateLimiter{next: next}
+}
+
+// reserve returns how long to wait before sending a request, and
+// records the request as sent at that time.
+func (r *rateLimiter) reserve(now time.Time) time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var wait time.Duration
+	if len(r.sent) == rateLimitRequests {
+		if at := r.sent[0].Add(rateLimitPeriod); at.After(now) {
+			wait = at.Sub(now)
+		}
+		r.sent = r.sent[1:]
+	}
+	r.sent = append(r.sent, now.Add(wait))
+	return wait
+}
+
+func (r *rateLimiter) RoundTrip(req *http.Request) (*http.Response, error) {
+	if wait := r.reserve(time.Now()); wait > 0 {
+		t := time.NewTimer(wait)
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+	return r.next.RoundTrip(req)
+}
@@ -0,0 +1,68 @@
+// Copyright (c) 2020-2024 Andrew Stormont
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package deezer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiterReserve(t *testing.T) {
+	// Each step sends count requests at an offset, all of which should
+	// have to wait for wait.
+	type step struct {
+		at    time.Duration
+		count int
+		wait  time.Duration
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"burst", []step{{0, rateLimitRequests, 0}}},
+		{"over the limit", []step{{0, rateLimitRequests, 0}, {0, 2, rateLimitPeriod}}},
+		{"held requests count", []step{
+			{0, rateLimitRequests, 0},
+			{0, rateLimitRequests, rateLimitPeriod},
+			{0, 1, 2 * rateLimitPeriod},
+		}},
+		{"part way through the period", []step{{0, rateLimitRequests, 0}, {3 * time.Second, 1, 2 * time.Second}}},
+		{"after the period", []step{{0, rateLimitRequests, 0}, {rateLimitPeriod, rateLimitRequests, 0}}},
+		{"spread out", []step{
+			{0, 25, 0},
+			{2 * time.Second, 25, 0},
+			{4 * time.Second, 25, time.Second},
+			{7 * time.Second, 25, 0},
+		}},
+	}
+	start := time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newRateLimiter(nil)
+			for i, s := range tt.steps {
+				for n := 0; n < s.count; n++ {
+					if wait := r.reserve(start.Add(s.at)); wait != s.wait {
+						t.Fatalf("step %d, request %d: wait = %v, want %v", i, n, wait, s.wait)
+					}
+				}
+			}
+		})
+	}
+}
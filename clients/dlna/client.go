@@ -0,0 +1,257 @@
+// Copyright (c) 2020-2024 Andrew Stormont
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package dlna
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"beoutil/clients/dlna/models"
+)
+
+type Server struct {
+	Location     string
+	FriendlyName string
+	UDN          string
+	ControlURL   string
+}
+
+type deviceDescription struct {
+	URLBase string `xml:"URLBase"`
+	Device  device `xml:"device"`
+}
+
+type device struct {
+	FriendlyName string    `xml:"friendlyName"`
+	UDN          string    `xml:"UDN"`
+	Services     []service `xml:"serviceList>service"`
+	Devices      []device  `xml:"deviceList>device"`
+}
+
+type service struct {
+	ServiceType string `xml:"serviceType"`
+	ControlURL  string `xml:"controlURL"`
+}
+
+// findContentDirectory searches a device and its embedded
+// devices for a ContentDirectory service.
+func findContentDirectory(d *device) (*device, *service) {
+	for i, s := range d.Services {
+		if strings.HasPrefix(s.ServiceType, "urn:schemas-upnp-org:service:ContentDirectory:") {
+			return d, &d.Services[i]
+		}
+	}
+	for i := range d.Devices {
+		if dev, s := findContentDirectory(&d.Devices[i]); s != nil {
+			return dev, s
+		}
+	}
+	return nil, nil
+}
+
+// GetServer fetches the device description at location.
+func GetServer(ctx context.Context, location string) (*Server, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d: %s", resp.StatusCode, resp.Status)
+	}
+	var desc deviceDescription
+	if err = xml.NewDecoder(resp.Body).Decode(&desc); err != nil {
+		return nil, err
+	}
+	dev, s := findContentDirectory(&desc.Device)
+	if s == nil {
+		return nil, errors.New("no ContentDirectory service found")
+	}
+	base := location
+	if desc.URLBase != "" {
+		base = desc.URLBase
+	}
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return nil, err
+	}
+	controlURL, err := baseURL.Parse(s.ControlURL)
+	if err != nil {
+		return nil, err
+	}
+	return &Server{
+		Location:     location,
+		FriendlyName: dev.FriendlyName,
+		UDN:          dev.UDN,
+		ControlURL:   controlURL.String(),
+	}, nil
+}
+
+type soapFault struct {
+	FaultString string `xml:"faultstring"`
+	Code        int    `xml:"detail>UPnPError>errorCode"`
+	Description string `xml:"detail>UPnPError>errorDescription"`
+}
+
+type soapEnvelope struct {
+	Body struct {
+		Fault    *soapFault `xml:"Fault"`
+		Response struct {
+			Result         string `xml:"Result"`
+			NumberReturned int    `xml:"NumberReturned"`
+			TotalMatches   int    `xml:"TotalMatches"`
+		} `xml:",any"`
+	} `xml:"Body"`
+}
+
+type Error struct {
+	Code        int
+	Description string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("upnp error %d: %s", e.Code, e.Description)
+}
+
+func escape(s string) string {
+	var b bytes.Buffer
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+func (s *Server) call(ctx context.Context, action string, args [][2]string) (*models.Result, error) {
+	var body strings.Builder
+	body.WriteString(`<?xml version="1.0" encoding="utf-8"?>`)
+	body.WriteString(`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" ` +
+		`s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body>`)
+	body.WriteString(`<u:` + action + ` xmlns:u="` + ContentDirectory + `">`)
+	for _, a := range args {
+		body.WriteString("<" + a[0] + ">" + escape(a[1]) + "</" + a[0] + ">")
+	}
+	body.WriteString(`</u:` + action + `></s:Body></s:Envelope>`)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.ControlURL, strings.NewReader(body.String()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", `"`+ContentDirectory+"#"+action+`"`)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var env soapEnvelope
+	if err = xml.Unmarshal(b, &env); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("status %d: %s", resp.StatusCode, resp.Status)
+		}
+		return nil, err
+	}
+	if f := env.Body.Fault; f != nil {
+		if f.Code != 0 {
+			return nil, &Error{Code: f.Code, Description: f.Description}
+		}
+		return nil, errors.New(f.FaultString)
+	}
+	var didl models.DIDLLite
+	if err = xml.Unmarshal([]byte(env.Body.Response.Result), &didl); err != nil {
+		return nil, err
+	}
+	return &models.Result{
+		Objects:        didl.Objects(),
+		NumberReturned: env.Body.Response.NumberReturned,
+		TotalMatches:   env.Body.Response.TotalMatches,
+	}, nil
+}
+
+// Browse lists the children of a container. The root container is "0".
+func (s *Server) Browse(ctx context.Context, objectID string, start, count int) (*models.Result, error) {
+	return s.call(ctx, "Browse", [][2]string{
+		{"ObjectID", objectID},
+		{"BrowseFlag", "BrowseDirectChildren"},
+		{"Filter", "*"},
+		{"StartingIndex", strconv.Itoa(start)},
+		{"RequestedCount", strconv.Itoa(count)},
+		{"SortCriteria", ""},
+	})
+}
+
+// GetObject returns the metadata for a single object.
+func (s *Server) GetObject(ctx context.Context, objectID string) (*models.Object, error) {
+	r, err := s.call(ctx, "Browse", [][2]string{
+		{"ObjectID", objectID},
+		{"BrowseFlag", "BrowseMetadata"},
+		{"Filter", "*"},
+		{"StartingIndex", "0"},
+		{"RequestedCount", "1"},
+		{"SortCriteria", ""},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(r.Objects) == 0 {
+		return nil, fmt.Errorf("object %q not found", objectID)
+	}
+	return &r.Objects[0], nil
+}
+
+// Search runs a ContentDirectory search below containerID using criteria
+// such as `upnp:artist contains "Tool"`.
+func (s *Server) Search(ctx context.Context, containerID, criteria string, start, count int) (*models.Result, error) {
+	return s.call(ctx, "Search", [][2]string{
+		{"ContainerID", containerID},
+		{"SearchCriteria", criteria},
+		{"Filter", "*"},
+		{"StartingIndex", strconv.Itoa(start)},
+		{"RequestedCount", strconv.Itoa(count)},
+		{"SortCriteria", ""},
+	})
+}
+
+// Criteria builds a search criteria string for audio items that
+// match all the given properties, e.g. {"upnp:artist": "Tool"}.
+func Criteria(props [][2]string) string {
+	c := `upnp:class derivedfrom "` + models.ClassAudioItem + `"`
+	for _, p := range props {
+		if p[1] == "" {
+			continue
+		}
+		v := strings.ReplaceAll(strings.ReplaceAll(p[1], `\`, `\\`), `"`, `\"`)
+		c += " and " + p[0] + ` contains "` + v + `"`
+	}
+	return c
+}
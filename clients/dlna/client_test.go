@@ -0,0 +1,172 @@
+// Copyright (c) 2020-2024 Andrew Stormont
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package dlna
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"beoutil/clients/dlna/models"
+)
+
+// newTestServer serves a small library through the stand-in server and
+// returns a client for it.
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+	dir := t.TempDir()
+	for _, f := range []string{
+		"Opeth/Damnation/01 Windowpane.mp3",
+		"Tool/Lateralus/01 The Grudge.flac",
+		"Tool/Lateralus/02 Eon Blue Apocalypse.mp3",
+		"Tool/Lateralus/notes.txt",
+	} {
+		path := filepath.Join(dir, filepath.FromSlash(f))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("audio"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	srv := httptest.NewUnstartedServer(nil)
+	srv.Start()
+	t.Cleanup(srv.Close)
+	s, err := NewStandInServer(dir, "Test Library", srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Config.Handler = s.Handler()
+	c, err := GetServer(context.Background(), srv.URL+"/description.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func titles(r *models.Result) []string {
+	var out []string
+	for _, o := range r.Objects {
+		out = append(out, o.Title)
+	}
+	return out
+}
+
+func TestGetServer(t *testing.T) {
+	s := newTestServer(t)
+	if s.FriendlyName != "Test Library" {
+		t.Errorf("FriendlyName = %q, want %q", s.FriendlyName, "Test Library")
+	}
+}
+
+func TestBrowse(t *testing.T) {
+	s := newTestServer(t)
+	tests := []struct {
+		name         string
+		id           string
+		start, count int
+		want         []string
+		total        int
+	}{
+		{name: "root", id: "0", want: []string{"Opeth", "Tool"}, total: 2},
+		{name: "album", id: "5", want: []string{"The Grudge", "Eon Blue Apocalypse"}, total: 2},
+		{name: "paged", id: "5", start: 1, count: 1, want: []string{"Eon Blue Apocalypse"}, total: 2},
+		{name: "past end", id: "5", start: 5, count: 1, want: nil, total: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := s.Browse(context.Background(), tt.id, tt.start, tt.count)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := titles(r); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("titles = %q, want %q", got, tt.want)
+			}
+			if r.NumberReturned != len(tt.want) || r.TotalMatches != tt.total {
+				t.Errorf("returned %d of %d, want %d of %d", r.NumberReturned, r.TotalMatches, len(tt.want), tt.total)
+			}
+		})
+	}
+}
+
+func TestGetObject(t *testing.T) {
+	s := newTestServer(t)
+	o, err := s.GetObject(context.Background(), "7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.Title != "Eon Blue Apocalypse" || o.Artist != "Tool" || o.Album != "Lateralus" || o.TrackNumber != 2 {
+		t.Errorf("got %q by %q on %q (#%d)", o.Title, o.Artist, o.Album, o.TrackNumber)
+	}
+	if !o.IsAudio() || len(o.Res) != 1 || o.Res[0].ProtocolInfo != "http-get:*:audio/mpeg:*" {
+		t.Errorf("unexpected class %q or resources %+v", o.Class, o.Res)
+	}
+	if _, err = s.GetObject(context.Background(), "99"); err == nil {
+		t.Error("expected an error for a missing object")
+	}
+}
+
+func TestSearch(t *testing.T) {
+	s := newTestServer(t)
+	tests := []struct {
+		name  string
+		props [][2]string
+		want  []string
+	}{
+		{name: "all", want: []string{"Windowpane", "The Grudge", "Eon Blue Apocalypse"}},
+		{name: "artist", props: [][2]string{{"upnp:artist", "tool"}}, want: []string{"The Grudge", "Eon Blue Apocalypse"}},
+		{name: "artist and title", props: [][2]string{{"upnp:artist", "Tool"}, {"dc:title", "eon"}}, want: []string{"Eon Blue Apocalypse"}},
+		{name: "empty ignored", props: [][2]string{{"upnp:album", ""}, {"dc:title", "window"}}, want: []string{"Windowpane"}},
+		{name: "quoted", props: [][2]string{{"dc:title", `"Grudge"`}}, want: nil},
+		{name: "no match", props: [][2]string{{"upnp:album", "Ænima"}}, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := s.Search(context.Background(), "0", Criteria(tt.props), 0, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := titles(r); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("titles = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCriteria(t *testing.T) {
+	tests := []struct {
+		props [][2]string
+		want  string
+	}{
+		{nil, `upnp:class derivedfrom "object.item.audioItem"`},
+		{[][2]string{{"upnp:artist", "Tool"}, {"upnp:album", ""}},
+			`upnp:class derivedfrom "object.item.audioItem" and upnp:artist contains "Tool"`},
+		{[][2]string{{"dc:title", `say "hi" \o/`}},
+			`upnp:class derivedfrom "object.item.audioItem" and dc:title contains "say \"hi\" \\o/"`},
+	}
+	for _, tt := range tests {
+		if got := Criteria(tt.props); got != tt.want {
+			t.Errorf("Criteria(%q) = %q, want %q", tt.props, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright (c) 2020-2024 Andrew Stormont
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package models
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ClassContainer  = "object.container"
+	ClassAudioItem  = "object.item.audioItem"
+	ClassMusicTrack = "object.item.audioItem.musicTrack"
+)
+
+//
+// ContentDirectory DIDL-Lite
+//
+
+type Res struct {
+	URL          string `xml:",chardata"`
+	ProtocolInfo string `xml:"protocolInfo,attr"`
+	Duration     string `xml:"duration,attr,omitempty"`
+	Size         int64  `xml:"size,attr,omitempty"`
+}
+
+type Object struct {
+	ID          string `xml:"id,attr"`
+	ParentID    string `xml:"parentID,attr"`
+	ChildCount  int    `xml:"childCount,attr,omitempty"`
+	Title       string `xml:"http://purl.org/dc/elements/1.1/ title"`
+	Creator     string `xml:"http://purl.org/dc/elements/1.1/ creator"`
+	Class       string `xml:"urn:schemas-upnp-org:metadata-1-0/upnp/ class"`
+	Artist      string `xml:"urn:schemas-upnp-org:metadata-1-0/upnp/ artist"`
+	Album       string `xml:"urn:schemas-upnp-org:metadata-1-0/upnp/ album"`
+	Genre       string `xml:"urn:schemas-upnp-org:metadata-1-0/upnp/ genre"`
+	AlbumArtURI string `xml:"urn:schemas-upnp-org:metadata-1-0/upnp/ albumArtURI"`
+	TrackNumber int    `xml:"urn:schemas-upnp-org:metadata-1-0/upnp/ originalTrackNumber"`
+	Res         []Res  `xml:"res"`
+}
+
+func (o *Object) IsContainer() bool {
+	return strings.HasPrefix(o.Class, ClassContainer)
+}
+
+func (o *Object) IsAudio() bool {
+	return strings.HasPrefix(o.Class, ClassAudioItem)
+}
+
+// ArtistName returns the artist, falling back to the creator.
+func (o *Object) ArtistName() string {
+	if o.Artist != "" {
+		return o.Artist
+	}
+	return o.Creator
+}
+
+// Duration returns the duration of the first resource.
+func (o *Object) Duration() time.Duration {
+	if len(o.Res) == 0 {
+		return 0
+	}
+	return ParseDuration(o.Res[0].Duration)
+}
+
+// ParseDuration parses a DIDL-Lite duration such as "0:03:25.000".
+func ParseDuration(s string) time.Duration {
+	var d time.Duration
+	for _, part := range strings.Split(s, ":") {
+		f, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return 0
+		}
+		d = d*60 + time.Duration(f*float64(time.Second))
+	}
+	return d
+}
+
+// FormatDuration formats a duration as expected in DIDL-Lite.
+func FormatDuration(d time.Duration) string {
+	h := int(d / time.Hour)
+	m := int(d/time.Minute) % 60
+	s := float64(d%time.Minute) / float64(time.Second)
+	return strconv.Itoa(h) + ":" + twoDigits(m) + ":" + strconv.FormatFloat(100+s, 'f', 3, 64)[1:]
+}
+
+func twoDigits(n int) string {
+	return strconv.Itoa(100 + n)[1:]
+}
+
+type DIDLLite struct {
+	Containers []Object `xml:"container"`
+	Items      []Object `xml:"item"`
+}
+
+// Objects returns containers followed by items.
+func (d *DIDLLite) Objects() []Object {
+	return append(append([]Object(nil), d.Containers...), d.Items...)
+}
+
+//
+// ContentDirectory Browse/Search responses
+//
+
+type Result struct {
+	Objects        []Object
+	NumberReturned int
+	TotalMatches   int
+}
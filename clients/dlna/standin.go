@@ -0,0 +1,394 @@
+// Copyright (c) 2020-2024 Andrew Stormont
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package dlna
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"net"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"beoutil/clients/dlna/models"
+)
+
+var audioExtensions = map[string]string{
+	".mp3":  "audio/mpeg",
+	".flac": "audio/flac",
+	".m4a":  "audio/mp4",
+	".aac":  "audio/aac",
+	".ogg":  "audio/ogg",
+	".wav":  "audio/wav",
+}
+
+type standInNode struct {
+	object   models.Object
+	path     string
+	children []*standInNode
+}
+
+// StandInServer is a minimal DLNA media server that serves the audio files
+// in a directory. Directories are exposed as containers, and files are
+// expected to be laid out as <artist>/<album>/<NN title>.<ext>. It's
+// intended for trying out beoutil without a real media server.
+type StandInServer struct {
+	FriendlyName string
+	UDN          string
+	baseURL      string
+	objects      map[string]*standInNode
+}
+
+// standInUDN derives a stable UDN from the directory being served.
+func standInUDN(dir string) string {
+	h := fnv.New128a()
+	_, _ = h.Write([]byte(dir))
+	b := h.Sum(nil)
+	return fmt.Sprintf("uuid:%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+var trackNumberRe = regexp.MustCompile(`^(\d+)[ .\-_]+`)
+
+// NewStandInServer indexes dir. Media URLs handed out to clients will
+// start with baseURL, e.g. http://192.168.0.10:8200.
+func NewStandInServer(dir, friendlyName, baseURL string) (*StandInServer, error) {
+	s := &StandInServer{
+		FriendlyName: friendlyName,
+		UDN:          standInUDN(dir),
+		baseURL:      strings.TrimSuffix(baseURL, "/"),
+		objects:      make(map[string]*standInNode),
+	}
+	next := 0
+	nodes := make(map[string]*standInNode)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		if rel == "." {
+			n := &standInNode{object: models.Object{ID: "0", ParentID: "-1", Title: friendlyName, Class: models.ClassContainer}}
+			nodes[path] = n
+			s.objects["0"] = n
+			return nil
+		}
+		parent := nodes[filepath.Dir(path)]
+		if parent == nil {
+			return nil
+		}
+		next++
+		n := &standInNode{path: path}
+		n.object.ID = strconv.Itoa(next)
+		n.object.ParentID = parent.object.ID
+		if d.IsDir() {
+			n.object.Title = d.Name()
+			n.object.Class = models.ClassContainer
+			nodes[path] = n
+		} else {
+			ext := strings.ToLower(filepath.Ext(path))
+			mime, ok := audioExtensions[ext]
+			if !ok {
+				return nil
+			}
+			title := strings.TrimSuffix(d.Name(), filepath.Ext(path))
+			if m := trackNumberRe.FindStringSubmatch(title); m != nil {
+				n.object.TrackNumber, _ = strconv.Atoi(m[1])
+				title = title[len(m[0]):]
+			}
+			parts := strings.Split(filepath.ToSlash(rel), "/")
+			if len(parts) >= 2 {
+				n.object.Album = parts[len(parts)-2]
+			}
+			if len(parts) >= 3 {
+				n.object.Artist = parts[len(parts)-3]
+				n.object.Creator = n.object.Artist
+			}
+			n.object.Title = title
+			n.object.Class = models.ClassMusicTrack
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			n.object.Res = []models.Res{{
+				URL:          s.baseURL + "/media/" + n.object.ID + ext,
+				ProtocolInfo: "http-get:*:" + mime + ":*",
+				Size:         info.Size(),
+			}}
+		}
+		parent.children = append(parent.children, n)
+		parent.object.ChildCount++
+		s.objects[n.object.ID] = n
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *StandInServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/description.xml", s.serveDescription)
+	mux.HandleFunc("/ctl", s.serveControl)
+	mux.HandleFunc("/media/", s.serveMedia)
+	return mux
+}
+
+func (s *StandInServer) serveDescription(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	_, _ = fmt.Fprintf(w, `<?xml version="1.0" encoding="utf-8"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+<specVersion><major>1</major><minor>0</minor></specVersion>
+<device>
+<deviceType>%s</deviceType>
+<friendlyName>%s</friendlyName>
+<manufacturer>beoutil</manufacturer>
+<modelName>beoutil stand-in media server</modelName>
+<UDN>%s</UDN>
+<serviceList>
+<service>
+<serviceType>%s</serviceType>
+<serviceId>urn:upnp-org:serviceId:ContentDirectory</serviceId>
+<SCPDURL>/cds.xml</SCPDURL>
+<controlURL>/ctl</controlURL>
+<eventSubURL>/evt</eventSubURL>
+</service>
+</serviceList>
+</device>
+</root>
+`, MediaServer, escape(s.FriendlyName), s.UDN, ContentDirectory)
+}
+
+func (s *StandInServer) serveMedia(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/media/")
+	id = strings.TrimSuffix(id, filepath.Ext(id))
+	n, ok := s.objects[id]
+	if !ok || n.path == "" || n.object.IsContainer() {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeFile(w, r, n.path)
+}
+
+type standInRequest struct {
+	Body struct {
+		Action struct {
+			XMLName        xml.Name
+			ObjectID       string `xml:"ObjectID"`
+			ContainerID    string `xml:"ContainerID"`
+			BrowseFlag     string `xml:"BrowseFlag"`
+			SearchCriteria string `xml:"SearchCriteria"`
+			StartingIndex  int    `xml:"StartingIndex"`
+			RequestedCount int    `xml:"RequestedCount"`
+		} `xml:",any"`
+	} `xml:"Body"`
+}
+
+var criteriaRe = regexp.MustCompile(`([a-zA-Z]+:[a-zA-Z]+) contains "((?:[^"\\]|\\.)*)"`)
+
+func objectProperty(o *models.Object, prop string) string {
+	switch prop {
+	case "dc:title":
+		return o.Title
+	case "dc:creator":
+		return o.Creator
+	case "upnp:artist":
+		return o.Artist
+	case "upnp:album":
+		return o.Album
+	case "upnp:genre":
+		return o.Genre
+	}
+	return ""
+}
+
+func (s *StandInServer) search(n *standInNode, criteria [][]string, out *[]*standInNode) {
+	for _, c := range n.children {
+		if c.object.IsContainer() {
+			s.search(c, criteria, out)
+			continue
+		}
+		match := true
+		for _, m := range criteria {
+			v := strings.ReplaceAll(strings.ReplaceAll(m[2], `\"`, `"`), `\\`, `\`)
+			if !strings.Contains(strings.ToLower(objectProperty(&c.object, m[1])), strings.ToLower(v)) {
+				match = false
+				break
+			}
+		}
+		if match {
+			*out = append(*out, c)
+		}
+	}
+}
+
+func soapFaultResponse(w http.ResponseWriter, code int, desc string) {
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	w.WriteHeader(http.StatusInternalServerError)
+	_, _ = fmt.Fprintf(w, `<?xml version="1.0" encoding="utf-8"?>`+
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body><s:Fault>`+
+		`<faultcode>s:Client</faultcode><faultstring>UPnPError</faultstring><detail>`+
+		`<UPnPError xmlns="urn:schemas-upnp-org:control-1-0"><errorCode>%d</errorCode>`+
+		`<errorDescription>%s</errorDescription></UPnPError></detail></s:Fault></s:Body></s:Envelope>`,
+		code, escape(desc))
+}
+
+func (s *StandInServer) serveControl(w http.ResponseWriter, r *http.Request) {
+	var req standInRequest
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+		soapFaultResponse(w, 402, "Invalid Args")
+		return
+	}
+	a := req.Body.Action
+	var matches []*standInNode
+	switch a.XMLName.Local {
+	case "Browse":
+		n, ok := s.objects[a.ObjectID]
+		if !ok {
+			soapFaultResponse(w, 701, "No such object")
+			return
+		}
+		if a.BrowseFlag == "BrowseMetadata" {
+			matches = []*standInNode{n}
+		} else {
+			matches = n.children
+		}
+	case "Search":
+		n, ok := s.objects[a.ContainerID]
+		if !ok {
+			soapFaultResponse(w, 710, "No such container")
+			return
+		}
+		s.search(n, criteriaRe.FindAllStringSubmatch(a.SearchCriteria, -1), &matches)
+	default:
+		soapFaultResponse(w, 401, "Invalid Action")
+		return
+	}
+	total := len(matches)
+	if a.StartingIndex > len(matches) {
+		a.StartingIndex = len(matches)
+	}
+	matches = matches[a.StartingIndex:]
+	if a.RequestedCount > 0 && a.RequestedCount < len(matches) {
+		matches = matches[:a.RequestedCount]
+	}
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	_, _ = fmt.Fprintf(w, `<?xml version="1.0" encoding="utf-8"?>`+
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">`+
+		`<s:Body><u:%sResponse xmlns:u="%s"><Result>%s</Result><NumberReturned>%d</NumberReturned>`+
+		`<TotalMatches>%d</TotalMatches><UpdateID>1</UpdateID></u:%sResponse></s:Body></s:Envelope>`,
+		a.XMLName.Local, ContentDirectory, escape(renderDIDL(matches)), len(matches), total, a.XMLName.Local)
+}
+
+func renderDIDL(nodes []*standInNode) string {
+	var b strings.Builder
+	b.WriteString(`<DIDL-Lite xmlns="urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/" ` +
+		`xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:upnp="urn:schemas-upnp-org:metadata-1-0/upnp/">`)
+	for _, n := range nodes {
+		o := &n.object
+		elem := "item"
+		if o.IsContainer() {
+			elem = "container"
+		}
+		_, _ = fmt.Fprintf(&b, `<%s id="%s" parentID="%s" restricted="1"`, elem, escape(o.ID), escape(o.ParentID))
+		if o.IsContainer() {
+			_, _ = fmt.Fprintf(&b, ` childCount="%d"`, o.ChildCount)
+		}
+		b.WriteString(">")
+		_, _ = fmt.Fprintf(&b, `<dc:title>%s</dc:title><upnp:class>%s</upnp:class>`, escape(o.Title), o.Class)
+		if o.Creator != "" {
+			_, _ = fmt.Fprintf(&b, `<dc:creator>%s</dc:creator>`, escape(o.Creator))
+		}
+		if o.Artist != "" {
+			_, _ = fmt.Fprintf(&b, `<upnp:artist>%s</upnp:artist>`, escape(o.Artist))
+		}
+		if o.Album != "" {
+			_, _ = fmt.Fprintf(&b, `<upnp:album>%s</upnp:album>`, escape(o.Album))
+		}
+		if o.TrackNumber != 0 {
+			_, _ = fmt.Fprintf(&b, `<upnp:originalTrackNumber>%d</upnp:originalTrackNumber>`, o.TrackNumber)
+		}
+		for _, r := range o.Res {
+			_, _ = fmt.Fprintf(&b, `<res protocolInfo="%s" size="%d">%s</res>`, escape(r.ProtocolInfo), r.Size, escape(r.URL))
+		}
+		_, _ = fmt.Fprintf(&b, "</%s>", elem)
+	}
+	b.WriteString("</DIDL-Lite>")
+	return b.String()
+}
+
+// ServeSSDP answers SSDP searches for the server until ctx is done.
+func (s *StandInServer) ServeSSDP(ctx context.Context) error {
+	group, err := net.ResolveUDPAddr("udp4", ssdpAddr)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenMulticastUDP("udp4", nil, group)
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		_ = conn.Close()
+	}()
+	buf := make([]byte, 2048)
+	for {
+		n, src, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			continue
+		}
+		req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(buf[:n])))
+		if err != nil || req.Method != "M-SEARCH" {
+			continue
+		}
+		st := req.Header.Get("St")
+		switch st {
+		case "ssdp:all", "upnp:rootdevice", MediaServer, ContentDirectory:
+		default:
+			continue
+		}
+		if st == "ssdp:all" {
+			st = ContentDirectory
+		}
+		resp := "HTTP/1.1 200 OK\r\n" +
+			"CACHE-CONTROL: max-age=1800\r\n" +
+			"EXT:\r\n" +
+			"LOCATION: " + s.baseURL + "/description.xml\r\n" +
+			"SERVER: " + runtime.GOOS + " UPnP/1.0 beoutil/1.0\r\n" +
+			"ST: " + st + "\r\n" +
+			"USN: " + s.UDN + "::" + st + "\r\n\r\n"
+		_, _ = conn.WriteToUDP([]byte(resp), src)
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright (c) 2020-2024 Andrew Stormont
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package listenbrainz
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"beoutil/clients/listenbrainz/models"
+)
+
+const DefaultEndpoint = "https://api.listenbrainz.org"
+
+// MaxListensPerSubmission is the most listens the API accepts at once.
+const MaxListensPerSubmission = 1000
+
+type Client struct {
+	client   *http.Client
+	endpoint string
+	token    string
+}
+
+// NewClient returns a client for a ListenBrainz compatible server at
+// endpoint, authenticating with a user token.
+func NewClient(endpoint, token string) *Client {
+	if endpoint == "" {
+		endpoint = DefaultEndpoint
+	}
+	return &Client{
+		client:   new(http.Client),
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		token:    token,
+	}
+}
+
+// Error is returned when the server rejects a request.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("listenbrainz: status %d: %s", e.StatusCode, e.Message)
+}
+
+// Temporary reports whether the request may succeed if tried again.
+func (e *Error) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+func (c *Client) do(ctx context.Context, method, path string, v interface{}) ([]byte, error) {
+	var body io.Reader
+	if v != nil {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.endpoint+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Token "+c.token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	res, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		e := &Error{StatusCode: resp.StatusCode, Message: resp.Status}
+		var errResp models.ErrorResponse
+		if json.Unmarshal(res, &errResp) == nil && errResp.Error != "" {
+			e.Message = errResp.Error
+		}
+		return nil, e
+	}
+	return res, nil
+}
+
+// ValidateToken checks the token, returning the name of the user it
+// belongs to.
+func (c *Client) ValidateToken(ctx context.Context) (string, error) {
+	b, err := c.do(ctx, http.MethodGet, "/1/validate-token", nil)
+	if err != nil {
+		return "", err
+	}
+	var resp struct {
+		Valid    bool   `json:"valid"`
+		Message  string `json:"message"`
+		UserName string `json:"user_name"`
+	}
+	if err = json.Unmarshal(b, &resp); err != nil {
+		return "", err
+	}
+	if !resp.Valid {
+		return "", &Error{StatusCode: http.StatusUnauthorized, Message: resp.Message}
+	}
+	return resp.UserName, nil
+}
+
+// PlayingNow tells the server what is playing right now.
+func (c *Client) PlayingNow(ctx context.Context, track models.TrackMetadata) error {
+	_, err := c.do(ctx, http.MethodPost, "/1/submit-listens", &models.Submission{
+		ListenType: models.ListenTypePlayingNow,
+		Payload:    []models.Listen{{TrackMetadata: track}},
+	})
+	return err
+}
+
+// SubmitListens submits completed listens. A single listen is submitted
+// as such, and several as an import.
+func (c *Client) SubmitListens(ctx context.Context, listens []models.Listen) error {
+	listenType := models.ListenTypeImport
+	if len(listens) == 1 {
+		listenType = models.ListenTypeSingle
+	}
+	_, err := c.do(ctx, http.MethodPost, "/1/submit-listens", &models.Submission{
+		ListenType: listenType,
+		Payload:    listens,
+	})
+	return err
+}
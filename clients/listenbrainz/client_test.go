@@ -0,0 +1,157 @@
+// Copyright (c) 2020-2024 Andrew Stormont
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package listenbrainz
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"beoutil/clients/listenbrainz/models"
+)
+
+// fakeServer is a stand-in for the ListenBrainz API that records the
+// submissions it's sent, or fails them with status if it's set.
+type fakeServer struct {
+	status      int
+	submissions []models.Submission
+}
+
+func (f *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Header.Get("Authorization") != "Token secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte(`{"code": 401, "error": "Invalid authorization token."}`))
+		return
+	}
+	if f.status != 0 {
+		w.WriteHeader(f.status)
+		_, _ = w.Write([]byte(`{"code": 0, "error": "failed"}`))
+		return
+	}
+	switch r.URL.Path {
+	case "/1/validate-token":
+		_, _ = w.Write([]byte(`{"code": 200, "valid": true, "user_name": "alice"}`))
+	case "/1/submit-listens":
+		var s models.Submission
+		if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.submissions = append(f.submissions, s)
+		_, _ = w.Write([]byte(`{"status": "ok"}`))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newTestClient(t *testing.T, token string) (*Client, *fakeServer) {
+	t.Helper()
+	f := &fakeServer{}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return NewClient(srv.URL+"/", token), f
+}
+
+func TestValidateToken(t *testing.T) {
+	c, _ := newTestClient(t, "secret")
+	user, err := c.ValidateToken(context.Background())
+	if err != nil || user != "alice" {
+		t.Errorf("ValidateToken() = %q, %v, want alice", user, err)
+	}
+	c, _ = newTestClient(t, "wrong")
+	_, err = c.ValidateToken(context.Background())
+	var lbErr *Error
+	if !errors.As(err, &lbErr) || lbErr.StatusCode != http.StatusUnauthorized ||
+		lbErr.Message != "Invalid authorization token." || lbErr.Temporary() {
+		t.Errorf("ValidateToken() error = %v, want a permanent 401", err)
+	}
+}
+
+func TestSubmitListens(t *testing.T) {
+	listen := models.Listen{ListenedAt: 1700000000, TrackMetadata: models.TrackMetadata{
+		ArtistName: "Tool",
+		TrackName:  "Schism",
+	}}
+	tests := []struct {
+		name    string
+		listens []models.Listen
+		want    models.ListenType
+	}{
+		{"single", []models.Listen{listen}, models.ListenTypeSingle},
+		{"import", []models.Listen{listen, listen}, models.ListenTypeImport},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, f := newTestClient(t, "secret")
+			if err := c.SubmitListens(context.Background(), tt.listens); err != nil {
+				t.Fatal(err)
+			}
+			if len(f.submissions) != 1 {
+				t.Fatalf("got %d submissions, want 1", len(f.submissions))
+			}
+			s := f.submissions[0]
+			if s.ListenType != tt.want || len(s.Payload) != len(tt.listens) || s.Payload[0] != listen {
+				t.Errorf("got %+v", s)
+			}
+		})
+	}
+}
+
+func TestPlayingNow(t *testing.T) {
+	c, f := newTestClient(t, "secret")
+	track := models.TrackMetadata{ArtistName: "Tool", TrackName: "Schism"}
+	if err := c.PlayingNow(context.Background(), track); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.submissions) != 1 || f.submissions[0].ListenType != models.ListenTypePlayingNow ||
+		f.submissions[0].Payload[0].ListenedAt != 0 || f.submissions[0].Payload[0].TrackMetadata != track {
+		t.Errorf("got %+v", f.submissions)
+	}
+}
+
+func TestErrorTemporary(t *testing.T) {
+	tests := []struct {
+		status int
+		want   bool
+	}{
+		{http.StatusBadRequest, false},
+		{http.StatusUnauthorized, false},
+		{http.StatusTooManyRequests, true},
+		{http.StatusInternalServerError, true},
+		{http.StatusServiceUnavailable, true},
+	}
+	for _, tt := range tests {
+		c, f := newTestClient(t, "secret")
+		f.status = tt.status
+		err := c.SubmitListens(context.Background(), []models.Listen{{}})
+		var lbErr *Error
+		if !errors.As(err, &lbErr) || lbErr.StatusCode != tt.status || lbErr.Message != "failed" {
+			t.Errorf("status %d: error = %v", tt.status, err)
+			continue
+		}
+		if got := lbErr.Temporary(); got != tt.want {
+			t.Errorf("status %d: Temporary() = %t, want %t", tt.status, got, tt.want)
+		}
+	}
+}
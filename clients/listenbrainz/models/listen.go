@@ -0,0 +1,60 @@
+// Copyright (c) 2020-2024 Andrew Stormont
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package models
+
+type ListenType string
+
+const (
+	ListenTypePlayingNow ListenType = "playing_now"
+	ListenTypeSingle     ListenType = "single"
+	ListenTypeImport     ListenType = "import"
+)
+
+type AdditionalInfo struct {
+	DurationMs       int    `json:"duration_ms,omitempty"`
+	TrackNumber      int    `json:"tracknumber,omitempty"`
+	OriginURL        string `json:"origin_url,omitempty"`
+	MusicService     string `json:"music_service,omitempty"`
+	MediaPlayer      string `json:"media_player,omitempty"`
+	SubmissionClient string `json:"submission_client,omitempty"`
+}
+
+type TrackMetadata struct {
+	ArtistName     string         `json:"artist_name"`
+	TrackName      string         `json:"track_name"`
+	ReleaseName    string         `json:"release_name,omitempty"`
+	AdditionalInfo AdditionalInfo `json:"additional_info,omitempty"`
+}
+
+type Listen struct {
+	ListenedAt    int64         `json:"listened_at,omitempty"` // ListenedAt is a unix timestamp, omitted for playing_now.
+	TrackMetadata TrackMetadata `json:"track_metadata"`
+}
+
+type Submission struct {
+	ListenType ListenType `json:"listen_type"`
+	Payload    []Listen   `json:"payload"`
+}
+
+type ErrorResponse struct {
+	Code  int    `json:"code"`
+	Error string `json:"error"`
+}
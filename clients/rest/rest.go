@@ -48,9 +48,16 @@ func NewJSONClient() Client {
 	return &jsonClient{client: new(http.Client)}
 }
 
+// NewJSONClientWithTransport returns a client that makes requests
+// through rt, for caching, rate limiting and so on.
+func NewJSONClientWithTransport(rt http.RoundTripper) Client {
+	return &jsonClient{client: &http.Client{Transport: rt}}
+}
+
 type HttpError struct {
 	StatusCode int
 	Status     string
+	Path       string // Path is the path of the request that failed.
 }
 
 func (e *HttpError) Error() string {
@@ -58,7 +65,11 @@ func (e *HttpError) Error() string {
 }
 
 func newHTTPError(resp *http.Response) error {
-	return &HttpError{StatusCode: resp.StatusCode, Status: resp.Status}
+	e := &HttpError{StatusCode: resp.StatusCode, Status: resp.Status}
+	if resp.Request != nil {
+		e.Path = resp.Request.URL.Path
+	}
+	return e
 }
 
 func (c *jsonClient) doRequest(req *http.Request) ([]byte, error) {
@@ -77,6 +88,8 @@ func (c *jsonClient) doRequest(req *http.Request) ([]byte, error) {
 			// FIXME: This is beoremote specific.
 			var errResponse models.ErrorResponse
 			if json.Unmarshal(res, &errResponse) == nil {
+				errResponse.Error.StatusCode = resp.StatusCode
+				errResponse.Error.Path = req.URL.Path
 				return nil, &errResponse.Error
 			}
 		}
@@ -0,0 +1,368 @@
+// Copyright (c) 2020-2024 Andrew Stormont
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"beoutil/clients/beoremote"
+
+	"github.com/urfave/cli/v2"
+)
+
+type DeezerConfig struct {
+	Market   string `json:"market,omitempty"`   // Market is an ISO 3166-1 country code, e.g. "DK".
+	Explicit *bool  `json:"explicit,omitempty"` // Explicit allows tracks with explicit lyrics.
+}
+
+// WebhookConfig is a URL that the webhook command forwards notifications to.
+type WebhookConfig struct {
+	URL      string   `json:"url"`
+	Products []string `json:"products,omitempty"` // Products limits which products are forwarded.
+	Match    []string `json:"match,omitempty"`    // Match is a list of key=value notification conditions.
+	Template string   `json:"template,omitempty"` // Template is a text/template used for the payload.
+	Secret   string   `json:"secret,omitempty"`   // Secret is used to sign payloads with HMAC-SHA256.
+}
+
+// ListenBrainzConfig is used by the scrobble command.
+type ListenBrainzConfig struct {
+	Endpoint string `json:"endpoint,omitempty"` // Endpoint is the API root of a ListenBrainz compatible server.
+	Token    string `json:"token,omitempty"`    // Token is the user token.
+}
+
+// Config is read from config.json in the beoutil config directory.
+// Products may be referred to by alias, name, JID or IP throughout.
+type Config struct {
+	DefaultProduct string             `json:"defaultProduct,omitempty"`
+	Aliases        map[string]string  `json:"aliases,omitempty"`
+	Play           string             `json:"play,omitempty"`
+	Deezer         DeezerConfig       `json:"deezer"`
+	VolumeLimits   map[string]int     `json:"volumeLimits,omitempty"`
+	Webhooks       []WebhookConfig    `json:"webhooks,omitempty"`
+	ListenBrainz   ListenBrainzConfig `json:"listenbrainz"`
+	Profiles       map[string]*Config `json:"profiles,omitempty"`
+}
+
+// apply overrides c with any settings made in profile.
+func (c *Config) apply(profile *Config) {
+	if profile.DefaultProduct != "" {
+		c.DefaultProduct = profile.DefaultProduct
+	}
+	if profile.Play != "" {
+		c.Play = profile.Play
+	}
+	if profile.Deezer.Market != "" {
+		c.Deezer.Market = profile.Deezer.Market
+	}
+	if profile.Deezer.Explicit != nil {
+		c.Deezer.Explicit = profile.Deezer.Explicit
+	}
+	if c.Aliases == nil {
+		c.Aliases = make(map[string]string)
+	}
+	for k, v := range profile.Aliases {
+		c.Aliases[k] = v
+	}
+	if c.VolumeLimits == nil {
+		c.VolumeLimits = make(map[string]int)
+	}
+	for k, v := range profile.VolumeLimits {
+		c.VolumeLimits[k] = v
+	}
+	if profile.ListenBrainz.Endpoint != "" {
+		c.ListenBrainz.Endpoint = profile.ListenBrainz.Endpoint
+	}
+	if profile.ListenBrainz.Token != "" {
+		c.ListenBrainz.Token = profile.ListenBrainz.Token
+	}
+	if len(profile.Webhooks) > 0 {
+		c.Webhooks = profile.Webhooks
+	}
+}
+
+// config is loaded before any command runs.
+var config Config
+
+// getConfigDir returns $XDG_CONFIG_HOME/beoutil, or the
+// platform equivalent, creating it if it doesn't exist.
+func getConfigDir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get user config dir: %w", err)
+	}
+	dir = filepath.Join(dir, "beoutil")
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// getStatePath returns the path of a file in the config directory. If the
+// file doesn't exist yet, but legacy does in the home directory, legacy is
+// moved there first.
+func getStatePath(name, legacy string) (string, error) {
+	dir, err := getConfigDir()
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, name)
+	if _, err = os.Stat(path); !os.IsNotExist(err) {
+		return path, err
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path, nil
+	}
+	old := filepath.Join(home, legacy)
+	if _, err = os.Stat(old); err != nil {
+		return path, nil
+	}
+	if err = moveFile(old, path); err != nil {
+		return "", fmt.Errorf("failed to migrate %s: %w", old, err)
+	}
+	_, _ = fmt.Fprintf(os.Stderr, "Moved %s to %s.\n", old, path)
+	return path, nil
+}
+
+func moveFile(src, dst string) error {
+	if os.Rename(src, dst) == nil {
+		return nil
+	}
+	// Renaming fails across file systems, so fall back to copying.
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		_ = out.Close()
+		_ = os.Remove(dst)
+		return err
+	}
+	if err = out.Close(); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+func getCachePath() (string, error) {
+	return getStatePath("products.json", ".beoutil")
+}
+
+// loadConfig reads the config file, applies the selected profile, and then
+// applies any overrides from the environment. A missing config file is
+// only an error if the path was given explicitly.
+func loadConfig(c *cli.Context) error {
+	config = Config{}
+	path := c.String("config")
+	if path == "" {
+		dir, err := getConfigDir()
+		if err != nil {
+			return err
+		}
+		path = filepath.Join(dir, "config.json")
+	}
+	b, err := os.ReadFile(path)
+	if err != nil && !(os.IsNotExist(err) && !c.IsSet("config")) {
+		return err
+	}
+	if err == nil {
+		if err = json.Unmarshal(b, &config); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	if name := c.String("profile"); name != "" {
+		profile, ok := config.Profiles[name]
+		if !ok {
+			return fmt.Errorf("no profile named %q in %s", name, path)
+		}
+		config.apply(profile)
+	}
+	if v := os.Getenv("BEOUTIL_PRODUCT"); v != "" {
+		config.DefaultProduct = v
+	}
+	if v := os.Getenv("BEOUTIL_PLAY"); v != "" {
+		config.Play = v
+	}
+	if v := os.Getenv("BEOUTIL_DEEZER_MARKET"); v != "" {
+		config.Deezer.Market = v
+	}
+	if v := os.Getenv("BEOUTIL_DEEZER_EXPLICIT"); v != "" {
+		explicit, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("BEOUTIL_DEEZER_EXPLICIT: %w", err)
+		}
+		config.Deezer.Explicit = &explicit
+	}
+	switch config.Play {
+	case "", "now", "next", "last":
+	default:
+		return fmt.Errorf("invalid play mode in config: %q", config.Play)
+	}
+	return nil
+}
+
+var (
+	resolveMu sync.Mutex
+	resolved  = make(map[string]string)
+	clients   = make(map[string]*beoremote.Client)
+)
+
+// resolveProduct turns an alias, product name, JID or IP into an address.
+// Names and JIDs are looked up in the product cache. Results are kept for
+// the life of the process, so scripts don't pay for repeated lookups.
+func resolveProduct(s string) (string, error) {
+	resolveMu.Lock()
+	defer resolveMu.Unlock()
+	if addr, ok := resolved[s]; ok {
+		return addr, nil
+	}
+	addr, err := lookupProduct(s)
+	if err != nil {
+		return "", err
+	}
+	resolved[s] = addr
+	return addr, nil
+}
+
+func lookupProduct(s string) (string, error) {
+	if s == "" {
+		s = config.DefaultProduct
+		if s == "" {
+			return "", errors.New("no product given and no default product configured")
+		}
+	}
+	for alias, v := range config.Aliases {
+		if strings.EqualFold(alias, s) {
+			s = v
+			break
+		}
+	}
+	if net.ParseIP(s) != nil {
+		return s, nil
+	}
+	products, err := getCachedProducts()
+	if err != nil {
+		return s, nil
+	}
+	for jid, p := range products {
+		if (string(jid) == s || strings.EqualFold(p.Name, s)) && len(p.IPs) > 0 {
+			return p.IPs[0].String(), nil
+		}
+	}
+	// Assume anything else is a host name.
+	return s, nil
+}
+
+// getClient returns a client for a product, reusing an existing one
+// if the product has been used before.
+func getClient(product string) (*beoremote.Client, error) {
+	addr, err := resolveProduct(product)
+	if err != nil {
+		return nil, err
+	}
+	resolveMu.Lock()
+	defer resolveMu.Unlock()
+	br, ok := clients[addr]
+	if !ok {
+		br = beoremote.NewClient(addr)
+		clients[addr] = br
+	}
+	return br, nil
+}
+
+// argList implements cli.Args.
+type argList []string
+
+func (a argList) Get(n int) string {
+	if n < len(a) {
+		return a[n]
+	}
+	return ""
+}
+
+func (a argList) First() string {
+	return a.Get(0)
+}
+
+func (a argList) Tail() []string {
+	if len(a) < 2 {
+		return []string{}
+	}
+	return a[1:]
+}
+
+func (a argList) Len() int {
+	return len(a)
+}
+
+func (a argList) Present() bool {
+	return len(a) != 0
+}
+
+func (a argList) Slice() []string {
+	return a
+}
+
+// productArgs returns the arguments for a command that takes a product
+// followed by n-1 other arguments. The product may be left out if a
+// default product is configured.
+func productArgs(c *cli.Context, n int) cli.Args {
+	args := c.Args().Slice()
+	if len(args) == n-1 && config.DefaultProduct != "" {
+		args = append([]string{config.DefaultProduct}, args...)
+	}
+	if len(args) != n {
+		cli.ShowSubcommandHelpAndExit(c, 1)
+	}
+	return argList(args)
+}
+
+// playMode returns the --play flag, falling back to the configured default.
+func playMode(c *cli.Context) string {
+	if !c.IsSet("play") && config.Play != "" {
+		return config.Play
+	}
+	return c.String("play")
+}
+
+// volumeLimit returns the configured maximum volume for the product at addr.
+func volumeLimit(addr string) (int, bool) {
+	for product, limit := range config.VolumeLimits {
+		if a, err := resolveProduct(product); err == nil && a == addr {
+			return limit, true
+		}
+	}
+	return 0, false
+}
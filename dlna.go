@@ -0,0 +1,331 @@
+// Copyright (c) 2020-2024 Andrew Stormont
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"beoutil/clients/beoremote"
+	"beoutil/clients/beoremote/models"
+	"beoutil/clients/dlna"
+	dlnaModels "beoutil/clients/dlna/models"
+
+	"github.com/urfave/cli/v2"
+)
+
+func discoverDLNAServers(ctx context.Context) ([]*dlna.Server, error) {
+	locations, err := dlna.Discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var servers []*dlna.Server
+	for _, l := range locations {
+		s, err := dlna.GetServer(ctx, l)
+		if err != nil {
+			continue
+		}
+		servers = append(servers, s)
+	}
+	return servers, nil
+}
+
+// resolveDLNAServer accepts a description URL, or the friendly
+// name or UDN of a server that can be discovered using SSDP.
+func resolveDLNAServer(c *cli.Context, s string) (*dlna.Server, error) {
+	if strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://") {
+		return dlna.GetServer(c.Context, s)
+	}
+	ctx, cancel := context.WithTimeout(c.Context, c.Duration("timeout"))
+	defer cancel()
+	servers, err := discoverDLNAServers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, server := range servers {
+		if strings.EqualFold(server.FriendlyName, s) || server.UDN == s {
+			return server, nil
+		}
+	}
+	return nil, fmt.Errorf("media server %q not found", s)
+}
+
+func doListDLNAServers(c *cli.Context) error {
+	if c.NArg() != 0 {
+		cli.ShowSubcommandHelpAndExit(c, 1)
+	}
+	_, _ = fmt.Fprintf(os.Stderr, "Scanning for media servers...\n")
+	ctx, cancel := context.WithTimeout(c.Context, c.Duration("timeout"))
+	defer cancel()
+	servers, err := discoverDLNAServers(ctx)
+	if err != nil {
+		return err
+	}
+	if len(servers) == 0 {
+		_, _ = fmt.Fprintln(os.Stderr, "No media servers found.")
+		return nil
+	}
+	tw := new(tabwriter.Writer)
+	tw.Init(os.Stdout, 8, 4, 1, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "NAME\tUDN\tLOCATION")
+	for _, s := range servers {
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\n", s.FriendlyName, s.UDN, s.Location)
+	}
+	_ = tw.Flush()
+	return nil
+}
+
+func printDLNAObjects(objects []dlnaModels.Object) {
+	if len(objects) == 0 {
+		_, _ = fmt.Fprintln(os.Stderr, "No items found.")
+		return
+	}
+	tw := new(tabwriter.Writer)
+	tw.Init(os.Stdout, 8, 4, 1, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "ID\tTYPE\tTITLE\tARTIST\tALBUM")
+	for _, o := range objects {
+		kind := "item"
+		if o.IsContainer() {
+			kind = "folder"
+		} else if o.IsAudio() {
+			kind = "track"
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", o.ID, kind, o.Title, o.ArtistName(), o.Album)
+	}
+	_ = tw.Flush()
+}
+
+// browseAll fetches every child of a container, one page at a time.
+func browseAll(ctx context.Context, s *dlna.Server, id string) ([]dlnaModels.Object, error) {
+	const pageSize = 100
+	var objects []dlnaModels.Object
+	for {
+		r, err := s.Browse(ctx, id, len(objects), pageSize)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, r.Objects...)
+		if r.NumberReturned == 0 || len(objects) >= r.TotalMatches {
+			return objects, nil
+		}
+	}
+}
+
+func doDLNABrowse(c *cli.Context) error {
+	args := c.Args()
+	if args.Len() < 1 || args.Len() > 2 {
+		cli.ShowSubcommandHelpAndExit(c, 1)
+	}
+	s, err := resolveDLNAServer(c, args.First())
+	if err != nil {
+		return err
+	}
+	id := "0"
+	if args.Len() == 2 {
+		id = args.Get(1)
+	}
+	objects, err := browseAll(c.Context, s, id)
+	if err != nil {
+		return err
+	}
+	printDLNAObjects(objects)
+	return nil
+}
+
+func doDLNASearch(c *cli.Context) error {
+	args := c.Args()
+	if args.Len() != 1 {
+		cli.ShowSubcommandHelpAndExit(c, 1)
+	}
+	criteria := dlna.Criteria([][2]string{
+		{"upnp:artist", c.String("artist")},
+		{"upnp:album", c.String("album")},
+		{"dc:title", c.String("title")},
+	})
+	s, err := resolveDLNAServer(c, args.First())
+	if err != nil {
+		return err
+	}
+	r, err := s.Search(c.Context, "0", criteria, 0, c.Int("limit"))
+	if err != nil {
+		return err
+	}
+	printDLNAObjects(r.Objects)
+	return nil
+}
+
+// collectDLNATracks returns the audio items in a container
+// and every container below it, in browse order.
+func collectDLNATracks(ctx context.Context, s *dlna.Server, id string) ([]dlnaModels.Object, error) {
+	objects, err := browseAll(ctx, s, id)
+	if err != nil {
+		return nil, err
+	}
+	var tracks []dlnaModels.Object
+	for _, o := range objects {
+		if o.IsContainer() {
+			var sub []dlnaModels.Object
+			if sub, err = collectDLNATracks(ctx, s, o.ID); err != nil {
+				return nil, err
+			}
+			tracks = append(tracks, sub...)
+		} else if o.IsAudio() && len(o.Res) > 0 {
+			tracks = append(tracks, o)
+		}
+	}
+	return tracks, nil
+}
+
+func toDLNAQueueItem(o dlnaModels.Object) models.PlayQueueItem {
+	t := &models.Track{
+		Id:          o.ID,
+		Name:        o.Title,
+		ArtistName:  o.ArtistName(),
+		Album:       o.Album,
+		TrackNumber: o.TrackNumber,
+		Duration:    int(o.Duration().Seconds()),
+		Artist:      []models.Artist{},
+		Dlna: &models.Dlna{
+			Id:  o.ID,
+			Url: o.Res[0].URL,
+		},
+		Image: []models.Image{},
+	}
+	if a := o.ArtistName(); a != "" {
+		t.Artist = append(t.Artist, models.Artist{Name: a, NameNormalized: a})
+	}
+	if o.AlbumArtURI != "" {
+		t.Image = append(t.Image, models.Image{URL: o.AlbumArtURI, Size: models.Large, MediaType: "image/jpg"})
+	}
+	return models.PlayQueueItem{Track: t, Behaviour: models.Planned}
+}
+
+func doQueueDLNA(c *cli.Context) error {
+	args := productArgs(c, 3)
+	pos := queuePosition(c)
+	s, err := resolveDLNAServer(c, args.Get(1))
+	if err != nil {
+		return err
+	}
+	o, err := s.GetObject(c.Context, args.Get(2))
+	if err != nil {
+		return err
+	}
+	tracks := []dlnaModels.Object{*o}
+	if o.IsContainer() {
+		if tracks, err = collectDLNATracks(c.Context, s, o.ID); err != nil {
+			return err
+		}
+	} else if len(o.Res) == 0 {
+		return errors.New("item has no media to play")
+	}
+	if len(tracks) == 0 {
+		return errors.New("no tracks found")
+	}
+	br, err := getClient(args.First())
+	if err != nil {
+		return err
+	}
+	return addDLNATracks(c.Context, br.BeoZone, tracks, pos)
+}
+
+// addDLNATracks adds tracks to the play queue at pos, keeping their order.
+func addDLNATracks(ctx context.Context, z beoremote.BeoZone, tracks []dlnaModels.Object, pos beoremote.Position) error {
+	if pos.When == beoremote.Now {
+		// We clear the queue to match what the B&O app does.
+		if err := z.ClearPlayQueue(ctx); err != nil {
+			return err
+		}
+	}
+	// Items are added one at a time, so when playing next or inserting
+	// after another item they are added in reverse to end up in the right
+	// order.
+	for i := range tracks {
+		at := pos
+		t := tracks[i]
+		switch {
+		case pos.When == beoremote.Next || pos.After:
+			t = tracks[len(tracks)-1-i]
+		case pos.When == beoremote.Now && i > 0:
+			at = beoremote.At(beoremote.Last)
+		}
+		if err := z.AddQueueItem(ctx, toDLNAQueueItem(t), at); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getLocalIP returns the first non-loopback IPv4 address of this machine.
+func getLocalIP() (net.IP, error) {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok && !n.IP.IsLoopback() && n.IP.To4() != nil {
+			return n.IP, nil
+		}
+	}
+	return nil, errors.New("no network address found")
+}
+
+func doDLNAStandIn(c *cli.Context) error {
+	args := c.Args()
+	if args.Len() != 1 {
+		cli.ShowSubcommandHelpAndExit(c, 1)
+	}
+	addr := c.String("addr")
+	if addr == "" {
+		ip, err := getLocalIP()
+		if err != nil {
+			return err
+		}
+		addr = ip.String()
+	}
+	port := strconv.Itoa(c.Int("port"))
+	s, err := dlna.NewStandInServer(args.First(), c.String("name"), "http://"+net.JoinHostPort(addr, port))
+	if err != nil {
+		return err
+	}
+	go func() {
+		if err := s.ServeSSDP(c.Context); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "SSDP disabled: %v\n", err)
+		}
+	}()
+	srv := &http.Server{Addr: ":" + port, Handler: s.Handler()}
+	go func() {
+		<-c.Context.Done()
+		_ = srv.Close()
+	}()
+	_, _ = fmt.Fprintf(os.Stderr, "Serving %s at http://%s/description.xml\n", args.First(), net.JoinHostPort(addr, port))
+	if err = srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+// Copyright (c) 2020-2024 Andrew Stormont
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"beoutil/clients/beoremote"
+	"beoutil/clients/beoremote/models"
+	dlnaModels "beoutil/clients/dlna/models"
+)
+
+// fakeQueueZone simulates a play queue on a product, with the first item
+// playing.
+type fakeQueueZone struct {
+	beoremote.BeoZone
+	queue   []string
+	current int
+}
+
+func (z *fakeQueueZone) ClearPlayQueue(ctx context.Context) error {
+	z.queue, z.current = nil, 0
+	return nil
+}
+
+func (z *fakeQueueZone) AddQueueItem(ctx context.Context, qi models.PlayQueueItem, pos beoremote.Position) error {
+	i := len(z.queue)
+	switch {
+	case pos.Anchor != "":
+		for j, id := range z.queue {
+			if id == pos.Anchor {
+				i = j
+				if pos.After {
+					i++
+				}
+			}
+		}
+	case pos.When == beoremote.Now:
+		i = z.current
+	case pos.When == beoremote.Next:
+		i = z.current + 1
+		if i > len(z.queue) {
+			i = len(z.queue)
+		}
+	}
+	z.queue = append(z.queue[:i], append([]string{qi.Track.Id}, z.queue[i:]...)...)
+	return nil
+}
+
+func TestAddDLNATracks(t *testing.T) {
+	var tracks []dlnaModels.Object
+	for _, id := range []string{"t1", "t2", "t3"} {
+		tracks = append(tracks, dlnaModels.Object{ID: id, Res: []dlnaModels.Res{{URL: "http://x/" + id}}})
+	}
+	tests := []struct {
+		name string
+		pos  beoremote.Position
+		want []string
+	}{
+		{"now", beoremote.At(beoremote.Now), []string{"t1", "t2", "t3"}},
+		{"next", beoremote.At(beoremote.Next), []string{"a", "t1", "t2", "t3", "b", "c"}},
+		{"last", beoremote.At(beoremote.Last), []string{"a", "b", "c", "t1", "t2", "t3"}},
+		{"after", beoremote.After("plid-b"), []string{"a", "b", "t1", "t2", "t3", "c"}},
+		{"before", beoremote.Before("plid-b"), []string{"a", "t1", "t2", "t3", "b", "c"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			z := &fakeQueueZone{queue: []string{"a", "b", "c"}}
+			if err := addDLNATracks(context.Background(), z, tracks, tt.pos); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(z.queue, tt.want) {
+				t.Errorf("queue = %q, want %q", z.queue, tt.want)
+			}
+		})
+	}
+}
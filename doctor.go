@@ -0,0 +1,342 @@
+// Copyright (c) 2020-2024 Andrew Stormont
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"beoutil/clients/beoremote"
+	"beoutil/clients/beoremote/models"
+
+	"github.com/urfave/cli/v2"
+)
+
+// slowProbe is how long a probe may take before it's reported as slow.
+const slowProbe = time.Second
+
+// doctorProduct is a product found in the cache, by discovery, or both.
+type doctorProduct struct {
+	Jid        models.Jid
+	Name       string
+	Addr       string
+	Cached     *ProductDetails
+	Discovered *ProductDetails
+	Probes     [len(probeNames)]probeResult
+	System     []models.Product
+}
+
+type probeResult struct {
+	Elapsed time.Duration
+	Err     error
+}
+
+func (r probeResult) String() string {
+	switch {
+	case r.Err == nil:
+		return r.Elapsed.Round(time.Millisecond).String()
+	case errors.Is(r.Err, beoremote.ErrUnreachable):
+		return "unreachable"
+	case errors.Is(r.Err, beoremote.ErrTimeout), errors.Is(r.Err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(r.Err, beoremote.ErrStandby):
+		return "standby"
+	case errors.Is(r.Err, beoremote.ErrNotSupported):
+		return "unsupported"
+	}
+	return "error"
+}
+
+// probeNames are the endpoint families probed, in the order of
+// doctorProduct.Probes.
+var probeNames = [...]string{"/BeoDevice", "/BeoZone", "/BeoHome", "/BeoNotify"}
+
+// doctor collects findings, which are problems along with what to do
+// about them.
+type doctor struct {
+	findings []string
+}
+
+func (d *doctor) find(format string, a ...interface{}) {
+	d.findings = append(d.findings, fmt.Sprintf(format, a...))
+}
+
+func doDoctor(c *cli.Context) error {
+	if c.NArg() != 0 {
+		cli.ShowSubcommandHelpAndExit(c, 1)
+	}
+	d := &doctor{}
+	d.checkMulticast()
+	products := d.checkCache(c)
+	d.probe(c, products)
+	d.checkSystemProducts(products)
+	fmt.Println()
+	if len(d.findings) == 0 {
+		fmt.Println("No problems found.")
+		return nil
+	}
+	fmt.Println("Findings:")
+	for _, f := range d.findings {
+		fmt.Printf("- %s\n", f)
+	}
+	if len(d.findings) == 1 {
+		return errors.New("1 problem found")
+	}
+	return fmt.Errorf("%d problems found", len(d.findings))
+}
+
+// checkMulticast checks there's an interface that can join the mDNS
+// multicast group, which discovery needs.
+func (d *doctor) checkMulticast() {
+	fmt.Println("Multicast:")
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		d.find("Failed to list network interfaces: %v.", err)
+		return
+	}
+	group := &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+	ok := 0
+	tw := new(tabwriter.Writer)
+	tw.Init(os.Stdout, 8, 4, 1, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "INTERFACE\tADDRESS\tMDNS")
+	for i := range ifaces {
+		iface := &ifaces[i]
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 || iface.Flags&net.FlagMulticast == 0 {
+			continue
+		}
+		var addr net.IP
+		addrs, _ := iface.Addrs()
+		for _, a := range addrs {
+			if n, isNet := a.(*net.IPNet); isNet && n.IP.To4() != nil {
+				addr = n.IP
+				break
+			}
+		}
+		if addr == nil {
+			continue
+		}
+		result := "ok"
+		conn, err := net.ListenMulticastUDP("udp4", iface, group)
+		if err != nil {
+			result = err.Error()
+			d.find("Can't join the mDNS multicast group on %s: %v. Check the firewall allows UDP port 5353.", iface.Name, err)
+		} else {
+			_ = conn.Close()
+			ok++
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\n", iface.Name, addr, result)
+	}
+	_ = tw.Flush()
+	if ok == 0 {
+		d.find("No network interface can receive multicast, so products can't be discovered. Connect to the network the products are on.")
+	}
+}
+
+// checkCache compares the product cache with a fresh discovery, and
+// returns every product found by either.
+func (d *doctor) checkCache(c *cli.Context) []*doctorProduct {
+	fmt.Println()
+	fmt.Println("Discovery:")
+	cached, err := getCachedProducts()
+	if err != nil {
+		d.find("Failed to read the product cache: %v. Run `beoutil find-products` to create it.", err)
+	}
+	ctx, cancel := context.WithTimeout(c.Context, c.Duration("timeout"))
+	defer cancel()
+	discovered, err := discoverProducts(ctx)
+	if err != nil {
+		d.find("Discovery failed: %v.", err)
+	}
+	byJid := make(map[models.Jid]*doctorProduct)
+	get := func(jid models.Jid, p *ProductDetails) *doctorProduct {
+		dp, ok := byJid[jid]
+		if !ok {
+			dp = &doctorProduct{Jid: jid, Name: p.Name}
+			byJid[jid] = dp
+		}
+		return dp
+	}
+	for jid, p := range cached {
+		get(jid, p).Cached = p
+	}
+	for jid, p := range discovered {
+		get(models.Jid(jid), p).Discovered = p
+	}
+	var products []*doctorProduct
+	for _, dp := range byJid {
+		products = append(products, dp)
+	}
+	sort.Slice(products, func(i, j int) bool {
+		return products[i].Name < products[j].Name
+	})
+	tw := new(tabwriter.Writer)
+	tw.Init(os.Stdout, 8, 4, 1, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "PRODUCT\tJID\tCACHED\tDISCOVERED")
+	for _, dp := range products {
+		cachedIPs, discoveredIPs := "-", "-"
+		if dp.Cached != nil {
+			cachedIPs = joinIPs(dp.Cached.IPs)
+		}
+		if dp.Discovered != nil {
+			discoveredIPs = joinIPs(dp.Discovered.IPs)
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", dp.Name, dp.Jid, cachedIPs, discoveredIPs)
+		switch {
+		case dp.Discovered != nil && len(dp.Discovered.IPs) > 0:
+			dp.Addr = dp.Discovered.IPs[0].String()
+		case dp.Cached != nil && len(dp.Cached.IPs) > 0:
+			dp.Addr = dp.Cached.IPs[0].String()
+		}
+		switch {
+		case dp.Cached == nil:
+			d.find("%s was discovered but isn't cached. Run `beoutil find-products` to add it.", dp.Name)
+		case dp.Discovered == nil && len(discovered) > 0:
+			d.find("%s is cached but wasn't discovered. It may be in deep standby, unplugged, or on another network.", dp.Name)
+		case dp.Discovered != nil && cachedIPs != discoveredIPs:
+			d.find("%s moved from %s to %s. Run `beoutil find-products` to update the cache.", dp.Name, cachedIPs, discoveredIPs)
+		}
+	}
+	_ = tw.Flush()
+	if len(discovered) == 0 && len(cached) > 0 {
+		d.find("Discovery found no products. If the cached products respond below, multicast is blocked between here and them, e.g. by Wi-Fi client isolation or a firewall dropping UDP port 5353.")
+	}
+	return products
+}
+
+// probe times a request to each endpoint family of every product.
+func (d *doctor) probe(c *cli.Context, products []*doctorProduct) {
+	fmt.Println()
+	fmt.Println("Products:")
+	var targets []string
+	byAddr := make(map[string]*doctorProduct)
+	for _, dp := range products {
+		if dp.Addr != "" {
+			targets = append(targets, dp.Addr)
+			byAddr[dp.Addr] = dp
+		}
+	}
+	timeout := c.Duration("product-timeout")
+	var mu sync.Mutex
+	fanOut(c.Context, targets, c.Int("workers"), 0, func(ctx context.Context, addr string) (string, error) {
+		br := beoremote.NewClient(addr)
+		var system []models.Product
+		probes := [len(probeNames)]func(ctx context.Context) error{
+			func(ctx context.Context) error {
+				_, err := br.GetBeoDevice(ctx)
+				return err
+			},
+			func(ctx context.Context) error {
+				var err error
+				system, err = br.BeoZone.GetSystemProducts(ctx)
+				return err
+			},
+			func(ctx context.Context) error {
+				_, err := br.BeoHome.GetTimers(ctx)
+				return err
+			},
+			func(ctx context.Context) error {
+				// Products send their state as soon as a stream is opened.
+				events, err := br.BeoZone.OpenNotificationStream(ctx)
+				if err != nil {
+					return err
+				}
+				event, ok := <-events
+				if !ok {
+					return ctx.Err()
+				}
+				return event.Err
+			},
+		}
+		var results [len(probeNames)]probeResult
+		for i, probe := range probes {
+			pctx, cancel := context.WithTimeout(ctx, timeout)
+			start := time.Now()
+			err := probe(pctx)
+			cancel()
+			results[i] = probeResult{Elapsed: time.Since(start), Err: err}
+		}
+		mu.Lock()
+		byAddr[addr].Probes = results
+		byAddr[addr].System = system
+		mu.Unlock()
+		return "", nil
+	})
+	tw := new(tabwriter.Writer)
+	tw.Init(os.Stdout, 8, 4, 1, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "PRODUCT\tADDRESS\tBEODEVICE\tBEOZONE\tBEOHOME\tBEONOTIFY")
+	for _, dp := range products {
+		if dp.Addr == "" {
+			d.find("%s has no IP address. Run `beoutil find-products` to update the cache.", dp.Name)
+			continue
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", dp.Name, dp.Addr,
+			dp.Probes[0], dp.Probes[1], dp.Probes[2], dp.Probes[3])
+		unreachable := 0
+		for _, r := range dp.Probes {
+			if errors.Is(r.Err, beoremote.ErrUnreachable) {
+				unreachable++
+			}
+		}
+		if unreachable == len(dp.Probes) {
+			d.find("%s at %s can't be reached on port 8080. Check it's powered on and on this network, and that no firewall is in the way.", dp.Name, dp.Addr)
+			continue
+		}
+		for i, r := range dp.Probes {
+			switch {
+			case r.Err != nil:
+				d.find("%s: %s failed: %v.", dp.Name, probeNames[i], r.Err)
+			case r.Elapsed > slowProbe:
+				d.find("%s: %s took %s. The network or product may be overloaded.", dp.Name, probeNames[i], r.Elapsed.Round(time.Millisecond))
+			}
+		}
+	}
+	_ = tw.Flush()
+}
+
+// checkSystemProducts finds products that other products know about but
+// that don't advertise the beoremote API, so beoutil can't control them.
+func (d *doctor) checkSystemProducts(products []*doctorProduct) {
+	known := make(map[models.Jid]bool)
+	for _, dp := range products {
+		known[dp.Jid] = true
+	}
+	for _, dp := range products {
+		for _, p := range dp.System {
+			if known[p.Jid] {
+				continue
+			}
+			known[p.Jid] = true
+			state := "online"
+			if !p.Online {
+				state = "offline"
+			}
+			d.find("%s (%s, %s) is known to %s but doesn't advertise the beoremote API, so it can't be controlled directly. It can still be used as a source or listener from other products.",
+				p.FriendlyName, p.Jid, state, dp.Name)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2020-2024 Andrew Stormont
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"errors"
+
+	"beoutil/clients/beoremote"
+)
+
+// errorKind describes how a class of errors is reported to the user.
+type errorKind struct {
+	Err      error
+	ExitCode int
+	Hint     string
+}
+
+// errorKinds are checked in order, and the first match decides the exit
+// code. Anything that doesn't match exits with 1.
+var errorKinds = []errorKind{
+	{
+		Err:      beoremote.ErrUnreachable,
+		ExitCode: 3,
+		Hint:     "check the product is powered and on the network, or run find-products to refresh its IP",
+	},
+	{
+		Err:      beoremote.ErrStandby,
+		ExitCode: 4,
+		Hint:     "the product is in standby; run poweron first",
+	},
+	{
+		Err:      beoremote.ErrNotSupported,
+		ExitCode: 5,
+		Hint:     "the product or its current source doesn't support this",
+	},
+	{
+		Err:      beoremote.ErrInvalidQueueItem,
+		ExitCode: 6,
+		Hint:     "the product rejected the queue item; check the track or station ID",
+	},
+	{
+		Err:      beoremote.ErrNotLinkable,
+		ExitCode: 7,
+		Hint:     "this source can't be shared with other products; run get-sources to see linkable sources",
+	},
+	{
+		Err:      beoremote.ErrTimeout,
+		ExitCode: 8,
+		Hint:     "the product didn't respond in time; it may be busy or unreachable",
+	},
+	{
+		Err:      beoremote.ErrSourceNotFound,
+		ExitCode: 9,
+		Hint:     "run get-sources to see the sources available to the product",
+	},
+}
+
+func getErrorKind(err error) *errorKind {
+	for i := range errorKinds {
+		if errors.Is(err, errorKinds[i].Err) {
+			return &errorKinds[i]
+		}
+	}
+	return nil
+}
+
+func exitCode(err error) int {
+	if k := getErrorKind(err); k != nil {
+		return k.ExitCode
+	}
+	return 1
+}
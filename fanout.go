@@ -0,0 +1,247 @@
+// Copyright (c) 2020-2024 Andrew Stormont
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"beoutil/clients/beoremote"
+
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	defaultWorkers        = 8
+	defaultProductTimeout = 5 * time.Second
+)
+
+// fanOutResult is the outcome of running a function against one target.
+type fanOutResult struct {
+	Target  string
+	Value   string
+	Err     error
+	Elapsed time.Duration
+}
+
+type fanOutFunc func(ctx context.Context, target string) (string, error)
+
+// fanOut runs fn against every target using at most workers goroutines.
+// Each call gets its own deadline so that one unreachable product can't
+// hold up the others. Results are returned in the same order as targets.
+func fanOut(ctx context.Context, targets []string, workers int, timeout time.Duration, fn fanOutFunc) []fanOutResult {
+	if workers < 1 {
+		workers = 1
+	}
+	results := make([]fanOutResult, len(targets))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers && w < len(targets); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				tctx, cancel := ctx, context.CancelFunc(func() {})
+				if timeout > 0 {
+					tctx, cancel = context.WithTimeout(ctx, timeout)
+				}
+				start := time.Now()
+				v, err := fn(tctx, targets[i])
+				cancel()
+				results[i] = fanOutResult{
+					Target:  targets[i],
+					Value:   v,
+					Err:     err,
+					Elapsed: time.Since(start),
+				}
+			}
+		}()
+	}
+	for i := range targets {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return results
+}
+
+// failedResults returns an error summarising any failures, or nil. If
+// every failure was for the same reason, the error wraps that reason.
+func failedResults(results []fanOutResult) error {
+	var (
+		failed int
+		kind   *errorKind
+		mixed  bool
+	)
+	for _, r := range results {
+		if r.Err == nil {
+			continue
+		}
+		k := getErrorKind(r.Err)
+		if failed == 0 {
+			kind = k
+		} else if k != kind {
+			mixed = true
+		}
+		failed++
+	}
+	if failed == 0 {
+		return nil
+	}
+	if kind != nil && !mixed {
+		return fmt.Errorf("%d of %d products failed: %w", failed, len(results), kind.Err)
+	}
+	return fmt.Errorf("%d of %d products failed", failed, len(results))
+}
+
+func printFanOutResults(results []fanOutResult) {
+	tw := new(tabwriter.Writer)
+	tw.Init(os.Stdout, 8, 4, 1, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "PRODUCT\tRESULT\tTIME")
+	for _, r := range results {
+		result := r.Value
+		if r.Err != nil {
+			result = "error: " + r.Err.Error()
+		} else if result == "" {
+			result = "ok"
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\n", r.Target, result, r.Elapsed.Round(time.Millisecond))
+	}
+	_ = tw.Flush()
+}
+
+func fanOutFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.IntFlag{
+			Name:  "workers",
+			Usage: "Maximum number of products to talk to at once",
+			Value: defaultWorkers,
+		},
+		&cli.DurationFlag{
+			Name:  "product-timeout",
+			Usage: "Deadline for each product",
+			Value: defaultProductTimeout,
+		},
+	}
+}
+
+// targetFlags are the flags accepted by commands using targetAction.
+func targetFlags(flags ...cli.Flag) []cli.Flag {
+	return append(append(flags, &cli.BoolFlag{
+		Name:  "all",
+		Usage: "Run against every cached product",
+	}), fanOutFlags()...)
+}
+
+// targetFunc performs a command against a single product. Any value
+// returned is printed, or shown in the summary table.
+type targetFunc func(ctx context.Context, c *cli.Context, br *beoremote.Client, args []string) (string, error)
+
+// targetAction turns a targetFunc into a command action. The first
+// argument is a product, or a comma separated list of products, and may
+// be omitted to use the default product. --all runs the command against
+// every cached product instead. When there's more than one product they
+// are contacted in parallel and a summary table is printed. nargs is the
+// number of arguments expected after the product.
+func targetAction(nargs int, fn targetFunc) cli.ActionFunc {
+	return targetActionTo(os.Stdout, nargs, fn)
+}
+
+// targetActionTo is like targetAction, but a single product's result is
+// printed to w.
+func targetActionTo(w io.Writer, nargs int, fn targetFunc) cli.ActionFunc {
+	return func(c *cli.Context) error {
+		targets, args, err := resolveTargets(c, nargs)
+		if err != nil {
+			return err
+		}
+		return runTargets(c, w, targets, args, fn)
+	}
+}
+
+// resolveTargets returns the addresses of the products a command should
+// run against, and the arguments that follow the products.
+func resolveTargets(c *cli.Context, nargs int) ([]string, []string, error) {
+	args := c.Args().Slice()
+	var targets []string
+	if c.Bool("all") {
+		if len(args) != nargs {
+			cli.ShowSubcommandHelpAndExit(c, 1)
+		}
+		products, err := getCachedProducts()
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, p := range products {
+			if len(p.IPs) > 0 {
+				targets = append(targets, p.IPs[0].String())
+			}
+		}
+		return targets, args, nil
+	}
+	if len(args) == nargs && config.DefaultProduct != "" {
+		args = append([]string{config.DefaultProduct}, args...)
+	}
+	if len(args) != nargs+1 {
+		cli.ShowSubcommandHelpAndExit(c, 1)
+	}
+	for _, t := range strings.Split(args[0], ",") {
+		addr, err := resolveProduct(t)
+		if err != nil {
+			return nil, nil, err
+		}
+		targets = append(targets, addr)
+	}
+	return targets, args[1:], nil
+}
+
+// runTargets runs fn against targets. A single target's result is printed
+// as is to w, otherwise they're run in parallel and a summary table is
+// printed.
+func runTargets(c *cli.Context, w io.Writer, targets []string, args []string, fn targetFunc) error {
+	if len(targets) == 1 && !c.Bool("all") {
+		br, err := getClient(targets[0])
+		if err != nil {
+			return err
+		}
+		v, err := fn(c.Context, c, br, args)
+		if err == nil && v != "" {
+			_, _ = fmt.Fprintln(w, v)
+		}
+		return err
+	}
+	results := fanOut(c.Context, targets, c.Int("workers"), c.Duration("product-timeout"),
+		func(ctx context.Context, target string) (string, error) {
+			br, err := getClient(target)
+			if err != nil {
+				return "", err
+			}
+			return fn(ctx, c, br, args)
+		})
+	printFanOutResults(results)
+	return failedResults(results)
+}
@@ -0,0 +1,545 @@
+// Copyright (c) 2020-2024 Andrew Stormont
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"beoutil/clients/beoremote/models"
+
+	"github.com/urfave/cli/v2"
+)
+
+// historyPlay is a track, or a song on a radio station, that was played.
+type historyPlay struct {
+	Product   string    `json:"product"`
+	Addr      string    `json:"addr"`
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	Listened  int       `json:"listened"`           // Listened is how many seconds were actually played.
+	Duration  int       `json:"duration,omitempty"` // Duration is the length of the track in seconds, if known.
+	Artist    string    `json:"artist,omitempty"`
+	Track     string    `json:"track"`
+	Album     string    `json:"album,omitempty"`
+	TrackID   string    `json:"trackId,omitempty"`
+	Station   string    `json:"station,omitempty"`
+	StationID string    `json:"stationId,omitempty"`
+
+	queueItemID models.PlayQueueItemID
+	sourceType  string // sourceType is the type of source playing, e.g. "DEEZER", if known.
+}
+
+// splitLiveDescription splits a radio station's live description, which
+// is usually "Artist - Title", into its parts.
+func splitLiveDescription(s string) (artist, track string) {
+	if i := strings.Index(s, " - "); i > 0 {
+		return strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+3:])
+	}
+	return "", strings.TrimSpace(s)
+}
+
+// playTracker follows what a product is playing from its notifications,
+// keeping track of how long each track is actually played for.
+type playTracker struct {
+	product    *watchedProduct
+	current    *historyPlay
+	state      models.State
+	sourceType string
+	updated    time.Time
+
+	// OnStart is called when a track starts, and OnEnd when it ends.
+	OnStart func(p *historyPlay)
+	OnEnd   func(p *historyPlay)
+}
+
+func newPlayTracker(p *watchedProduct) *playTracker {
+	return &playTracker{product: p}
+}
+
+// tick adds the time since the last notification to the current track
+// if it was playing.
+func (t *playTracker) tick(now time.Time) {
+	if t.current != nil && t.state == models.StatePlay && !t.updated.IsZero() {
+		t.current.Listened += int(now.Sub(t.updated).Round(time.Second) / time.Second)
+	}
+	t.updated = now
+}
+
+func (t *playTracker) end(now time.Time) {
+	if t.current == nil {
+		return
+	}
+	t.tick(now)
+	t.current.End = now
+	if t.OnEnd != nil {
+		t.OnEnd(t.current)
+	}
+	t.current = nil
+}
+
+func (t *playTracker) start(p *historyPlay, now time.Time) {
+	t.end(now)
+	p.Product = t.product.Name
+	p.Addr = t.product.Addr
+	p.Start = now
+	p.sourceType = t.sourceType
+	t.current = p
+	t.updated = now
+	if t.OnStart != nil {
+		t.OnStart(p)
+	}
+}
+
+// Handle updates the tracker from a notification received at now.
+func (t *playTracker) Handle(n *models.Notification, now time.Time) {
+	v, err := n.Decode()
+	if err != nil {
+		return
+	}
+	switch d := v.(type) {
+	case *models.NowPlayingStoredMusicData:
+		if t.current != nil && t.current.Station == "" &&
+			t.current.queueItemID == d.PlayQueueItemID && t.current.TrackID == d.TrackID {
+			return
+		}
+		t.start(&historyPlay{
+			Artist:      d.Artist,
+			Track:       d.Name,
+			Album:       d.Album,
+			TrackID:     d.TrackID,
+			queueItemID: d.PlayQueueItemID,
+		}, now)
+	case *models.NowPlayingNetRadioData:
+		artist, track := splitLiveDescription(d.LiveDescription)
+		if t.current != nil && t.current.StationID == d.StationID &&
+			t.current.Artist == artist && t.current.Track == track {
+			return
+		}
+		t.start(&historyPlay{
+			Artist:    artist,
+			Track:     track,
+			Station:   d.Name,
+			StationID: d.StationID,
+		}, now)
+	case *models.ProgressInformationData:
+		t.tick(now)
+		t.state = d.State
+		if t.current != nil && d.TotalDuration > 0 {
+			t.current.Duration = d.TotalDuration
+		}
+	case *models.SourceData:
+		t.sourceType = d.PrimaryExperience.Source.SourceType.Type
+	case *models.SourceExperienceChangedData:
+		t.sourceType = d.PrimaryExperience.Source.SourceType.Type
+	default:
+		if n.Type == models.NotificationTypeNowPlayingEnded {
+			t.end(now)
+		}
+	}
+}
+
+func getHistoryPath() (string, error) {
+	dir, err := getConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "history.ndjson"), nil
+}
+
+// historyStore appends plays to a file, one JSON object per line.
+type historyStore struct {
+	mu   sync.Mutex
+	path string
+}
+
+func (s *historyStore) Append(p *historyPlay) error {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(append(b, '\n')); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// historyFilter selects plays for reports.
+type historyFilter struct {
+	products map[string]bool
+	since    time.Time
+}
+
+func (f historyFilter) match(p *historyPlay) bool {
+	if f.products != nil && !f.products[p.Addr] && !f.products[p.Product] {
+		return false
+	}
+	return p.Start.After(f.since)
+}
+
+// parseSince parses either a date, such as 2024-01-31, or a duration
+// before now, such as 168h.
+func parseSince(s string) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", s, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid --since %q, expected a date or duration", s)
+	}
+	return t, nil
+}
+
+func getHistoryFilter(c *cli.Context) (historyFilter, error) {
+	var f historyFilter
+	if products := c.StringSlice("product"); len(products) > 0 {
+		f.products = make(map[string]bool)
+		for _, p := range products {
+			f.products[p] = true
+			if addr, err := resolveProduct(p); err == nil {
+				f.products[addr] = true
+			}
+		}
+	}
+	if s := c.String("since"); s != "" {
+		var err error
+		if f.since, err = parseSince(s); err != nil {
+			return f, err
+		}
+	}
+	return f, nil
+}
+
+// loadHistory reads every play matching f, oldest first.
+func loadHistory(f historyFilter) ([]*historyPlay, error) {
+	path, err := getHistoryPath()
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, errors.New("no history recorded yet, run: beoutil history record")
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = file.Close() }()
+	var plays []*historyPlay
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		var p historyPlay
+		if err = json.Unmarshal(scanner.Bytes(), &p); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		if f.match(&p) {
+			plays = append(plays, &p)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(plays, func(i, j int) bool { return plays[i].Start.Before(plays[j].Start) })
+	return plays, nil
+}
+
+func formatListened(seconds int) string {
+	return (time.Duration(seconds) * time.Second).String()
+}
+
+func doRecordHistory(c *cli.Context) error {
+	path, err := getHistoryPath()
+	if err != nil {
+		return err
+	}
+	products, err := getWatchedProducts(c.StringSlice("product"))
+	if err != nil {
+		return err
+	}
+	store := &historyStore{path: path}
+	var mu sync.Mutex
+	trackers := make(map[string]*playTracker)
+	for _, p := range products {
+		t := newPlayTracker(p)
+		t.OnEnd = func(p *historyPlay) {
+			if p.Listened == 0 {
+				return
+			}
+			if err := store.Append(p); err != nil {
+				log.Printf("Failed to record play: %v", err)
+				return
+			}
+			log.Printf("%s: %s - %s (%s)", p.Product, p.Artist, p.Track, formatListened(p.Listened))
+		}
+		trackers[p.Addr] = t
+	}
+	log.Printf("Recording history from %d products to %s", len(products), path)
+	watchProducts(c.Context, products, func(p *watchedProduct, n *models.Notification) {
+		mu.Lock()
+		defer mu.Unlock()
+		trackers[p.Addr].Handle(n, time.Now())
+	})
+	// Record whatever was playing when we were stopped.
+	now := time.Now()
+	for _, t := range trackers {
+		t.end(now)
+	}
+	return nil
+}
+
+// historyCount is the number of plays, and time spent listening, for
+// an artist, track or day.
+type historyCount struct {
+	Product  string
+	Key      []string
+	Plays    int
+	Listened int
+}
+
+// countHistory groups plays by product and key, most played first.
+func countHistory(plays []*historyPlay, key func(p *historyPlay) []string) []*historyCount {
+	counts := make(map[string]*historyCount)
+	var result []*historyCount
+	for _, p := range plays {
+		k := key(p)
+		if k == nil {
+			continue
+		}
+		id := p.Product + "\x00" + strings.Join(k, "\x00")
+		hc, ok := counts[id]
+		if !ok {
+			hc = &historyCount{Product: p.Product, Key: k}
+			counts[id] = hc
+			result = append(result, hc)
+		}
+		hc.Plays++
+		hc.Listened += p.Listened
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Product != result[j].Product {
+			return result[i].Product < result[j].Product
+		}
+		if result[i].Plays != result[j].Plays {
+			return result[i].Plays > result[j].Plays
+		}
+		return result[i].Listened > result[j].Listened
+	})
+	return result
+}
+
+func printHistoryCounts(counts []*historyCount, limit int, headers ...string) {
+	tw := new(tabwriter.Writer)
+	tw.Init(os.Stdout, 8, 4, 1, ' ', 0)
+	_, _ = fmt.Fprintf(tw, "PRODUCT\t%s\tPLAYS\tTIME\n", strings.Join(headers, "\t"))
+	shown := make(map[string]int)
+	for _, hc := range counts {
+		if limit > 0 && shown[hc.Product] >= limit {
+			continue
+		}
+		shown[hc.Product]++
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%d\t%s\n", hc.Product, strings.Join(hc.Key, "\t"), hc.Plays, formatListened(hc.Listened))
+	}
+	_ = tw.Flush()
+}
+
+func doTopArtists(c *cli.Context) error {
+	f, err := getHistoryFilter(c)
+	if err != nil {
+		return err
+	}
+	plays, err := loadHistory(f)
+	if err != nil {
+		return err
+	}
+	counts := countHistory(plays, func(p *historyPlay) []string {
+		if p.Artist == "" {
+			return nil
+		}
+		return []string{p.Artist}
+	})
+	printHistoryCounts(counts, c.Int("limit"), "ARTIST")
+	return nil
+}
+
+func doTopTracks(c *cli.Context) error {
+	f, err := getHistoryFilter(c)
+	if err != nil {
+		return err
+	}
+	plays, err := loadHistory(f)
+	if err != nil {
+		return err
+	}
+	counts := countHistory(plays, func(p *historyPlay) []string {
+		return []string{p.Track, p.Artist}
+	})
+	printHistoryCounts(counts, c.Int("limit"), "TRACK", "ARTIST")
+	return nil
+}
+
+func doDailyHistory(c *cli.Context) error {
+	f, err := getHistoryFilter(c)
+	if err != nil {
+		return err
+	}
+	plays, err := loadHistory(f)
+	if err != nil {
+		return err
+	}
+	counts := countHistory(plays, func(p *historyPlay) []string {
+		return []string{p.Start.Local().Format("2006-01-02")}
+	})
+	sort.SliceStable(counts, func(i, j int) bool {
+		if counts[i].Key[0] != counts[j].Key[0] {
+			return counts[i].Key[0] < counts[j].Key[0]
+		}
+		return counts[i].Product < counts[j].Product
+	})
+	tw := new(tabwriter.Writer)
+	tw.Init(os.Stdout, 8, 4, 1, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "DATE\tPRODUCT\tPLAYS\tTIME")
+	for _, hc := range counts {
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%d\t%s\n", hc.Key[0], hc.Product, hc.Plays, formatListened(hc.Listened))
+	}
+	return tw.Flush()
+}
+
+func doLastPlays(c *cli.Context) error {
+	n := 20
+	if c.Args().Present() {
+		var err error
+		if n, err = strconv.Atoi(c.Args().First()); err != nil || n < 1 {
+			return fmt.Errorf("invalid number of plays: %q", c.Args().First())
+		}
+	}
+	f, err := getHistoryFilter(c)
+	if err != nil {
+		return err
+	}
+	plays, err := loadHistory(f)
+	if err != nil {
+		return err
+	}
+	if len(plays) > n {
+		plays = plays[len(plays)-n:]
+	}
+	tw := new(tabwriter.Writer)
+	tw.Init(os.Stdout, 8, 4, 1, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "STARTED\tPRODUCT\tARTIST\tTRACK\tALBUM/STATION\tLISTENED")
+	for i := len(plays) - 1; i >= 0; i-- {
+		p := plays[i]
+		from := p.Album
+		if p.Station != "" {
+			from = p.Station
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", p.Start.Local().Format("2006-01-02 15:04"),
+			p.Product, p.Artist, p.Track, from, formatListened(p.Listened))
+	}
+	return tw.Flush()
+}
+
+func exportHistoryCSV(w io.Writer, plays []*historyPlay) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"product", "addr", "start", "end", "listened", "duration",
+		"artist", "track", "album", "trackId", "station", "stationId"})
+	for _, p := range plays {
+		_ = cw.Write([]string{p.Product, p.Addr, p.Start.Format(time.RFC3339), p.End.Format(time.RFC3339),
+			strconv.Itoa(p.Listened), strconv.Itoa(p.Duration), p.Artist, p.Track, p.Album, p.TrackID,
+			p.Station, p.StationID})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func exportHistoryJSON(w io.Writer, plays []*historyPlay) error {
+	if plays == nil {
+		plays = []*historyPlay{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(plays)
+}
+
+func doExportHistory(c *cli.Context) error {
+	var export func(w io.Writer, plays []*historyPlay) error
+	switch c.String("format") {
+	case "csv":
+		export = exportHistoryCSV
+	case "json":
+		export = exportHistoryJSON
+	default:
+		return fmt.Errorf("invalid format: %q", c.String("format"))
+	}
+	f, err := getHistoryFilter(c)
+	if err != nil {
+		return err
+	}
+	plays, err := loadHistory(f)
+	if err != nil {
+		return err
+	}
+	path := c.String("output")
+	if path == "" || path == "-" {
+		return export(os.Stdout, plays)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err = export(file, plays); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// historyFlags are the flags used to select plays for reports.
+func historyFlags(flags ...cli.Flag) []cli.Flag {
+	return append(flags,
+		&cli.StringSliceFlag{
+			Name:  "product",
+			Usage: "Only include these products",
+		},
+		&cli.StringFlag{
+			Name:  "since",
+			Usage: "Only include plays since a date (2024-01-31) or duration (168h)",
+		},
+	)
+}
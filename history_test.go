@@ -0,0 +1,172 @@
+// Copyright (c) 2020-2024 Andrew Stormont
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"beoutil/clients/beoremote/models"
+)
+
+func trackNotification(id, name string) *models.Notification {
+	return testNotification(models.NotificationTypeNowPlayingStoredMusic,
+		`{"name": "`+name+`", "artist": "Tool", "album": "Lateralus", "trackId": "`+id+`", "playQueueItemId": "plid-`+id+`"}`)
+}
+
+func radioNotification(live string) *models.Notification {
+	return testNotification(models.NotificationTypeNowPlayingNetRadio,
+		`{"name": "Radio Paradise", "liveDescription": "`+live+`", "stationId": "s17077"}`)
+}
+
+func durationNotification(state models.State, total int) *models.Notification {
+	return testNotification(models.NotificationTypeProgressInformation,
+		`{"state": "`+string(state)+`", "totalDuration": `+strconv.Itoa(total)+`}`)
+}
+
+func TestPlayTrackerHandle(t *testing.T) {
+	ended := testNotification(models.NotificationTypeNowPlayingEnded, "")
+	type step struct {
+		at int // at is when the notification is received, in seconds.
+		n  *models.Notification
+	}
+	type play struct {
+		artist, track      string
+		start, end         int
+		listened, duration int
+	}
+	tests := []struct {
+		name  string
+		steps []step
+		want  []play
+	}{
+		{
+			"track change",
+			[]step{
+				{0, trackNotification("1", "Schism")},
+				{0, durationNotification(models.StatePlay, 407)},
+				{100, durationNotification(models.StatePlay, 407)},
+				{407, trackNotification("2", "Parabol")},
+				{407, durationNotification(models.StatePlay, 184)},
+				{500, ended},
+			},
+			[]play{
+				{"Tool", "Schism", 0, 407, 407, 407},
+				{"Tool", "Parabol", 407, 500, 93, 184},
+			},
+		},
+		{
+			"repeated now playing",
+			[]step{
+				{0, trackNotification("1", "Schism")},
+				{0, durationNotification(models.StatePlay, 407)},
+				{30, trackNotification("1", "Schism")},
+				{60, ended},
+			},
+			[]play{{"Tool", "Schism", 0, 60, 60, 407}},
+		},
+		{
+			"pause and resume",
+			[]step{
+				{0, trackNotification("1", "Schism")},
+				{0, durationNotification(models.StatePlay, 407)},
+				{40, durationNotification(models.StatePause, 407)},
+				{100, durationNotification(models.StatePlay, 407)},
+				{130, ended},
+			},
+			[]play{{"Tool", "Schism", 0, 130, 70, 407}},
+		},
+		{
+			"stop mid-track",
+			[]step{
+				{0, trackNotification("1", "Schism")},
+				{0, durationNotification(models.StatePlay, 407)},
+				{60, durationNotification(models.StateStop, 407)},
+				{200, ended},
+			},
+			[]play{{"Tool", "Schism", 0, 200, 60, 407}},
+		},
+		{
+			"stop then next track",
+			[]step{
+				{0, trackNotification("1", "Schism")},
+				{0, durationNotification(models.StatePlay, 407)},
+				{60, durationNotification(models.StateStop, 407)},
+				{300, trackNotification("2", "Parabol")},
+				{300, durationNotification(models.StatePlay, 184)},
+				{310, ended},
+			},
+			[]play{
+				{"Tool", "Schism", 0, 300, 60, 407},
+				{"Tool", "Parabol", 300, 310, 10, 184},
+			},
+		},
+		{
+			"radio",
+			[]step{
+				{0, durationNotification(models.StatePlay, 0)},
+				{0, radioNotification("Tool - Schism")},
+				{200, radioNotification("Tool - Schism")},
+				{240, radioNotification("Station ident")},
+				{250, ended},
+			},
+			[]play{
+				{"Tool", "Schism", 0, 240, 240, 0},
+				{"", "Station ident", 240, 250, 10, 0},
+			},
+		},
+		{
+			"ended without a track",
+			[]step{
+				{0, durationNotification(models.StatePlay, 0)},
+				{10, ended},
+			},
+			nil,
+		},
+	}
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	at := func(s int) time.Time { return start.Add(time.Duration(s) * time.Second) }
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []*historyPlay
+			tr := newPlayTracker(&watchedProduct{Addr: "192.0.2.1", Name: "Kitchen"})
+			tr.OnEnd = func(p *historyPlay) { got = append(got, p) }
+			for _, s := range tt.steps {
+				tr.Handle(s.n, at(s.at))
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d plays, want %d", len(got), len(tt.want))
+			}
+			for i, w := range tt.want {
+				p := got[i]
+				if p.Artist != w.artist || p.Track != w.track || p.Product != "Kitchen" {
+					t.Errorf("play %d is %s - %s on %s, want %s - %s on Kitchen", i, p.Artist, p.Track, p.Product, w.artist, w.track)
+				}
+				if !p.Start.Equal(at(w.start)) || !p.End.Equal(at(w.end)) {
+					t.Errorf("play %d ran %s to %s, want %s to %s", i, p.Start, p.End, at(w.start), at(w.end))
+				}
+				if p.Listened != w.listened || p.Duration != w.duration {
+					t.Errorf("play %d listened %ds of %ds, want %ds of %ds", i, p.Listened, p.Duration, w.listened, w.duration)
+				}
+			}
+		})
+	}
+}
@@ -30,9 +30,10 @@ import (
 	"net"
 	"os"
 	"os/signal"
-	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"text/tabwriter"
 	"time"
 
@@ -40,24 +41,16 @@ import (
 	"beoutil/clients/beoremote/models"
 	"beoutil/clients/deezer"
 	deezerModels "beoutil/clients/deezer/models"
+	"beoutil/clients/listenbrainz"
 
 	"github.com/urfave/cli/v2"
 )
 
-func getHomeDir() (string, error) {
-	home, err := os.UserHomeDir()
-	if err !=nil {
-		return "", fmt.Errorf("Failed to get user home dir: %w", err)
-	}
-	fmt.Printf("Home dir found: %s\n", home)
-	return home, nil
-}
-
 func doFindProducts(c *cli.Context) error {
 	if c.NArg() != 0 {
 		cli.ShowSubcommandHelpAndExit(c, 1)
 	}
-	home, err := getHomeDir()
+	path, err := getCachePath()
 	if err != nil {
 		return err
 	}
@@ -73,7 +66,7 @@ func doFindProducts(c *cli.Context) error {
 	if err != nil {
 		return err
 	}
-	err = os.WriteFile(filepath.Join(home, ".beoutil"), b, 0644)
+	err = os.WriteFile(path, b, 0644)
 	if err != nil {
 		return err
 	}
@@ -82,11 +75,11 @@ func doFindProducts(c *cli.Context) error {
 }
 
 func getCachedProducts() (map[models.Jid]*ProductDetails, error) {
-	home, err := getHomeDir()
+	path, err := getCachePath()
 	if err != nil {
 		return nil, err
 	}
-	b, err := os.ReadFile(filepath.Join(home, ".beoutil"))
+	b, err := os.ReadFile(path)
 	if os.IsNotExist(err) {
 		return nil, errors.New("no products cached")
 	}
@@ -119,24 +112,34 @@ func joinIPs(ips []net.IP) string {
 	return b.String()
 }
 
-func getAllSystemProducts(ctx context.Context) (map[models.Jid]systemProduct, error) {
+func getAllSystemProducts(ctx context.Context, workers int, timeout time.Duration) (map[models.Jid]systemProduct, error) {
 	cached, err := getCachedProducts()
 	if err != nil {
 		return nil, err
 	}
-	result := make(map[models.Jid]systemProduct)
+	var ips []string
 	for _, c := range cached {
 		for _, ip := range c.IPs {
-			br := beoremote.NewClient(ip.String())
-			var products []models.Product
-			if products, err = br.BeoZone.GetSystemProducts(ctx); err != nil {
-				continue
-			}
-			// Merge all the different product lists together.
-			for _, p := range products {
-				if _, ok := result[p.Jid]; !ok {
-					result[p.Jid] = systemProduct{Product: p}
-				}
+			ips = append(ips, ip.String())
+		}
+	}
+	var mu sync.Mutex
+	lists := make(map[string][]models.Product)
+	fanOut(ctx, ips, workers, timeout, func(ctx context.Context, ip string) (string, error) {
+		br := beoremote.NewClient(ip)
+		products, err := br.BeoZone.GetSystemProducts(ctx)
+		mu.Lock()
+		lists[ip] = products
+		mu.Unlock()
+		return "", err
+	})
+	result := make(map[models.Jid]systemProduct)
+	// Merge all the different product lists together.
+	for _, ip := range ips {
+		products := lists[ip]
+		for _, p := range products {
+			if _, ok := result[p.Jid]; !ok {
+				result[p.Jid] = systemProduct{Product: p}
 			}
 		}
 	}
@@ -153,7 +156,7 @@ func doListProducts(c *cli.Context) error {
 	if c.NArg() != 0 {
 		cli.ShowSubcommandHelpAndExit(c, 1)
 	}
-	products, err := getAllSystemProducts(c.Context)
+	products, err := getAllSystemProducts(c.Context, c.Int("workers"), c.Duration("product-timeout"))
 	if err != nil {
 		return err
 	}
@@ -205,162 +208,221 @@ func doAllStandby(c *cli.Context) error {
 	if err != nil {
 		return err
 	}
-	for _, p := range products {
-		for _, ip := range p.IPs {
-			br := beoremote.NewClient(ip.String())
-			var s models.PowerState
-			s, err = br.BeoDevice.GetState(c.Context)
-			if err != nil {
-				continue
-			}
-			if s != models.PowerStateOn {
-				break
-			}
-			err = br.BeoDevice.AllStandby(c.Context)
-			if err == nil {
-				break
+	var jids []string
+	for jid := range products {
+		jids = append(jids, string(jid))
+	}
+	results := fanOut(c.Context, jids, c.Int("workers"), c.Duration("product-timeout"),
+		func(ctx context.Context, jid string) (string, error) {
+			var err error
+			for _, ip := range products[models.Jid(jid)].IPs {
+				br := beoremote.NewClient(ip.String())
+				var s models.PowerState
+				s, err = br.BeoDevice.GetState(ctx)
+				if err != nil {
+					continue
+				}
+				if s != models.PowerStateOn {
+					return string(s), nil
+				}
+				if err = br.BeoDevice.AllStandby(ctx); err == nil {
+					return "allStandby", nil
+				}
 			}
-		}
-	}
-	return err
+			return "", err
+		})
+	return failedResults(results)
 }
 
-func doStandby(c *cli.Context) error {
-	args := c.Args()
-	if args.Len() != 1 {
-		cli.ShowSubcommandHelpAndExit(c, 1)
-	}
-	br := beoremote.NewClient(args.First())
-	return br.BeoDevice.Standby(c.Context)
+func doStandby(ctx context.Context, _ *cli.Context, br *beoremote.Client, _ []string) (string, error) {
+	return "", br.BeoDevice.Standby(ctx)
 }
 
-func doPowerOn(c *cli.Context) error {
-	args := c.Args()
-	if args.Len() != 1 {
-		cli.ShowSubcommandHelpAndExit(c, 1)
-	}
-	br := beoremote.NewClient(args.First())
-	return br.BeoDevice.PowerOn(c.Context)
+func doPowerOn(ctx context.Context, _ *cli.Context, br *beoremote.Client, _ []string) (string, error) {
+	return "", br.BeoDevice.PowerOn(ctx)
 }
 
-func doReboot(c *cli.Context) error {
-	args := c.Args()
-	if args.Len() != 1 {
-		cli.ShowSubcommandHelpAndExit(c, 1)
-	}
-	br := beoremote.NewClient(args.First())
-	return br.BeoDevice.Reboot(c.Context)
+func doReboot(ctx context.Context, _ *cli.Context, br *beoremote.Client, _ []string) (string, error) {
+	return "", br.BeoDevice.Reboot(ctx)
 }
 
-func doGetVolume(c *cli.Context) error {
-	args := c.Args()
-	if args.Len() != 1 {
-		cli.ShowSubcommandHelpAndExit(c, 1)
-	}
-	br := beoremote.NewClient(args.First())
-	v, err := br.BeoZone.GetVolume(c.Context)
+func doGetVolume(ctx context.Context, _ *cli.Context, br *beoremote.Client, _ []string) (string, error) {
+	v, err := br.BeoZone.GetVolume(ctx)
 	if err != nil {
-		return err
+		return "", err
 	}
-	_, _ = fmt.Fprintf(os.Stderr, "%d\n", v)
-	return nil
+	return strconv.Itoa(v), nil
 }
 
-func doSetVolume(c *cli.Context) error {
-	args := c.Args()
-	if args.Len() != 2 {
-		cli.ShowSubcommandHelpAndExit(c, 1)
-	}
-	v, err := strconv.Atoi(args.Get(1))
+func doSetVolume(ctx context.Context, _ *cli.Context, br *beoremote.Client, args []string) (string, error) {
+	v, err := strconv.Atoi(args[0])
 	if err != nil {
-		return err
+		return "", err
+	}
+	if limit, ok := volumeLimit(br.Addr()); ok && v > limit {
+		v = limit
+		_, _ = fmt.Fprintf(os.Stderr, "Volume limited to %d.\n", v)
 	}
-	br := beoremote.NewClient(args.First())
-	return br.BeoZone.SetVolume(c.Context, v)
+	return "", br.BeoZone.SetVolume(ctx, v)
 }
 
-func doPause(c *cli.Context) error {
-	args := c.Args()
-	if args.Len() != 1 {
-		cli.ShowSubcommandHelpAndExit(c, 1)
+func doPause(ctx context.Context, _ *cli.Context, br *beoremote.Client, _ []string) (string, error) {
+	return "", br.BeoZone.Pause(ctx)
+}
+
+func doPlay(ctx context.Context, _ *cli.Context, br *beoremote.Client, _ []string) (string, error) {
+	return "", br.BeoZone.Play(ctx)
+}
+
+func doForward(ctx context.Context, _ *cli.Context, br *beoremote.Client, _ []string) (string, error) {
+	return "", br.BeoZone.Forward(ctx)
+}
+
+func doBackward(ctx context.Context, _ *cli.Context, br *beoremote.Client, _ []string) (string, error) {
+	return "", br.BeoZone.Backward(ctx)
+}
+
+func doStop(ctx context.Context, _ *cli.Context, br *beoremote.Client, _ []string) (string, error) {
+	return "", br.BeoZone.Stop(ctx)
+}
+
+// parseSeekPosition parses positions such as "83", "1:23", "1:02:03" or
+// "90s". A leading + or - makes the position relative to the current one.
+func parseSeekPosition(s string) (int, int, error) {
+	whence := io.SeekStart
+	sign := 1
+	if strings.HasPrefix(s, "+") || strings.HasPrefix(s, "-") {
+		whence = io.SeekCurrent
+		if s[0] == '-' {
+			sign = -1
+		}
+		s = s[1:]
+	}
+	if strings.HasPrefix(s, "+") || strings.HasPrefix(s, "-") {
+		return 0, 0, fmt.Errorf("invalid position: %q", s)
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return sign * int(d/time.Second), whence, nil
 	}
-	br := beoremote.NewClient(args.First())
-	return br.BeoZone.Pause(c.Context)
+	seconds := 0
+	for i, part := range strings.Split(s, ":") {
+		n, err := strconv.Atoi(part)
+		// Only the first field may be 60 or more, as in "90:00".
+		if err != nil || n < 0 || (i > 0 && n >= 60) || strings.ContainsAny(part, "+-") {
+			return 0, 0, fmt.Errorf("invalid position: %q", s)
+		}
+		seconds = seconds*60 + n
+	}
+	return sign * seconds, whence, nil
 }
 
-func doPlay(c *cli.Context) error {
-	args := c.Args()
-	if args.Len() != 1 {
-		cli.ShowSubcommandHelpAndExit(c, 1)
+func doSeek(ctx context.Context, c *cli.Context, br *beoremote.Client, args []string) (string, error) {
+	offset, whence, err := parseSeekPosition(args[0])
+	if err != nil {
+		return "", err
 	}
-	br := beoremote.NewClient(args.First())
-	return br.BeoZone.Play(c.Context)
+	ctx, cancel := context.WithTimeout(ctx, c.Duration("timeout"))
+	defer cancel()
+	return "", br.BeoZone.Seek(ctx, offset, whence)
 }
 
-func doForward(c *cli.Context) error {
-	args := c.Args()
-	if args.Len() != 1 {
-		cli.ShowSubcommandHelpAndExit(c, 1)
+func doGetMuted(ctx context.Context, _ *cli.Context, br *beoremote.Client, _ []string) (string, error) {
+	m, err := br.BeoZone.GetMuted(ctx)
+	if err != nil {
+		return "", err
 	}
-	br := beoremote.NewClient(args.First())
-	return br.BeoZone.Forward(c.Context)
+	return strconv.FormatBool(m), nil
 }
 
-func doBackward(c *cli.Context) error {
-	args := c.Args()
-	if args.Len() != 1 {
-		cli.ShowSubcommandHelpAndExit(c, 1)
+func doSetMuted(ctx context.Context, _ *cli.Context, br *beoremote.Client, args []string) (string, error) {
+	m, err := strconv.ParseBool(args[0])
+	if err != nil {
+		return "", err
 	}
-	br := beoremote.NewClient(args.First())
-	return br.BeoZone.Backward(c.Context)
+	return "", br.BeoZone.SetMuted(ctx, m)
 }
 
-func doStop(c *cli.Context) error {
-	args := c.Args()
-	if args.Len() != 1 {
-		cli.ShowSubcommandHelpAndExit(c, 1)
+// printLiveDescription prints what's on air if the
+// item being played is a radio station.
+func printLiveDescription(ctx context.Context, z beoremote.BeoZone, q *models.PlayQueue) {
+	for _, qi := range q.PlayQueueItem {
+		if qi.Id != q.PlayNowId || qi.Station == nil {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+		defer cancel()
+		n, err := z.WaitForNotification(ctx, models.NotificationTypeNowPlayingNetRadio)
+		if err != nil {
+			return
+		}
+		var d models.NowPlayingNetRadioData
+		if json.Unmarshal(n.Data, &d) == nil && d.LiveDescription != "" {
+			fmt.Printf("On air: %s\n", d.LiveDescription)
+		}
+		return
 	}
-	br := beoremote.NewClient(args.First())
-	return br.BeoZone.Stop(c.Context)
 }
 
-func doGetMuted(c *cli.Context) error {
-	args := c.Args()
-	if args.Len() != 1 {
-		cli.ShowSubcommandHelpAndExit(c, 1)
+// queueItemArtists returns every artist of a queue item.
+func queueItemArtists(qi models.PlayQueueItem) string {
+	if qi.Track == nil || len(qi.Track.Artist) < 2 {
+		return queueItemArtist(qi)
 	}
-	br := beoremote.NewClient(args.First())
-	m, err := br.BeoZone.GetMuted(c.Context)
-	if err != nil {
-		return err
+	var names []string
+	for _, a := range qi.Track.Artist {
+		names = append(names, a.Name)
 	}
-	_, _ = fmt.Fprintf(os.Stdout, "%t\n", m)
-	return nil
+	return strings.Join(names, ", ")
 }
 
-func doSetMuted(c *cli.Context) error {
-	args := c.Args()
-	if args.Len() != 2 {
-		cli.ShowSubcommandHelpAndExit(c, 1)
-	}
-	m, err := strconv.ParseBool(args.Get(1))
-	if err != nil {
-		return err
+// largestImage returns the URL of the largest image.
+func largestImage(images []models.Image) string {
+	url := ""
+	for _, size := range []models.Size{models.Small, models.Medium, models.Large} {
+		for _, i := range images {
+			if i.Size == size && i.URL != "" {
+				url = i.URL
+			}
+		}
 	}
-	br := beoremote.NewClient(args.First())
-	return br.BeoZone.SetMuted(c.Context, m)
+	return url
 }
 
 func doGetQueue(c *cli.Context) error {
-	args := c.Args()
-	if args.Len() != 1 {
-		cli.ShowSubcommandHelpAndExit(c, 1)
+	args := productArgs(c, 1)
+	br, err := getClient(args.First())
+	if err != nil {
+		return err
+	}
+	if c.Bool("follow") {
+		m := beoremote.NewQueueMirror(br.BeoZone)
+		err = m.Run(c.Context, func(q *models.PlayQueue) {
+			fmt.Printf("--- %s, revision %d ---\n", time.Now().Format("15:04:05"), m.Revision())
+			printQueue(c, q)
+			printQueueMode(q)
+		})
+		if errors.Is(err, context.Canceled) {
+			return nil
+		}
+		return err
+	}
+	var q *models.PlayQueue
+	if c.Bool("all") {
+		q, err = beoremote.GetFullPlayQueue(c.Context, br.BeoZone)
+	} else {
+		q, err = br.BeoZone.GetPlayQueue(c.Context, -200, 200)
 	}
-	br := beoremote.NewClient(args.First())
-	q, err := br.BeoZone.GetPlayQueue(c.Context, -200, 200)
 	if err != nil {
 		return err
 	}
+	printQueue(c, q)
+	printLiveDescription(c.Context, br.BeoZone, q)
+	printQueueMode(q)
+	return nil
+}
+
+// printQueue prints the items of a play queue.
+func printQueue(c *cli.Context, q *models.PlayQueue) {
 	if len(q.PlayQueueItem) > 0 {
 		tw := new(tabwriter.Writer)
 		tw.Init(os.Stdout, 8, 4, 1, ' ', 0)
@@ -369,81 +431,98 @@ func doGetQueue(c *cli.Context) error {
 		if q.PlayNowId == "" {
 			q.PlayNowId = q.PlayQueueItem[0].Id
 		}
-		_, _ = fmt.Fprintln(tw, "PTR\tPLID\tTRACK\tARTIST")
+		header := "PTR\tPLID\tNO\tTRACK\tARTIST\tALBUM\tTIME"
+		if c.Bool("covers") {
+			header += "\tCOVER"
+		}
+		_, _ = fmt.Fprintln(tw, header)
 		for _, qi := range q.PlayQueueItem {
 			marker := ""
 			if qi.Id == q.PlayNowId {
 				marker = "------>"
 			}
 			id := strings.TrimPrefix(string(qi.Id), "plid-")
-			_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n",
-				marker, id, qi.Track.Name, qi.Track.ArtistName)
+			no, album, length, cover := "", "", "", ""
+			if t := qi.Track; t != nil {
+				if t.TrackNumber > 0 {
+					no = strconv.Itoa(t.TrackNumber)
+				}
+				album = t.Album
+				if t.Duration > 0 {
+					length = fmt.Sprintf("%d:%02d", t.Duration/60, t.Duration%60)
+				}
+				cover = largestImage(t.Image)
+			} else if qi.Station != nil {
+				cover = largestImage(qi.Station.Image)
+			}
+			line := fmt.Sprintf("%s\t%s\t%s\t%s\t%s\t%s\t%s",
+				marker, id, no, queueItemName(qi), queueItemArtists(qi), album, length)
+			if c.Bool("covers") {
+				line += "\t" + cover
+			}
+			_, _ = fmt.Fprintln(tw, line)
 		}
 		_ = tw.Flush()
-		repeat := "unknown"
-		if q.Repeat == models.RepeatAll {
-			repeat = "all"
-		} else if q.Repeat == models.RepeatCurrentItem {
-			repeat = "current"
-		} else if q.Repeat == models.RepeatOff {
-			repeat = "off"
-		}
-		random := "unknown"
-		if q.Random == models.RandomRandom {
-			random = "on"
-		} else if q.Random == models.RandomOff {
-			random = "off"
-		}
-		fmt.Printf("Repeat: %s\tRandom: %s\n", repeat, random)
 	} else {
 		_, _ = fmt.Printf("Queue empty.\n")
 	}
-	return nil
 }
 
-func doClearQueue(c *cli.Context) error {
-	args := c.Args()
-	if args.Len() != 1 {
-		cli.ShowSubcommandHelpAndExit(c, 1)
-	}
-	br := beoremote.NewClient(args.First())
-	return br.BeoZone.ClearPlayQueue(c.Context)
+func printQueueMode(q *models.PlayQueue) {
+	if len(q.PlayQueueItem) == 0 {
+		return
+	}
+	repeat := "unknown"
+	if q.Repeat == models.RepeatAll {
+		repeat = "all"
+	} else if q.Repeat == models.RepeatCurrentItem {
+		repeat = "current"
+	} else if q.Repeat == models.RepeatOff {
+		repeat = "off"
+	}
+	random := "unknown"
+	if q.Random == models.RandomRandom {
+		random = "on"
+	} else if q.Random == models.RandomOff {
+		random = "off"
+	}
+	fmt.Printf("Repeat: %s\tRandom: %s\n", repeat, random)
+}
+
+func doClearQueue(ctx context.Context, _ *cli.Context, br *beoremote.Client, _ []string) (string, error) {
+	return "", br.BeoZone.ClearPlayQueue(ctx)
 }
 
 func doRemoveQueueItem(c *cli.Context) error {
-	args := c.Args()
-	if args.Len() != 2 {
-		cli.ShowSubcommandHelpAndExit(c, 1)
+	args := productArgs(c, 2)
+	br, err := getClient(args.First())
+	if err != nil {
+		return err
 	}
-	br := beoremote.NewClient(args.First())
 	return br.BeoZone.RemoveQueueItem(c.Context, args.Get(1))
 }
 
 func doMoveQueueItem(c *cli.Context) error {
-	args := c.Args()
-	if args.Len() != 3 {
-		cli.ShowSubcommandHelpAndExit(c, 1)
+	args := productArgs(c, 3)
+	br, err := getClient(args.First())
+	if err != nil {
+		return err
 	}
-	br := beoremote.NewClient(args.First())
 	return br.BeoZone.MoveQueueItem(c.Context, args.Get(1), args.Get(2))
 }
 
 func doPlayQueueItem(c *cli.Context) error {
-	args := c.Args()
-	if args.Len() != 2 {
-		cli.ShowSubcommandHelpAndExit(c, 1)
+	args := productArgs(c, 2)
+	br, err := getClient(args.First())
+	if err != nil {
+		return err
 	}
-	br := beoremote.NewClient(args.First())
 	return br.BeoZone.PlayQueueItem(c.Context, args.Get(1))
 }
 
-func doSetRepeat(c *cli.Context) error {
-	args := c.Args()
-	if args.Len() != 2 {
-		cli.ShowSubcommandHelpAndExit(c, 1)
-	}
+func doSetRepeat(ctx context.Context, _ *cli.Context, br *beoremote.Client, args []string) (string, error) {
 	repeat := models.RepeatUnknown
-	switch args.Get(1) {
+	switch args[0] {
 	case "all":
 		repeat = models.RepeatAll
 	case "current":
@@ -451,68 +530,111 @@ func doSetRepeat(c *cli.Context) error {
 	case "off":
 		repeat = models.RepeatOff
 	default:
-		cli.ShowSubcommandHelpAndExit(c, 1)
+		return "", fmt.Errorf("invalid repeat mode: %q", args[0])
 	}
-	br := beoremote.NewClient(args.First())
-	return br.BeoZone.SetQueueRepeat(c.Context, repeat)
+	return "", br.BeoZone.SetQueueRepeat(ctx, repeat)
 }
 
-func doSetRandom(c *cli.Context) error {
-	args := c.Args()
-	if args.Len() != 2 {
-		cli.ShowSubcommandHelpAndExit(c, 1)
-	}
+func doSetRandom(ctx context.Context, _ *cli.Context, br *beoremote.Client, args []string) (string, error) {
 	random := models.RandomUnknown
-	switch args.Get(1) {
+	switch args[0] {
 	case "on":
 		random = models.RandomRandom
 	case "off":
 		random = models.RandomOff
 	default:
-		cli.ShowSubcommandHelpAndExit(c, 1)
+		return "", fmt.Errorf("invalid random mode: %q", args[0])
 	}
-	br := beoremote.NewClient(args.First())
-	return br.BeoZone.SetQueueRandom(c.Context, random)
+	return "", br.BeoZone.SetQueueRandom(ctx, random)
 }
 
 func doGetSources(c *cli.Context) error {
-	args := c.Args()
-	if args.Len() != 1 {
-		cli.ShowSubcommandHelpAndExit(c, 1)
+	args := productArgs(c, 1)
+	br, err := getClient(args.First())
+	if err != nil {
+		return err
 	}
-	br := beoremote.NewClient(args.First())
 	products, err := br.BeoZone.GetSystemProducts(c.Context)
 	if err != nil {
 		return err
 	}
+	category := c.String("category")
 	tw := new(tabwriter.Writer)
 	tw.Init(os.Stdout, 8, 4, 1, ' ', 0)
-	_, _ = fmt.Fprintln(tw, "PRODUCT NAME\tSOURCE NAME\tSOURCE ID\tLINKABLE")
-	for _, product := range products {
-		for _, source := range product.Source {
-			_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%t\n",
-				product.FriendlyName, source.FriendlyName, source.Id, source.Linkable)
+	_, _ = fmt.Fprintln(tw, "PRODUCT NAME\tSOURCE NAME\tSOURCE ID\tSOURCE TYPE\tCATEGORY\tPROFILE\tLINKABLE\tIN USE")
+	for i := range products {
+		product := &products[i]
+		for j := range product.Source {
+			source := &product.Source[j]
+			if c.Bool("local") && !beoremote.IsLocal(product, source) ||
+				c.Bool("linkable") && !source.Linkable ||
+				c.Bool("in-use") && !source.InUse ||
+				category != "" && !strings.EqualFold(source.Category, category) {
+				continue
+			}
+			_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%t\t%t\n",
+				product.FriendlyName, source.FriendlyName, source.Id, source.SourceType.Type,
+				source.Category, source.Profile, source.Linkable, source.InUse)
 		}
 	}
 	_ = tw.Flush()
 	return nil
 }
 
-func doSetActiveSource(c *cli.Context) error {
-	args := c.Args()
-	if args.Len() != 2 {
-		cli.ShowSubcommandHelpAndExit(c, 1)
+// productJid returns the JID of the cached product at addr, if any.
+func productJid(addr string) string {
+	products, err := getCachedProducts()
+	if err != nil {
+		return ""
+	}
+	for jid, p := range products {
+		for _, ip := range p.IPs {
+			if ip.String() == addr {
+				return string(jid)
+			}
+		}
+	}
+	return ""
+}
+
+// resolveSource turns a source name, type or ID, optionally prefixed with
+// the product it belongs to as in "kitchen/Deezer", into a source ID
+// that br can play. Without a product, br's own sources are searched.
+func resolveSource(ctx context.Context, br *beoremote.Client, ref string) (models.SourceID, error) {
+	product, name := beoremote.ParseSourceRef(ref)
+	if product == "" {
+		product = productJid(br.Addr())
+	} else if addr, err := resolveProduct(product); err == nil {
+		if jid := productJid(addr); jid != "" {
+			product = jid
+		}
+	}
+	source, err := br.ResolveSource(ctx, product, name)
+	if err != nil {
+		// Source IDs such as "deezer:2714.1200298.28240218@products.bang-olufsen.com"
+		// are passed on as they are, in case the product knows better.
+		if errors.Is(err, beoremote.ErrSourceNotFound) && !strings.Contains(ref, "/") && strings.Contains(name, ":") {
+			return models.SourceID(name), nil
+		}
+		return "", err
+	}
+	return source.Id, nil
+}
+
+func doSetActiveSource(ctx context.Context, _ *cli.Context, br *beoremote.Client, args []string) (string, error) {
+	id, err := resolveSource(ctx, br, args[0])
+	if err != nil {
+		return "", err
 	}
-	br := beoremote.NewClient(args.First())
-	return br.BeoZone.PlaySource(c.Context, models.SourceID(args.Get(1)))
+	return "", br.BeoZone.PlaySource(ctx, id)
 }
 
 func doGetActiveSources(c *cli.Context) error {
-	args := c.Args()
-	if args.Len() != 1 {
-		cli.ShowSubcommandHelpAndExit(c, 1)
+	args := productArgs(c, 1)
+	br, err := getClient(args.First())
+	if err != nil {
+		return err
 	}
-	br := beoremote.NewClient(args.First())
 	as, err := br.BeoZone.GetActiveSources(c.Context)
 	if err != nil {
 		return err
@@ -536,20 +658,20 @@ func doGetActiveSources(c *cli.Context) error {
 }
 
 func doAddListener(c *cli.Context) error {
-	args := c.Args()
-	if args.Len() != 2 {
-		cli.ShowSubcommandHelpAndExit(c, 1)
+	args := productArgs(c, 2)
+	br, err := getClient(args.First())
+	if err != nil {
+		return err
 	}
-	br := beoremote.NewClient(args.First())
 	return br.BeoZone.AddListener(c.Context, models.Jid(args.Get(1)))
 }
 
 func doRemoveListener(c *cli.Context) error {
-	args := c.Args()
-	if args.Len() != 2 {
-		cli.ShowSubcommandHelpAndExit(c, 1)
+	args := productArgs(c, 2)
+	br, err := getClient(args.First())
+	if err != nil {
+		return err
 	}
-	br := beoremote.NewClient(args.First())
 	return br.BeoZone.RemoveListener(c.Context, models.Jid(args.Get(1)))
 }
 
@@ -558,7 +680,7 @@ func doSearchArtist(c *cli.Context) error {
 	if args.Len() != 1 {
 		cli.ShowSubcommandHelpAndExit(c, 1)
 	}
-	d := deezer.NewClient()
+	d := getDeezerClient(c)
 	artists, err := d.SearchArtist(c.Context, &deezer.SearchOptions{
 		Q:     args.First(),
 		Index: 0,
@@ -582,7 +704,7 @@ func doListAlbums(c *cli.Context) error {
 	if args.Len() != 1 {
 		cli.ShowSubcommandHelpAndExit(c, 1)
 	}
-	d := deezer.NewClient()
+	d := getDeezerClient(c)
 	iter := d.NewAlbumIter(args.First())
 	tw := new(tabwriter.Writer)
 	tw.Init(os.Stdout, 8, 4, 1, ' ', 0)
@@ -613,7 +735,7 @@ func doListTracks(c *cli.Context) error {
 	if args.Len() != 1 {
 		return os.ErrInvalid
 	}
-	d := deezer.NewClient()
+	d := getDeezerClient(c)
 	tracks, err := d.GetAlbumTracks(c.Context, args.First())
 	if err != nil {
 		return err
@@ -628,6 +750,86 @@ func doListTracks(c *cli.Context) error {
 	return nil
 }
 
+func doCharts(c *cli.Context) error {
+	args := c.Args()
+	if args.Len() > 1 {
+		cli.ShowSubcommandHelpAndExit(c, 1)
+	}
+	genre := "0"
+	if args.Present() {
+		genre = args.First()
+	}
+	d := getDeezerClient(c)
+	chart, err := d.GetChart(c.Context, genre)
+	if err != nil {
+		return err
+	}
+	tw := new(tabwriter.Writer)
+	tw.Init(os.Stdout, 8, 4, 1, ' ', 0)
+	switch c.String("type") {
+	case "tracks":
+		_, _ = fmt.Fprintln(tw, "#\tID\tTITLE\tARTIST\tALBUM")
+		for i, t := range chart.Tracks.Data {
+			var artist, album string
+			if t.Artist != nil {
+				artist = t.Artist.Name
+			}
+			if t.Album != nil {
+				album = t.Album.Title
+			}
+			_, _ = fmt.Fprintf(tw, "%d\t%d\t%s\t%s\t%s\n", i+1, t.ID, t.Title, artist, album)
+		}
+	case "albums":
+		_, _ = fmt.Fprintln(tw, "#\tID\tTITLE\tARTIST")
+		for i, a := range chart.Albums.Data {
+			var artist string
+			if a.Artist != nil {
+				artist = a.Artist.Name
+			}
+			_, _ = fmt.Fprintf(tw, "%d\t%d\t%s\t%s\n", i+1, a.ID, a.Title, artist)
+		}
+	case "artists":
+		_, _ = fmt.Fprintln(tw, "#\tID\tNAME")
+		for i, a := range chart.Artists.Data {
+			_, _ = fmt.Fprintf(tw, "%d\t%d\t%s\n", i+1, a.ID, a.Name)
+		}
+	case "playlists":
+		_, _ = fmt.Fprintln(tw, "#\tID\tTITLE\tTRACKS\tBY")
+		for i, p := range chart.Playlists.Data {
+			var user string
+			if p.User != nil {
+				user = p.User.Name
+			}
+			_, _ = fmt.Fprintf(tw, "%d\t%d\t%s\t%d\t%s\n", i+1, p.ID, p.Title, p.NbTracks, user)
+		}
+	case "podcasts":
+		_, _ = fmt.Fprintln(tw, "#\tID\tTITLE\tFANS")
+		for i, p := range chart.Podcasts.Data {
+			_, _ = fmt.Fprintf(tw, "%d\t%d\t%s\t%d\n", i+1, p.ID, p.Title, p.Fans)
+		}
+	default:
+		return fmt.Errorf("invalid chart type: %q", c.String("type"))
+	}
+	_ = tw.Flush()
+	return nil
+}
+
+func doListGenres(c *cli.Context) error {
+	d := getDeezerClient(c)
+	genres, err := d.GetGenres(c.Context)
+	if err != nil {
+		return err
+	}
+	tw := new(tabwriter.Writer)
+	tw.Init(os.Stdout, 8, 4, 1, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "ID\tNAME")
+	for _, g := range genres {
+		_, _ = fmt.Fprintf(tw, "%d\t%s\n", g.ID, g.Name)
+	}
+	_ = tw.Flush()
+	return nil
+}
+
 func getArtistImages(a *deezerModels.Artist) []models.Image {
 	return []models.Image{
 		{
@@ -648,93 +850,348 @@ func getArtistImages(a *deezerModels.Artist) []models.Image {
 	}
 }
 
-func toQueueItem(t deezerModels.Track) models.PlayQueueItem {
-	a := models.Artist{
+// getAlbumImages returns the covers of an album, or nil if it has none.
+func getAlbumImages(a *deezerModels.Album) []models.Image {
+	if a == nil || a.CoverBig == "" {
+		return nil
+	}
+	return []models.Image{
+		{
+			URL:       a.CoverBig,
+			Size:      models.Large,
+			MediaType: "image/jpg",
+		},
+		{
+			URL:       a.CoverMedium,
+			Size:      models.Medium,
+			MediaType: "image/jpg",
+		},
+		{
+			URL:       a.CoverSmall,
+			Size:      models.Small,
+			MediaType: "image/jpg",
+		},
+	}
+}
+
+// normalizeName returns name as it's sorted and searched by, which is
+// lower case with runs of spaces collapsed.
+func normalizeName(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+func toQueueArtist(id int, name string) models.Artist {
+	return models.Artist{
 		Deezer: models.Deezer{
-			Id: t.Artist.ID,
+			Id: id,
 		},
-		Name:           t.Artist.Name,
-		NameNormalized: t.Artist.Name,
-		Id:             strconv.Itoa(t.Artist.ID),
+		Name:           name,
+		NameNormalized: normalizeName(name),
+		Id:             strconv.Itoa(id),
 		Image:          []models.Image{}, // The B&O app doesn't set this.
 	}
+}
+
+// toQueueItem returns a queue item for t. Only full tracks, as returned
+// by GetTrack, include the album and every contributing artist.
+func toQueueItem(t deezerModels.Track) models.PlayQueueItem {
+	var artists []models.Artist
+	for _, c := range t.Contributors {
+		artists = append(artists, toQueueArtist(c.ID, c.Name))
+	}
+	if len(artists) == 0 {
+		artists = append(artists, toQueueArtist(t.Artist.ID, t.Artist.Name))
+	}
+	images := getAlbumImages(t.Album)
+	if images == nil {
+		images = getArtistImages(t.Artist)
+	}
+	var album string
+	if t.Album != nil {
+		album = t.Album.Title
+	}
 	qi := models.PlayQueueItem{
 		Track: &models.Track{
 			Deezer: &models.Deezer{
 				Id: t.ID,
 			},
-			Name:   t.Title,
-			Artist: []models.Artist{a},
-			Image:  getArtistImages(t.Artist),
-			Id:     strconv.Itoa(t.ID),
+			Name:                 t.Title,
+			TrackNumber:          t.TrackPosition,
+			Duration:             t.Duration,
+			ArtistName:           t.Artist.Name,
+			ArtistNameNormalized: normalizeName(t.Artist.Name),
+			Album:                album,
+			Artist:               artists,
+			Image:                images,
+			Id:                   strconv.Itoa(t.ID),
 		},
 		Behaviour: models.Planned,
 	}
 	return qi
 }
 
-func doQueueTrack(c *cli.Context) error {
-	args := c.Args()
-	if args.Len() != 2 {
-		cli.ShowSubcommandHelpAndExit(c, 1)
-	}
-	play := c.String("play")
-	switch play {
-	case "now", "next", "last":
-	default:
-		cli.ShowSubcommandHelpAndExit(c, 1)
+// trackFilter decides which deezer tracks may be queued.
+type trackFilter struct {
+	Market   string
+	Explicit bool
+}
+
+// trackFilterFlags are the flags of commands that queue deezer tracks.
+func trackFilterFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:  "market",
+			Usage: "Only queue tracks available in this country, e.g. DK (default: from config)",
+		},
+		&cli.BoolFlag{
+			Name:  "no-explicit",
+			Usage: "Don't queue tracks with explicit lyrics",
+		},
 	}
-	d := deezer.NewClient()
+}
+
+// getTrackFilter returns the configured filter, overridden by the
+// --market and --no-explicit flags.
+func getTrackFilter(c *cli.Context) trackFilter {
+	f := trackFilter{Market: strings.ToUpper(config.Deezer.Market), Explicit: true}
+	if config.Deezer.Explicit != nil {
+		f.Explicit = *config.Deezer.Explicit
+	}
+	if c.IsSet("market") {
+		f.Market = strings.ToUpper(c.String("market"))
+	}
+	if c.Bool("no-explicit") {
+		f.Explicit = false
+	}
+	return f
+}
+
+// allowed reports whether t may be queued. Tracks that don't say which
+// countries they're available in are assumed to be available everywhere.
+func (f trackFilter) allowed(t deezerModels.Track) bool {
+	if !f.Explicit && t.ExplicitLyrics {
+		return false
+	}
+	if f.Market == "" || len(t.AvailableCountries) == 0 {
+		return true
+	}
+	for _, country := range t.AvailableCountries {
+		if country == f.Market {
+			return true
+		}
+	}
+	return false
+}
+
+func (f trackFilter) playable(t deezerModels.Track) bool {
+	return t.Readable && f.allowed(t)
+}
+
+// resolve returns the full version of t to queue, which is t itself, or
+// its alternative if t can't be played or isn't allowed. Tracks listed on
+// albums and playlists don't say where they're available, what their
+// alternative is, or who contributed to them, so the full track is
+// fetched. nil is returned if there's no version that can be queued,
+// including when Deezer no longer has the track or its alternative.
+func (f trackFilter) resolve(ctx context.Context, d *deezer.Client, t deezerModels.Track) (*deezerModels.Track, error) {
+	if len(t.Contributors) == 0 {
+		full, err := d.GetTrack(ctx, strconv.Itoa(t.ID))
+		if errors.Is(err, deezer.ErrNotFound) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		t = full
+	}
+	if f.playable(t) {
+		return &t, nil
+	}
+	if t.Alternative == nil {
+		return nil, nil
+	}
+	alt, err := d.GetTrack(ctx, strconv.Itoa(t.Alternative.ID))
+	if errors.Is(err, deezer.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if f.playable(alt) {
+		return &alt, nil
+	}
+	return nil, nil
+}
+
+// checkPlayMode returns the --play flag, showing the usage if it's invalid.
+func checkPlayMode(c *cli.Context) string {
+	play := playMode(c)
+	switch play {
+	case "now", "next", "last":
+	default:
+		cli.ShowSubcommandHelpAndExit(c, 1)
+	}
+	return play
+}
+
+// intervalFlag returns the duration flag name, which must be positive as
+// it's used for a ticker.
+func intervalFlag(c *cli.Context, name string) (time.Duration, error) {
+	d := c.Duration(name)
+	if d <= 0 {
+		return 0, fmt.Errorf("--%s must be greater than zero", name)
+	}
+	return d, nil
+}
+
+// queuePositionFlags are the flags of commands that queue items next to
+// another item.
+func queuePositionFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:  "after",
+			Usage: "Insert after the queue item with this PLID, instead of as --play says",
+		},
+		&cli.StringFlag{
+			Name:  "before",
+			Usage: "Insert before the queue item with this PLID, instead of as --play says",
+		},
+	}
+}
+
+// queuePosition returns where the --play, --after and --before flags say
+// items should be queued.
+func queuePosition(c *cli.Context) beoremote.Position {
+	after, before := c.String("after"), c.String("before")
+	switch {
+	case after != "" && before != "":
+		cli.ShowSubcommandHelpAndExit(c, 1)
+	case after != "":
+		return beoremote.After(after)
+	case before != "":
+		return beoremote.Before(before)
+	}
+	return beoremote.At(beoremote.When(checkPlayMode(c)))
+}
+
+func doQueueTrack(c *cli.Context) error {
+	args := productArgs(c, 2)
+	pos := queuePosition(c)
+	d := getDeezerClient(c)
 	t, err := d.GetTrack(c.Context, args.Get(1))
 	if err != nil {
 		return err
 	}
-	br := beoremote.NewClient(args.First())
-	if play == "now" {
+	return queueDeezerTrack(c, d, args.First(), pos, t)
+}
+
+// queueDeezerTrack queues t, or its alternative, on product.
+func queueDeezerTrack(c *cli.Context, d *deezer.Client, product string, pos beoremote.Position, t deezerModels.Track) error {
+	resolved, err := getTrackFilter(c).resolve(c.Context, d, t)
+	if err != nil {
+		return err
+	}
+	if resolved == nil {
+		return fmt.Errorf("track %d can't be played, or is filtered out by the deezer market or explicit settings", t.ID)
+	}
+	br, err := getClient(product)
+	if err != nil {
+		return err
+	}
+	if pos.When == beoremote.Now {
 		// We clear the queue to match what the B&O app does.
 		if err = br.BeoZone.ClearPlayQueue(c.Context); err != nil {
 			return err
 		}
 	}
-	return br.BeoZone.AddQueueItem(c.Context, toQueueItem(t), beoremote.When(play))
+	return br.BeoZone.AddQueueItem(c.Context, toQueueItem(*resolved), pos)
 }
 
 func doQueueDeezerAlbum(c *cli.Context) error {
-	args := c.Args()
-	if args.Len() != 2 {
-		cli.ShowSubcommandHelpAndExit(c, 1)
+	args := productArgs(c, 2)
+	pos := queuePosition(c)
+	d := getDeezerClient(c)
+	tracks, err := d.GetAlbumTracks(c.Context, args.Get(1))
+	if err != nil {
+		return err
 	}
-	play := c.String("play")
-	switch play {
-	case "now", "next", "last":
-	default:
-		cli.ShowSubcommandHelpAndExit(c, 1)
+	return queueDeezerTracks(c, d, args.First(), pos, tracks)
+}
+
+// queueDeezerTracks queues tracks, or their alternatives, on product,
+// skipping those that can't be queued.
+func queueDeezerTracks(c *cli.Context, d *deezer.Client, product string, pos beoremote.Position, tracks []deezerModels.Track) error {
+	var items []models.PlayQueueItem
+	filter := getTrackFilter(c)
+	for _, t := range tracks {
+		resolved, err := filter.resolve(c.Context, d, t)
+		if err != nil {
+			return err
+		}
+		if resolved == nil {
+			_, _ = fmt.Fprintf(os.Stderr, "Skipping %q, it can't be played or is filtered out.\n", t.Title)
+			continue
+		}
+		items = append(items, toQueueItem(*resolved))
 	}
-	d := deezer.NewClient()
-	tracks, err := d.GetAlbumTracks(c.Context, args.Get(1))
+	if len(items) == 0 {
+		return errors.New("every track can't be played, or is filtered out by the deezer market or explicit settings")
+	}
+	br, err := getClient(product)
 	if err != nil {
 		return err
 	}
-	br := beoremote.NewClient(args.First())
-	if play == "now" {
+	if pos.When == beoremote.Now {
 		// We clear the queue to match what the B&O app does.
 		if err = br.BeoZone.ClearPlayQueue(c.Context); err != nil {
 			return err
 		}
 	}
-	var items []models.PlayQueueItem
-	for _, t := range tracks {
-		items = append(items, toQueueItem(t))
+	return br.BeoZone.AddDeezerTracks(c.Context, items, pos)
+}
+
+// doQueue queues whatever a deezer link points at. Artists are queued
+// as their top tracks, and radios as the tracks they're playing now.
+func doQueue(c *cli.Context) error {
+	args := productArgs(c, 2)
+	pos := queuePosition(c)
+	d := getDeezerClient(c)
+	link, err := d.ResolveLink(c.Context, args.Get(1))
+	if err != nil {
+		return err
+	}
+	var tracks []deezerModels.Track
+	switch link.Type {
+	case deezer.LinkTrack:
+		t, err := d.GetTrack(c.Context, link.ID)
+		if err != nil {
+			return err
+		}
+		return queueDeezerTrack(c, d, args.First(), pos, t)
+	case deezer.LinkAlbum:
+		tracks, err = d.GetAlbumTracks(c.Context, link.ID)
+	case deezer.LinkPlaylist:
+		tracks, err = d.GetPlaylistTracks(c.Context, link.ID)
+	case deezer.LinkArtist:
+		tracks, err = d.GetArtistTopTracks(c.Context, link.ID, c.Int("limit"))
+	case deezer.LinkRadio:
+		tracks, err = d.GetRadioTracks(c.Context, link.ID)
+	}
+	if err != nil {
+		return err
 	}
-	return br.BeoZone.AddDeezerTracks(c.Context, items, beoremote.When(play))
+	if len(tracks) == 0 {
+		return fmt.Errorf("deezer %s has no tracks", link)
+	}
+	return queueDeezerTracks(c, d, args.First(), pos, tracks)
 }
 
 func doGetTimers(c *cli.Context) error {
-	args := c.Args()
-	if args.Len() != 1 {
-		cli.ShowSubcommandHelpAndExit(c, 1)
+	args := productArgs(c, 1)
+	br, err := getClient(args.First())
+	if err != nil {
+		return err
 	}
-	br := beoremote.NewClient(args.First())
 	timers, err := br.BeoHome.GetTimers(c.Context)
 	if err != nil {
 		return err
@@ -777,22 +1234,30 @@ func doGetTimers(c *cli.Context) error {
 }
 
 func doDeleteTimer(c *cli.Context) error {
-	args := c.Args()
-	if args.Len() != 2 {
-		cli.ShowSubcommandHelpAndExit(c, 1)
+	args := productArgs(c, 2)
+	br, err := getClient(args.First())
+	if err != nil {
+		return err
 	}
-	br := beoremote.NewClient(args.First())
 	return br.BeoHome.DeleteTimer(c.Context, args.Get(1))
 }
 
 func doWatchNotifications(c *cli.Context) error {
-	args := c.Args()
-	if args.Len() != 1 {
-		cli.ShowSubcommandHelpAndExit(c, 1)
+	args := productArgs(c, 1)
+	products, err := getWatchedProducts(args.Slice())
+	if err != nil {
+		return err
+	}
+	p := products[0]
+	var recorder *notificationRecorder
+	if path := c.String("record"); path != "" {
+		if recorder, err = newNotificationRecorder(path, p); err != nil {
+			return err
+		}
+		defer recorder.Close()
 	}
-	br := beoremote.NewClient(args.First())
 retry:
-	events, err := br.BeoZone.OpenNotificationStream(c.Context)
+	events, err := p.Client.BeoZone.OpenNotificationStream(c.Context)
 	if err != nil {
 		return err
 	}
@@ -803,27 +1268,66 @@ retry:
 			fmt.Printf("[Reconnecting...]\n\n")
 			goto retry
 		}
-		var n models.NotificationWrapper
 		if event.Err != nil {
 			fmt.Printf("Error: %v\n\n", event.Err)
-		} else if err = json.Unmarshal(event.Value, &n); err != nil {
+			continue
+		}
+		// Everything the product sends is recorded, even if it can't be
+		// decoded, so that replay sees the same.
+		if recorder != nil {
+			if err = recorder.Record(event.Value, time.Now()); err != nil {
+				return err
+			}
+		}
+		var n models.NotificationWrapper
+		if err = json.Unmarshal(event.Value, &n); err != nil {
 			fmt.Printf("Error: %s\n\n", err)
 		} else {
-			fmt.Printf("Type: %s\n", n.Notification.Type)
-			fmt.Printf("Kind: %s\n", n.Notification.Kind)
-			fmt.Printf("Timestamp: %s\n", n.Notification.Timestamp)
-			fmt.Printf("Data: %s\n\n", string(n.Notification.Data))
+			printNotification(&n.Notification)
 		}
 	}
 	return nil
 }
 
+// printNotification prints a notification as watch does.
+func printNotification(n *models.Notification) {
+	fmt.Printf("Type: %s\n", n.Type)
+	fmt.Printf("Kind: %s\n", n.Kind)
+	fmt.Printf("Timestamp: %s\n", n.Timestamp)
+	if _, err := n.Decode(); err != nil {
+		fmt.Printf("Error: %v\n", err)
+	}
+	fmt.Printf("Data: %s\n\n", string(n.Data))
+}
+
 func main() {
-	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 	app := &cli.App{
 		Name:  "beoutil",
 		Usage: "Control B&O products via the beoremote API",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "config",
+				Usage:   "Path to the config file",
+				EnvVars: []string{"BEOUTIL_CONFIG"},
+			},
+			&cli.StringFlag{
+				Name:    "profile",
+				Usage:   "Name of a profile in the config file to use",
+				EnvVars: []string{"BEOUTIL_PROFILE"},
+			},
+			&cli.BoolFlag{
+				Name:    "no-cache",
+				Usage:   "Don't use the Deezer response cache",
+				EnvVars: []string{"BEOUTIL_NO_CACHE"},
+			},
+			&cli.DurationFlag{
+				Name:  "cache-ttl",
+				Usage: "Cache Deezer responses for this long, whatever the server says",
+			},
+		},
+		Before: loadConfig,
 	}
 	app.Commands = append(app.Commands, &cli.Command{
 		Name:   "find-products",
@@ -836,149 +1340,347 @@ func main() {
 			},
 		},
 	})
+	app.Commands = append(app.Commands, &cli.Command{
+		Name:   "doctor",
+		Usage:  "Diagnose problems with discovery, the product cache and products",
+		Action: doDoctor,
+		Flags: append([]cli.Flag{
+			&cli.DurationFlag{
+				Name:  "timeout",
+				Value: 5 * time.Second,
+				Usage: "How long to spend discovering products",
+			},
+		}, fanOutFlags()...),
+	})
 	app.Commands = append(app.Commands, &cli.Command{
 		Name:   "list-products",
 		Usage:  "List discovered products",
 		Action: doListProducts,
+		Flags:  fanOutFlags(),
 	})
 	app.Commands = append(app.Commands, &cli.Command{
 		Name:     "all-standby",
 		Usage:    "Put all products into standby",
 		Category: "Power Management",
 		Action:   doAllStandby,
+		Flags:    fanOutFlags(),
+	})
+	app.Commands = append(app.Commands, &cli.Command{
+		Name:     "power-policy",
+		Usage:    "Put idle products into standby and enforce quiet hours",
+		Category: "Power Management",
+		Action:   doPowerPolicy,
+		Flags: []cli.Flag{
+			&cli.DurationFlag{
+				Name:  "idle",
+				Usage: "Put products into standby after they've been idle this long, e.g. 30m",
+			},
+			&cli.StringFlag{
+				Name:  "quiet-hours",
+				Usage: "Time window to limit the volume in, e.g. 22:00-07:00",
+			},
+			&cli.IntFlag{
+				Name:  "quiet-volume",
+				Value: 25,
+				Usage: "Maximum volume during quiet hours",
+			},
+			&cli.StringSliceFlag{
+				Name:  "product",
+				Usage: "Only manage these products (default: every cached product)",
+			},
+			&cli.DurationFlag{
+				Name:  "reconcile-interval",
+				Value: 5 * time.Minute,
+				Usage: "How often to check each product's power state",
+			},
+			&cli.DurationFlag{
+				Name:  "report-interval",
+				Value: time.Hour,
+				Usage: "How often to print how long each product has been on",
+			},
+			&cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "Log what would be done without doing it",
+			},
+		},
 	})
 	app.Commands = append(app.Commands, &cli.Command{
 		Name:      "standby",
 		Usage:     "Put product into standby mode",
-		ArgsUsage: "<product IP>",
+		ArgsUsage: "<product[,product...]>",
 		Category:  "Power Management",
-		Action:    doStandby,
+		Action:    targetAction(0, doStandby),
+		Flags:     targetFlags(),
 	})
 	app.Commands = append(app.Commands, &cli.Command{
 		Name:      "poweron",
 		Usage:     "Power on product",
-		ArgsUsage: "<product IP>",
+		ArgsUsage: "<product[,product...]>",
 		Category:  "Power Management",
-		Action:    doPowerOn,
+		Action:    targetAction(0, doPowerOn),
+		Flags:     targetFlags(),
 	})
 	app.Commands = append(app.Commands, &cli.Command{
 		Name:      "reboot",
 		Usage:     "Reboot product",
-		ArgsUsage: "<product IP>",
+		ArgsUsage: "<product[,product...]>",
 		Category:  "Power Management",
-		Action:    doReboot,
+		Action:    targetAction(0, doReboot),
+		Flags:     targetFlags(),
 	})
 	app.Commands = append(app.Commands, &cli.Command{
 		Name:      "get-volume",
 		Usage:     "Set speaker volume",
-		ArgsUsage: "<product IP>",
+		ArgsUsage: "<product[,product...]>",
 		Category:  "Speaker",
-		Action:    doGetVolume,
+		Action:    targetActionTo(os.Stderr, 0, doGetVolume),
+		Flags:     targetFlags(),
 	})
 	app.Commands = append(app.Commands, &cli.Command{
 		Name:      "set-volume",
 		Usage:     "Get speaker volume",
-		ArgsUsage: "<product IP> <0-100>",
+		ArgsUsage: "<product[,product...]> <0-100>",
 		Category:  "Speaker",
-		Action:    doSetVolume,
+		Action:    targetAction(1, doSetVolume),
+		Flags:     targetFlags(),
 	})
 	app.Commands = append(app.Commands, &cli.Command{
 		Name:      "get-muted",
 		Usage:     "Set speaker volume",
-		ArgsUsage: "<product IP>",
+		ArgsUsage: "<product[,product...]>",
 		Category:  "Speaker",
-		Action:    doGetMuted,
+		Action:    targetAction(0, doGetMuted),
+		Flags:     targetFlags(),
 	})
 	app.Commands = append(app.Commands, &cli.Command{
 		Name:      "set-muted",
 		Usage:     "Get speaker volume",
-		ArgsUsage: "<product IP> <true|false>",
+		ArgsUsage: "<product[,product...]> <true|false>",
 		Category:  "Speaker",
-		Action:    doSetMuted,
+		Action:    targetAction(1, doSetMuted),
+		Flags:     targetFlags(),
 	})
 	app.Commands = append(app.Commands, &cli.Command{
 		Name:      "pause",
 		Usage:     "Pause the stream",
-		ArgsUsage: "<product IP>",
+		ArgsUsage: "<product[,product...]>",
 		Category:  "Stream",
-		Action:    doPause,
+		Action:    streamAction(doPause),
+		Flags:     streamFlags(),
 	})
 	app.Commands = append(app.Commands, &cli.Command{
 		Name:      "play",
 		Usage:     "Unpause the stream",
-		ArgsUsage: "<product IP>",
+		ArgsUsage: "<product[,product...]>",
 		Category:  "Stream",
-		Action:    doPlay,
+		Action:    streamAction(doPlay),
+		Flags:     streamFlags(),
+	})
+	app.Commands = append(app.Commands, &cli.Command{
+		Name:      "toggle",
+		Usage:     "Pause the stream if it's playing, otherwise unpause it",
+		ArgsUsage: "<product[,product...]>",
+		Category:  "Stream",
+		Action:    streamAction(doToggle),
+		Flags: streamFlags(
+			&cli.DurationFlag{
+				Name:  "timeout",
+				Usage: "How long to wait for the current play state, and for it to change",
+				Value: 5 * time.Second,
+			},
+		),
 	})
 	app.Commands = append(app.Commands, &cli.Command{
 		Name:      "forward",
 		Usage:     "Play the next track",
-		ArgsUsage: "<product IP>",
+		ArgsUsage: "<product[,product...]>",
 		Category:  "Stream",
-		Action:    doForward,
+		Action:    streamAction(doForward),
+		Flags:     streamFlags(),
 	})
 	app.Commands = append(app.Commands, &cli.Command{
 		Name:      "backward",
 		Usage:     "Play the previous track",
-		ArgsUsage: "<product IP>",
+		ArgsUsage: "<product[,product...]>",
 		Category:  "Stream",
-		Action:    doBackward,
+		Action:    streamAction(doBackward),
+		Flags:     streamFlags(),
 	})
 	app.Commands = append(app.Commands, &cli.Command{
 		Name:      "stop",
 		Usage:     "Stop playback",
-		ArgsUsage: "<product IP>",
+		ArgsUsage: "<product[,product...]>",
 		Category:  "Stream",
-		Action:    doStop,
+		Action:    streamAction(doStop),
+		Flags:     streamFlags(),
+	})
+	app.Commands = append(app.Commands, &cli.Command{
+		Name:      "seek",
+		Usage:     "Seek within the current track",
+		ArgsUsage: "<product[,product...]> <[+|-]position, e.g. 1:23 or +30s>",
+		Category:  "Stream",
+		Action:    targetAction(1, doSeek),
+		Flags: targetFlags(
+			&cli.DurationFlag{
+				Name:  "timeout",
+				Usage: "How long to wait for the current play position",
+				Value: 5 * time.Second,
+			},
+		),
 	})
 	app.Commands = append(app.Commands, &cli.Command{
 		Name:      "get-queue",
 		Usage:     "Get play queue",
-		ArgsUsage: "<product IP>",
+		ArgsUsage: "<product>",
 		Category:  "Queue",
 		Action:    doGetQueue,
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "all",
+				Usage: "Get the whole queue, not just the items around the one playing",
+			},
+			&cli.BoolFlag{
+				Name:  "follow",
+				Usage: "Print the whole queue again whenever it changes",
+			},
+			&cli.BoolFlag{
+				Name:  "covers",
+				Usage: "Show the URL of each item's cover art",
+			},
+		},
 	})
 	app.Commands = append(app.Commands, &cli.Command{
 		Name:      "clear-queue",
 		Usage:     "Clear play queue",
-		ArgsUsage: "<product IP>",
+		ArgsUsage: "<product[,product...]>",
 		Category:  "Queue",
-		Action:    doClearQueue,
+		Action:    targetAction(0, doClearQueue),
+		Flags:     targetFlags(),
 	})
 	app.Commands = append(app.Commands, &cli.Command{
 		Name:      "remove-qitem",
 		Usage:     "Removed item from the play queue",
-		ArgsUsage: "<product IP> <playlist ID>",
+		ArgsUsage: "<product> <playlist ID>",
 		Category:  "Queue",
 		Action:    doRemoveQueueItem,
 	})
 	app.Commands = append(app.Commands, &cli.Command{
 		Name:      "move-qitem",
 		Usage:     "Move an item in the play queue",
-		ArgsUsage: "<product IP> <playlist ID> <before playlist ID>",
+		ArgsUsage: "<product> <playlist ID> <before playlist ID>",
 		Category:  "Queue",
 		Action:    doMoveQueueItem,
 	})
 	app.Commands = append(app.Commands, &cli.Command{
 		Name:      "play-qitem",
 		Usage:     "Play queue from the specified item",
-		ArgsUsage: "<product IP> <playlist ID>",
+		ArgsUsage: "<product> <playlist ID>",
 		Category:  "Queue",
 		Action:    doPlayQueueItem,
 	})
 	app.Commands = append(app.Commands, &cli.Command{
 		Name:      "set-repeat",
 		Usage:     "Set queue repeat mode",
-		ArgsUsage: "<product IP> <current|all|off>",
+		ArgsUsage: "<product[,product...]> <current|all|off>",
 		Category:  "Queue",
-		Action:    doSetRepeat,
+		Action:    targetAction(1, doSetRepeat),
+		Flags:     targetFlags(),
 	})
 	app.Commands = append(app.Commands, &cli.Command{
 		Name:      "set-random",
 		Usage:     "Set queue random mode",
-		ArgsUsage: "<product IP> <on|off>",
+		ArgsUsage: "<product[,product...]> <on|off>",
+		Category:  "Queue",
+		Action:    targetAction(1, doSetRandom),
+		Flags:     targetFlags(),
+	})
+	app.Commands = append(app.Commands, &cli.Command{
+		Name:      "transfer-queue",
+		Usage:     "Copy the play queue from one product to another",
+		ArgsUsage: "<from product> <to product>",
 		Category:  "Queue",
-		Action:    doSetRandom,
+		Action:    doTransferQueue,
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "stop",
+				Usage: "Stop playback on the source product afterwards",
+			},
+			&cli.BoolFlag{
+				Name:  "standby",
+				Usage: "Put the source product into standby afterwards",
+			},
+			&cli.DurationFlag{
+				Name:  "timeout",
+				Usage: "How long to wait for the source's play position",
+				Value: 2 * time.Second,
+			},
+		},
+	})
+	dryRunFlag := &cli.BoolFlag{
+		Name:  "dry-run",
+		Usage: "Show the changes without applying them",
+	}
+	app.Commands = append(app.Commands, &cli.Command{
+		Name:     "edit-queue",
+		Usage:    "Edit the play queue in bulk",
+		Category: "Queue",
+		Subcommands: []*cli.Command{
+			{
+				Name:      "dedupe",
+				Usage:     "Remove duplicate deezer tracks",
+				ArgsUsage: "<product>",
+				Action:    queueEditAction(editDedupe),
+				Flags:     []cli.Flag{dryRunFlag},
+			},
+			{
+				Name:      "sort",
+				Usage:     "Sort upcoming items",
+				ArgsUsage: "<product>",
+				Action:    queueEditAction(editSort),
+				Flags: []cli.Flag{
+					dryRunFlag,
+					&cli.StringFlag{
+						Name:  "by",
+						Value: "artist,album,title",
+						Usage: "Comma separated sort keys (values: artist,album,title)",
+					},
+				},
+			},
+			{
+				Name:      "trim",
+				Usage:     "Keep only the next N upcoming items",
+				ArgsUsage: "<product>",
+				Action:    queueEditAction(editTrim),
+				Flags: []cli.Flag{
+					dryRunFlag,
+					&cli.IntFlag{
+						Name:     "keep",
+						Usage:    "Number of upcoming items to keep",
+						Required: true,
+					},
+				},
+			},
+			{
+				Name:      "remove-played",
+				Usage:     "Remove items before the one playing",
+				ArgsUsage: "<product>",
+				Action:    queueEditAction(editRemovePlayed),
+				Flags:     []cli.Flag{dryRunFlag},
+			},
+			{
+				Name:      "shuffle",
+				Usage:     "Shuffle upcoming items",
+				ArgsUsage: "<product>",
+				Action:    queueEditAction(editShuffle),
+				Flags: []cli.Flag{
+					dryRunFlag,
+					&cli.Int64Flag{
+						Name:  "seed",
+						Usage: "Seed for a repeatable shuffle",
+					},
+				},
+			},
+		},
 	})
 	app.Commands = append(app.Commands, &cli.Command{
 		Name:      "search-artist",
@@ -1009,91 +1711,553 @@ func main() {
 		Category:  "Deezer",
 		Action:    doListTracks,
 	})
+	app.Commands = append(app.Commands, &cli.Command{
+		Name:      "charts",
+		Usage:     "Show the Deezer charts",
+		ArgsUsage: "[genre ID]",
+		Category:  "Deezer",
+		Action:    doCharts,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "type",
+				Value: "tracks",
+				Usage: "Chart to show: tracks, albums, artists, playlists or podcasts",
+			},
+		},
+	})
+	app.Commands = append(app.Commands, &cli.Command{
+		Name:     "genres",
+		Usage:    "List Deezer genres, for use with charts",
+		Category: "Deezer",
+		Action:   doListGenres,
+	})
+	app.Commands = append(app.Commands, &cli.Command{
+		Name:     "cache",
+		Usage:    "Manage the Deezer response cache",
+		Category: "Deezer",
+		Subcommands: []*cli.Command{
+			{
+				Name:   "stats",
+				Usage:  "Show what's in the cache",
+				Action: doCacheStats,
+			},
+			{
+				Name:   "clear",
+				Usage:  "Remove everything from the cache",
+				Action: doCacheClear,
+			},
+		},
+	})
 	app.Commands = append(app.Commands, &cli.Command{
 		Name:      "queue-track",
 		Usage:     "Queue a track from deezer",
-		ArgsUsage: "<product IP> <track ID>",
+		ArgsUsage: "<product> <track ID>",
 		Category:  "Deezer",
 		Action:    doQueueTrack,
-		Flags: []cli.Flag{
+		Flags: append([]cli.Flag{
 			&cli.StringFlag{
 				Name:  "play",
 				Value: "last",
 				Usage: "(values: now,next,last)",
 			},
-		},
+		}, append(queuePositionFlags(), trackFilterFlags()...)...),
 	})
 	app.Commands = append(app.Commands, &cli.Command{
 		Name:      "queue-album",
 		Usage:     "Queue an album from deezer",
-		ArgsUsage: "<product IP> <album ID>",
+		ArgsUsage: "<product> <album ID>",
 		Category:  "Deezer",
 		Action:    doQueueDeezerAlbum,
+		Flags: append([]cli.Flag{
+			&cli.StringFlag{
+				Name:  "play",
+				Value: "last",
+				Usage: "(values: now,next,last)",
+			},
+		}, append(queuePositionFlags(), trackFilterFlags()...)...),
+	})
+	app.Commands = append(app.Commands, &cli.Command{
+		Name:      "queue",
+		Usage:     "Queue a track, album, playlist, artist or radio by its deezer link",
+		ArgsUsage: "<product> <deezer URL or URI>",
+		Category:  "Deezer",
+		Action:    doQueue,
+		Flags: append([]cli.Flag{
+			&cli.StringFlag{
+				Name:  "play",
+				Value: "last",
+				Usage: "(values: now,next,last)",
+			},
+			&cli.IntFlag{
+				Name:  "limit",
+				Value: 25,
+				Usage: "How many top tracks of an artist to queue",
+			},
+		}, append(queuePositionFlags(), trackFilterFlags()...)...),
+	})
+	app.Commands = append(app.Commands, &cli.Command{
+		Name:      "search-stations",
+		Usage:     "Search for B&O radio stations",
+		ArgsUsage: "<product> <query string>",
+		Category:  "Radio",
+		Action:    doSearchStations,
 		Flags: []cli.Flag{
+			&cli.IntFlag{
+				Name:  "offset",
+				Usage: "Index of the first result",
+			},
+			&cli.IntFlag{
+				Name:  "limit",
+				Usage: "Maximum number of results",
+				Value: 10,
+			},
+		},
+	})
+	app.Commands = append(app.Commands, &cli.Command{
+		Name:      "list-stations",
+		Usage:     "Browse B&O radio stations",
+		ArgsUsage: "<product>",
+		Category:  "Radio",
+		Action:    doListStations,
+		Flags: []cli.Flag{
+			&cli.IntFlag{
+				Name:  "offset",
+				Usage: "Index of the first result",
+			},
+			&cli.IntFlag{
+				Name:  "limit",
+				Usage: "Maximum number of results",
+				Value: 50,
+			},
+		},
+	})
+	app.Commands = append(app.Commands, &cli.Command{
+		Name:      "queue-station",
+		Usage:     "Queue a B&O radio station",
+		ArgsUsage: "<product> <station ID or favourite name>",
+		Category:  "Radio",
+		Action:    doQueueStation,
+		Flags: append([]cli.Flag{
+			&cli.StringFlag{
+				Name:  "play",
+				Value: "now",
+				Usage: "(values: now,next,last)",
+			},
+		}, queuePositionFlags()...),
+	})
+	app.Commands = append(app.Commands, &cli.Command{
+		Name:     "favourite-stations",
+		Usage:    "Manage favourite radio stations",
+		Category: "Radio",
+		Subcommands: []*cli.Command{
+			{
+				Name:   "list",
+				Usage:  "List favourite stations",
+				Action: doListFavouriteStations,
+			},
+			{
+				Name:      "add",
+				Usage:     "Add a station to the favourites",
+				ArgsUsage: "<product> <station ID>",
+				Action:    doAddFavouriteStation,
+			},
+			{
+				Name:      "remove",
+				Usage:     "Remove a station from the favourites",
+				ArgsUsage: "<station ID or name>",
+				Action:    doRemoveFavouriteStation,
+			},
+		},
+	})
+	app.Commands = append(app.Commands, &cli.Command{
+		Name:      "now-playing",
+		Usage:     "Show what's playing on a product",
+		ArgsUsage: "<product>",
+		Category:  "Stream",
+		Action:    doNowPlaying,
+		Flags: []cli.Flag{
+			&cli.DurationFlag{
+				Name:  "timeout",
+				Usage: "How long to wait for the product to report what's playing",
+				Value: 5 * time.Second,
+			},
+		},
+	})
+	dlnaTimeoutFlag := &cli.DurationFlag{
+		Name:  "timeout",
+		Usage: "How long to search for media servers",
+		Value: 3 * time.Second,
+	}
+	app.Commands = append(app.Commands, &cli.Command{
+		Name:     "dlna-servers",
+		Usage:    "Discover DLNA media servers",
+		Category: "DLNA",
+		Action:   doListDLNAServers,
+		Flags:    []cli.Flag{dlnaTimeoutFlag},
+	})
+	app.Commands = append(app.Commands, &cli.Command{
+		Name:      "dlna-browse",
+		Usage:     "Browse a DLNA media server",
+		ArgsUsage: "<server name, UDN or URL> [object ID]",
+		Category:  "DLNA",
+		Action:    doDLNABrowse,
+		Flags:     []cli.Flag{dlnaTimeoutFlag},
+	})
+	app.Commands = append(app.Commands, &cli.Command{
+		Name:      "dlna-search",
+		Usage:     "Search a DLNA media server for tracks",
+		ArgsUsage: "<server name, UDN or URL>",
+		Category:  "DLNA",
+		Action:    doDLNASearch,
+		Flags: []cli.Flag{
+			dlnaTimeoutFlag,
+			&cli.StringFlag{
+				Name:  "artist",
+				Usage: "Artist name contains",
+			},
+			&cli.StringFlag{
+				Name:  "album",
+				Usage: "Album title contains",
+			},
+			&cli.StringFlag{
+				Name:  "title",
+				Usage: "Track title contains",
+			},
+			&cli.IntFlag{
+				Name:  "limit",
+				Usage: "Maximum number of results",
+				Value: 50,
+			},
+		},
+	})
+	app.Commands = append(app.Commands, &cli.Command{
+		Name:      "queue-dlna",
+		Usage:     "Queue a track or folder from a DLNA media server",
+		ArgsUsage: "<product> <server name, UDN or URL> <object ID>",
+		Category:  "DLNA",
+		Action:    doQueueDLNA,
+		Flags: append([]cli.Flag{
+			dlnaTimeoutFlag,
 			&cli.StringFlag{
 				Name:  "play",
 				Value: "last",
 				Usage: "(values: now,next,last)",
 			},
+		}, queuePositionFlags()...),
+	})
+	app.Commands = append(app.Commands, &cli.Command{
+		Name:      "dlna-standin",
+		Usage:     "Serve a directory of music as a DLNA media server",
+		ArgsUsage: "<directory>",
+		Category:  "DLNA",
+		Action:    doDLNAStandIn,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "name",
+				Value: "beoutil",
+				Usage: "Friendly name of the server",
+			},
+			&cli.StringFlag{
+				Name:  "addr",
+				Usage: "Address to advertise to products (default: first local address)",
+			},
+			&cli.IntFlag{
+				Name:  "port",
+				Value: 8200,
+			},
 		},
 	})
 	app.Commands = append(app.Commands, &cli.Command{
 		Name:      "get-sources",
 		Usage:     "Get sources available to product",
-		ArgsUsage: "<product IP>",
+		ArgsUsage: "<product>",
 		Category:  "Multiroom",
 		Action:    doGetSources,
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "local",
+				Usage: "Only show sources belonging to each product, not borrowed ones",
+			},
+			&cli.BoolFlag{
+				Name:  "linkable",
+				Usage: "Only show sources that can be shared with other products",
+			},
+			&cli.StringFlag{
+				Name:  "category",
+				Usage: "Only show sources in a category, e.g. MUSIC or RADIO",
+			},
+			&cli.BoolFlag{
+				Name:  "in-use",
+				Usage: "Only show sources in use",
+			},
+		},
 	})
 	app.Commands = append(app.Commands, &cli.Command{
 		Name:      "get-active",
 		Usage:     "Get active sources",
-		ArgsUsage: "<product IP>",
+		ArgsUsage: "<product>",
 		Category:  "Multiroom",
 		Action:    doGetActiveSources,
 	})
 	app.Commands = append(app.Commands, &cli.Command{
 		Name:      "set-active",
-		Usage:     "Get active source",
-		ArgsUsage: "<product IP> <source ID>",
+		Usage:     "Set active source",
+		ArgsUsage: "<product[,product...]> <[product/]source name, type or ID>",
 		Category:  "Multiroom",
-		Action:    doSetActiveSource,
+		Action:    targetAction(1, doSetActiveSource),
+		Flags:     targetFlags(),
 	})
 	app.Commands = append(app.Commands, &cli.Command{
 		Name:      "add-listener",
 		Usage:     "Add listener to primary experience",
-		ArgsUsage: "<product IP> <listener JID>",
+		ArgsUsage: "<product> <listener JID>",
 		Category:  "Multiroom",
 		Action:    doAddListener,
 	})
 	app.Commands = append(app.Commands, &cli.Command{
 		Name:      "remove-listener",
 		Usage:     "Remove listener from primary experience",
-		ArgsUsage: "<product IP> <listener JID>",
+		ArgsUsage: "<product> <listener JID>",
 		Category:  "Multiroom",
 		Action:    doRemoveListener,
 	})
 	app.Commands = append(app.Commands, &cli.Command{
 		Name:      "get-timers",
 		Usage:     "Get timers from product",
-		ArgsUsage: "<product IP>",
+		ArgsUsage: "<product>",
 		Category:  "Timers",
 		Action:    doGetTimers,
 	})
 	app.Commands = append(app.Commands, &cli.Command{
 		Name:      "delete-timer",
 		Usage:     "Delete a timer",
-		ArgsUsage: "<product IP> <timer ID>",
+		ArgsUsage: "<product> <timer ID>",
 		Category:  "Timers",
 		Action:    doDeleteTimer,
 	})
 	app.Commands = append(app.Commands, &cli.Command{
 		Name:      "watch",
 		Usage:     "Watch notifications from product",
-		ArgsUsage: "<product IP>",
+		ArgsUsage: "<product>",
 		Category:  "Notifications",
 		Action:    doWatchNotifications,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "record",
+				Usage: "Append the notifications to this file, as newline delimited JSON, for replay",
+			},
+		},
+	})
+	app.Commands = append(app.Commands, &cli.Command{
+		Name:      "replay",
+		Usage:     "Replay notifications recorded by watch --record",
+		ArgsUsage: "<file>",
+		Category:  "Notifications",
+		Action:    doReplay,
+		Flags: []cli.Flag{
+			&cli.Float64Flag{
+				Name:  "speed",
+				Value: 1,
+				Usage: "Replay this many times faster than recorded, or 0 for as fast as possible",
+			},
+			&cli.StringSliceFlag{
+				Name:  "product",
+				Usage: "Only replay notifications from these products",
+			},
+			&cli.StringFlag{
+				Name:  "automate",
+				Usage: "Run the rules in this file as a dry run",
+			},
+			&cli.BoolFlag{
+				Name:  "metrics",
+				Usage: "Print counts of notifications, plays and listening time at the end",
+			},
+			&cli.BoolFlag{
+				Name:  "quiet",
+				Usage: "Don't print each notification",
+			},
+		},
+	})
+	app.Commands = append(app.Commands, &cli.Command{
+		Name:      "automate",
+		Usage:     "Run actions when products send matching notifications",
+		ArgsUsage: "[rules file]",
+		Category:  "Notifications",
+		Action:    doAutomate,
+		Flags: []cli.Flag{
+			&cli.StringSliceFlag{
+				Name:  "product",
+				Usage: "Only watch these products (default: every cached product)",
+			},
+			&cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "Log the actions that would be run without running them",
+			},
+		},
+	})
+	app.Commands = append(app.Commands, &cli.Command{
+		Name:     "webhook",
+		Usage:    "Forward notifications to webhooks as JSON POSTs",
+		Category: "Notifications",
+		Action:   doWebhook,
+		Flags: []cli.Flag{
+			&cli.StringSliceFlag{
+				Name:  "url",
+				Usage: "URL to POST notifications to, instead of the webhooks in the config file",
+			},
+			&cli.StringSliceFlag{
+				Name:  "match",
+				Usage: "Only forward notifications matching key=value, e.g. type=VOLUME",
+			},
+			&cli.StringFlag{
+				Name:  "template",
+				Usage: "File containing a Go template for the payload",
+			},
+			&cli.StringFlag{
+				Name:    "secret",
+				Usage:   "Secret used to sign payloads with HMAC-SHA256",
+				EnvVars: []string{"BEOUTIL_WEBHOOK_SECRET"},
+			},
+			&cli.StringSliceFlag{
+				Name:  "product",
+				Usage: "Only watch these products (default: every cached product)",
+			},
+			&cli.IntFlag{
+				Name:  "attempts",
+				Value: 5,
+				Usage: "Number of attempts before a delivery is spooled",
+			},
+			&cli.DurationFlag{
+				Name:  "timeout",
+				Value: 10 * time.Second,
+				Usage: "Timeout for each delivery attempt",
+			},
+			&cli.StringFlag{
+				Name:  "spool-dir",
+				Usage: "Directory for failed deliveries (default: spool/webhook in the config directory)",
+			},
+			&cli.DurationFlag{
+				Name:  "spool-interval",
+				Value: time.Minute,
+				Usage: "How often to retry spooled deliveries",
+			},
+		},
+	})
+	limitFlag := &cli.IntFlag{
+		Name:  "limit",
+		Value: 10,
+		Usage: "Number of entries to show per product",
+	}
+	app.Commands = append(app.Commands, &cli.Command{
+		Name:     "history",
+		Usage:    "Record and report on what products have played",
+		Category: "History",
+		Subcommands: []*cli.Command{
+			{
+				Name:   "record",
+				Usage:  "Record what products play until interrupted",
+				Action: doRecordHistory,
+				Flags: []cli.Flag{
+					&cli.StringSliceFlag{
+						Name:  "product",
+						Usage: "Only record these products (default: every cached product)",
+					},
+				},
+			},
+			{
+				Name:   "top-artists",
+				Usage:  "Show the most played artists on each product",
+				Action: doTopArtists,
+				Flags:  historyFlags(limitFlag),
+			},
+			{
+				Name:   "top-tracks",
+				Usage:  "Show the most played tracks on each product",
+				Action: doTopTracks,
+				Flags:  historyFlags(limitFlag),
+			},
+			{
+				Name:   "daily",
+				Usage:  "Show listening time per day",
+				Action: doDailyHistory,
+				Flags:  historyFlags(),
+			},
+			{
+				Name:      "last",
+				Usage:     "Show the most recent plays",
+				ArgsUsage: "[count]",
+				Action:    doLastPlays,
+				Flags:     historyFlags(),
+			},
+			{
+				Name:   "export",
+				Usage:  "Export plays as CSV or JSON",
+				Action: doExportHistory,
+				Flags: historyFlags(
+					&cli.StringFlag{
+						Name:  "format",
+						Value: "csv",
+						Usage: "Export format: csv or json",
+					},
+					&cli.StringFlag{
+						Name:    "output",
+						Aliases: []string{"o"},
+						Usage:   "File to write to (default: stdout)",
+					},
+				),
+			},
+		},
+	})
+	app.Commands = append(app.Commands, &cli.Command{
+		Name:     "scrobble",
+		Usage:    "Submit Deezer tracks played on products to ListenBrainz",
+		Category: "History",
+		Action:   doScrobble,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "endpoint",
+				Value: listenbrainz.DefaultEndpoint,
+				Usage: "API root of a ListenBrainz compatible server",
+			},
+			&cli.StringFlag{
+				Name:    "token",
+				Usage:   "ListenBrainz user token",
+				EnvVars: []string{"BEOUTIL_LISTENBRAINZ_TOKEN"},
+			},
+			&cli.StringSliceFlag{
+				Name:  "product",
+				Usage: "Only watch these products (default: every cached product)",
+			},
+			&cli.DurationFlag{
+				Name:  "retry-interval",
+				Value: 5 * time.Minute,
+				Usage: "How often to retry submitting queued listens",
+			},
+		},
+	})
+	app.Commands = append(app.Commands, &cli.Command{
+		Name:      "run",
+		Usage:     "Run a script of beoutil commands",
+		ArgsUsage: "<file|->",
+		Action:    doRun,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "on-error",
+				Value: "continue",
+				Usage: "What to do when a line fails: continue or abort",
+			},
+			&cli.BoolFlag{
+				Name:  "quiet",
+				Usage: "Don't print a summary when the script finishes",
+			},
+		},
 	})
 	if err := app.RunContext(ctx, os.Args); err != nil {
-		log.Fatal(err)
+		log.Print(err)
+		if k := getErrorKind(err); k != nil {
+			log.Printf("hint: %s", k.Hint)
+		}
+		stop()
+		os.Exit(exitCode(err))
 	}
 }
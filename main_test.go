@@ -0,0 +1,281 @@
+// Copyright (c) 2020-2024 Andrew Stormont
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"beoutil/clients/beoremote/models"
+	"beoutil/clients/deezer"
+	deezerModels "beoutil/clients/deezer/models"
+)
+
+func TestParseSeekPosition(t *testing.T) {
+	tests := []struct {
+		in      string
+		offset  int
+		whence  int
+		wantErr bool
+	}{
+		{in: "83", offset: 83, whence: io.SeekStart},
+		{in: "1:23", offset: 83, whence: io.SeekStart},
+		{in: "1:02:03", offset: 3723, whence: io.SeekStart},
+		{in: "90s", offset: 90, whence: io.SeekStart},
+		{in: "+30s", offset: 30, whence: io.SeekCurrent},
+		{in: "-1m", offset: -60, whence: io.SeekCurrent},
+		{in: "-0:10", offset: -10, whence: io.SeekCurrent},
+		{in: "0", offset: 0, whence: io.SeekStart},
+		{in: "90:00", offset: 5400, whence: io.SeekStart},
+		{in: "1:59", offset: 119, whence: io.SeekStart},
+		{in: "abc", wantErr: true},
+		{in: "1:-2", wantErr: true},
+		{in: "", wantErr: true},
+		{in: "+-5s", wantErr: true},
+		{in: "-+5s", wantErr: true},
+		{in: "--10", wantErr: true},
+		{in: "1:99", wantErr: true},
+		{in: "1:60", wantErr: true},
+		{in: "1:60:00", wantErr: true},
+		{in: "1:+5", wantErr: true},
+	}
+	for _, tt := range tests {
+		offset, whence, err := parseSeekPosition(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseSeekPosition(%q) succeeded, want error", tt.in)
+			}
+			continue
+		}
+		if err != nil || offset != tt.offset || whence != tt.whence {
+			t.Errorf("parseSeekPosition(%q) = %d, %d, %v, want %d, %d", tt.in, offset, whence, err, tt.offset, tt.whence)
+		}
+	}
+}
+
+func TestTrackFilterAllowed(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter trackFilter
+		track  deezerModels.Track
+		want   bool
+	}{
+		{"no filter", trackFilter{Explicit: true}, deezerModels.Track{AvailableCountries: []string{"DK"}}, true},
+		{"in market", trackFilter{Market: "GB", Explicit: true}, deezerModels.Track{AvailableCountries: []string{"DK", "GB"}}, true},
+		{"not in market", trackFilter{Market: "US", Explicit: true}, deezerModels.Track{AvailableCountries: []string{"DK", "GB"}}, false},
+		{"countries unknown", trackFilter{Market: "US", Explicit: true}, deezerModels.Track{}, true},
+		{"explicit allowed", trackFilter{Explicit: true}, deezerModels.Track{ExplicitLyrics: true}, true},
+		{"explicit filtered", trackFilter{}, deezerModels.Track{ExplicitLyrics: true}, false},
+		{"clean", trackFilter{}, deezerModels.Track{}, true},
+		{"explicit in market", trackFilter{Market: "GB"}, deezerModels.Track{ExplicitLyrics: true, AvailableCountries: []string{"GB"}}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.filter.allowed(tt.track); got != tt.want {
+			t.Errorf("%s: allowed() = %t, want %t", tt.name, got, tt.want)
+		}
+		tt.track.Readable = true
+		if got := tt.filter.playable(tt.track); got != tt.want {
+			t.Errorf("%s: playable() = %t, want %t", tt.name, got, tt.want)
+		}
+		tt.track.Readable = false
+		if tt.filter.playable(tt.track) {
+			t.Errorf("%s: playable() = true for an unreadable track", tt.name)
+		}
+	}
+}
+
+// testDeezerTracks are the full tracks served by newTestDeezerClient.
+var testDeezerTracks = map[string]string{
+	"1": `{"id": 1, "title": "Schism", "readable": true, "available_countries": ["DK", "GB"],
+		"contributors": [{"id": 2, "name": "Tool"}]}`,
+	"2": `{"id": 2, "title": "Schism (Clean)", "readable": true, "available_countries": ["US"],
+		"contributors": [{"id": 2, "name": "Tool"}], "alternative": {"id": 3}}`,
+	"3": `{"id": 3, "title": "Schism (US)", "readable": true, "available_countries": ["US", "DK"],
+		"contributors": [{"id": 2, "name": "Tool"}]}`,
+	"4": `{"id": 4, "title": "Forty Six & 2", "readable": false,
+		"contributors": [{"id": 2, "name": "Tool"}], "alternative": {"id": 404}}`,
+	"5": `{"id": 5, "title": "Lateralus", "readable": false,
+		"contributors": [{"id": 2, "name": "Tool"}], "alternative": {"id": 500}}`,
+	"6": `{"id": 6, "title": "Parabola", "readable": true, "explicit_lyrics": true,
+		"contributors": [{"id": 2, "name": "Tool"}], "alternative": {"id": 7}}`,
+	"7": `{"id": 7, "title": "Parabola (Clean)", "readable": true,
+		"contributors": [{"id": 2, "name": "Tool"}]}`,
+}
+
+// newTestDeezerClient returns a client for a server with testDeezerTracks.
+// Other tracks aren't found, except 500 which fails. The paths requested
+// are recorded in requests.
+func newTestDeezerClient(t *testing.T, requests *[]string) *deezer.Client {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests = append(*requests, r.URL.Path)
+		id := strings.TrimPrefix(r.URL.Path, "/track/")
+		if id == "500" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if track, ok := testDeezerTracks[id]; ok {
+			_, _ = w.Write([]byte(track))
+			return
+		}
+		_, _ = w.Write([]byte(`{"error": {"type": "DataException", "message": "no data", "code": 800}}`))
+	}))
+	t.Cleanup(srv.Close)
+	return deezer.NewClientWithOptions(&deezer.ClientOptions{BaseURL: srv.URL})
+}
+
+func TestTrackFilterResolve(t *testing.T) {
+	full := []deezerModels.Contributor{{ID: 2, Name: "Tool"}}
+	tests := []struct {
+		name     string
+		filter   trackFilter
+		track    deezerModels.Track
+		want     int // want is the ID of the track resolved, or 0 for none.
+		wantErr  bool
+		requests string
+	}{
+		{"listed track", trackFilter{Market: "GB", Explicit: true}, deezerModels.Track{ID: 1}, 1, false, "/track/1"},
+		{"full track", trackFilter{Market: "GB", Explicit: true},
+			deezerModels.Track{ID: 1, Readable: true, Contributors: full}, 1, false, ""},
+		{"not in market", trackFilter{Market: "GB", Explicit: true}, deezerModels.Track{ID: 3}, 0, false, "/track/3"},
+		{"alternative", trackFilter{Market: "DK", Explicit: true}, deezerModels.Track{ID: 2}, 3, false, "/track/2 /track/3"},
+		{"alternative not in market", trackFilter{Market: "GB", Explicit: true}, deezerModels.Track{ID: 2}, 0, false, "/track/2 /track/3"},
+		{"explicit", trackFilter{Explicit: true}, deezerModels.Track{ID: 6}, 6, false, "/track/6"},
+		{"explicit filtered", trackFilter{}, deezerModels.Track{ID: 6}, 7, false, "/track/6 /track/7"},
+		{"track not found", trackFilter{Explicit: true}, deezerModels.Track{ID: 404}, 0, false, "/track/404"},
+		{"alternative not found", trackFilter{Explicit: true}, deezerModels.Track{ID: 4}, 0, false, "/track/4 /track/404"},
+		{"track fails", trackFilter{Explicit: true}, deezerModels.Track{ID: 500}, 0, true, "/track/500"},
+		{"alternative fails", trackFilter{Explicit: true}, deezerModels.Track{ID: 5}, 0, true, "/track/5 /track/500"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests []string
+			d := newTestDeezerClient(t, &requests)
+			got, err := tt.filter.resolve(context.Background(), d, tt.track)
+			if tt.wantErr {
+				if err == nil || errors.Is(err, deezer.ErrNotFound) {
+					t.Errorf("resolve() error = %v, want a transport error", err)
+				}
+			} else if err != nil {
+				t.Fatalf("resolve() error = %v", err)
+			}
+			gotID := 0
+			if got != nil {
+				gotID = got.ID
+			}
+			if gotID != tt.want {
+				t.Errorf("resolve() = track %d, want %d", gotID, tt.want)
+			}
+			if r := strings.Join(requests, " "); r != tt.requests {
+				t.Errorf("requested %q, want %q", r, tt.requests)
+			}
+		})
+	}
+}
+
+func TestNormalizeName(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"Tool", "tool"},
+		{"A Perfect Circle", "a perfect circle"},
+		{"  Nine   Inch\tNails ", "nine inch nails"},
+		{"Sigur Rós", "sigur rós"},
+		{"ÆØÅ", "æøå"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := normalizeName(tt.in); got != tt.want {
+			t.Errorf("normalizeName(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestToQueueItem(t *testing.T) {
+	tool := &deezerModels.Artist{ID: 2, Name: "Tool", PictureBig: "artist-big", PictureMedium: "artist-medium", PictureSmall: "artist-small"}
+	lateralus := &deezerModels.Album{ID: 3, Title: "Lateralus", CoverBig: "album-big", CoverMedium: "album-medium", CoverSmall: "album-small"}
+	track := deezerModels.Track{ID: 1, Title: "Schism", TrackPosition: 6, Duration: 407, Artist: tool, Album: lateralus,
+		Contributors: []deezerModels.Contributor{{ID: 2, Name: "Tool"}}}
+	noCover := track
+	noCover.Album = &deezerModels.Album{ID: 3, Title: "Lateralus"}
+	noAlbum := track
+	noAlbum.Album = nil
+	listed := track
+	listed.Contributors = nil
+	several := track
+	several.Artist = &deezerModels.Artist{ID: 4, Name: "A  Perfect Circle"}
+	several.Contributors = []deezerModels.Contributor{{ID: 4, Name: "A  Perfect Circle"}, {ID: 5, Name: "Maynard James Keenan"}}
+	tests := []struct {
+		name    string
+		track   deezerModels.Track
+		album   string
+		images  string
+		artists string
+	}{
+		{"full track", track, "Lateralus", "album-big album-medium album-small", "2:Tool:tool"},
+		{"no album cover", noCover, "Lateralus", "artist-big artist-medium artist-small", "2:Tool:tool"},
+		{"no album", noAlbum, "", "artist-big artist-medium artist-small", "2:Tool:tool"},
+		{"no contributors", listed, "Lateralus", "album-big album-medium album-small", "2:Tool:tool"},
+		{"several contributors", several, "Lateralus", "album-big album-medium album-small",
+			"4:A  Perfect Circle:a perfect circle 5:Maynard James Keenan:maynard james keenan"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			qi := toQueueItem(tt.track)
+			tr := qi.Track
+			if qi.Behaviour != models.Planned || qi.Station != nil || tr == nil {
+				t.Fatalf("toQueueItem() = %+v, want a planned track", qi)
+			}
+			if tr.Deezer == nil || tr.Deezer.Id != 1 || tr.Id != "1" {
+				t.Errorf("IDs = %+v, %q, want 1", tr.Deezer, tr.Id)
+			}
+			if tr.Name != "Schism" || tr.TrackNumber != 6 || tr.Duration != 407 || tr.Album != tt.album {
+				t.Errorf("track = %q #%d %ds on %q, want \"Schism\" #6 407s on %q", tr.Name, tr.TrackNumber, tr.Duration, tr.Album, tt.album)
+			}
+			if tr.ArtistName != tt.track.Artist.Name || tr.ArtistNameNormalized != normalizeName(tt.track.Artist.Name) {
+				t.Errorf("artist name = %q, %q", tr.ArtistName, tr.ArtistNameNormalized)
+			}
+			var artists []string
+			for _, a := range tr.Artist {
+				if a.Id != strconv.Itoa(a.Deezer.Id) || a.Image == nil {
+					t.Errorf("artist %+v", a)
+				}
+				artists = append(artists, fmt.Sprintf("%s:%s:%s", a.Id, a.Name, a.NameNormalized))
+			}
+			if got := strings.Join(artists, " "); got != tt.artists {
+				t.Errorf("artists = %q, want %q", got, tt.artists)
+			}
+			var images []string
+			for _, i := range tr.Image {
+				images = append(images, i.URL)
+			}
+			if got := strings.Join(images, " "); got != tt.images {
+				t.Errorf("images = %q, want %q", got, tt.images)
+			}
+		})
+	}
+}
@@ -0,0 +1,214 @@
+// Copyright (c) 2020-2024 Andrew Stormont
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"beoutil/clients/beoremote"
+	"beoutil/clients/beoremote/models"
+)
+
+// subscribe calls fn for every notification from a product until ctx is
+// done or fn returns false. Products close the stream from time to time,
+// so it's reopened whenever that happens.
+func subscribe(ctx context.Context, br *beoremote.Client, fn func(n *models.Notification) bool) error {
+	for {
+		events, err := br.BeoZone.OpenNotificationStream(ctx)
+		if err != nil {
+			return err
+		}
+		for event := range events {
+			if errors.Is(event.Err, io.EOF) || errors.Is(event.Err, io.ErrUnexpectedEOF) {
+				break
+			}
+			if event.Err != nil {
+				return event.Err
+			}
+			var n models.NotificationWrapper
+			if err = json.Unmarshal(event.Value, &n); err != nil {
+				return err
+			}
+			if !fn(&n.Notification) {
+				return nil
+			}
+		}
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+	}
+}
+
+// watchedProduct is a product whose notifications are being watched.
+type watchedProduct struct {
+	Addr   string
+	Name   string
+	Client *beoremote.Client
+}
+
+// getWatchedProducts resolves products, or every cached product if
+// products is empty. Names are taken from the cache where possible.
+func getWatchedProducts(products []string) ([]*watchedProduct, error) {
+	cached, err := getCachedProducts()
+	if err != nil && len(products) == 0 {
+		return nil, err
+	}
+	names := make(map[string]string)
+	for _, p := range cached {
+		for _, ip := range p.IPs {
+			names[ip.String()] = p.Name
+		}
+		if len(products) == 0 && len(p.IPs) > 0 {
+			products = append(products, p.IPs[0].String())
+		}
+	}
+	var watched []*watchedProduct
+	for _, p := range products {
+		addr, err := resolveProduct(p)
+		if err != nil {
+			return nil, err
+		}
+		br, err := getClient(addr)
+		if err != nil {
+			return nil, err
+		}
+		name := names[addr]
+		if name == "" {
+			name = addr
+		}
+		watched = append(watched, &watchedProduct{Addr: addr, Name: name, Client: br})
+	}
+	return watched, nil
+}
+
+// watchProducts calls fn for every notification from every product until
+// ctx is done. A product that can't be reached, or drops its stream, is
+// retried with increasing delays, so products in deep standby or
+// unplugged don't stop the others being watched. fn is called
+// concurrently for different products.
+func watchProducts(ctx context.Context, products []*watchedProduct, fn func(p *watchedProduct, n *models.Notification)) {
+	var wg sync.WaitGroup
+	for _, p := range products {
+		wg.Add(1)
+		go func(p *watchedProduct) {
+			defer wg.Done()
+			delay := time.Second
+			for {
+				err := subscribe(ctx, p.Client, func(n *models.Notification) bool {
+					delay = time.Second
+					fn(p, n)
+					return true
+				})
+				if ctx.Err() != nil {
+					return
+				}
+				log.Printf("%s: %v, retrying in %s", p.Name, err, delay)
+				select {
+				case <-time.After(delay):
+				case <-ctx.Done():
+					return
+				}
+				if delay *= 2; delay > time.Minute {
+					delay = time.Minute
+				}
+			}
+		}(p)
+	}
+	wg.Wait()
+}
+
+// notificationCondition matches a field of a notification. Key is either
+// "type", "kind", or a dotted path into the notification's data, such as
+// "state" or "speaker.level".
+type notificationCondition struct {
+	Key   string
+	Value string
+}
+
+func parseNotificationConditions(args []string) ([]notificationCondition, error) {
+	var conds []notificationCondition
+	for _, a := range args {
+		s := strings.SplitN(a, "=", 2)
+		if len(s) != 2 || s[0] == "" {
+			return nil, fmt.Errorf("invalid condition %q, expected key=value", a)
+		}
+		conds = append(conds, notificationCondition{Key: s[0], Value: s[1]})
+	}
+	return conds, nil
+}
+
+// notificationField returns the value of key in a notification as a string.
+func notificationField(n *models.Notification, key string) (string, bool) {
+	switch key {
+	case "type":
+		return string(n.Type), true
+	case "kind":
+		return n.Kind, true
+	}
+	var v interface{}
+	if json.Unmarshal(n.Data, &v) != nil {
+		return "", false
+	}
+	for _, k := range strings.Split(key, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return "", false
+		}
+		if v, ok = m[k]; !ok {
+			return "", false
+		}
+	}
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case nil, map[string]interface{}, []interface{}:
+		return "", false
+	default:
+		return fmt.Sprint(v), true
+	}
+}
+
+// matchNotification reports whether n matches every condition.
+// Types are compared without regard to case.
+func matchNotification(n *models.Notification, conds []notificationCondition) bool {
+	for _, c := range conds {
+		v, ok := notificationField(n, c.Key)
+		if !ok {
+			return false
+		}
+		if c.Key == "type" {
+			if !strings.EqualFold(v, c.Value) {
+				return false
+			}
+		} else if v != c.Value {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,325 @@
+// Copyright (c) 2020-2024 Andrew Stormont
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"beoutil/clients/beoremote/models"
+
+	"github.com/urfave/cli/v2"
+)
+
+// parseTimeWindow parses a time window such as "22:00-07:00".
+func parseTimeWindow(s string) (after, before timeOfDay, err error) {
+	parts := strings.SplitN(s, "-", 2)
+	if len(parts) != 2 {
+		return after, before, fmt.Errorf("invalid time window %q, expected HH:MM-HH:MM", s)
+	}
+	for i, p := range []*timeOfDay{&after, &before} {
+		t, err := time.Parse("15:04", strings.TrimSpace(parts[i]))
+		if err != nil {
+			return after, before, fmt.Errorf("invalid time window %q, expected HH:MM-HH:MM", s)
+		}
+		*p = timeOfDay{Set: true, Minutes: t.Hour()*60 + t.Minute()}
+	}
+	return after, before, nil
+}
+
+// powerProduct is what the power policy knows about a product.
+type powerProduct struct {
+	*watchedProduct
+	on        bool
+	onSince   time.Time
+	onTotal   time.Duration
+	playing   bool
+	idleSince time.Time
+}
+
+func (pp *powerProduct) setOn(on bool, now time.Time) {
+	if on == pp.on {
+		return
+	}
+	if pp.on {
+		pp.onTotal += now.Sub(pp.onSince)
+	} else {
+		pp.onSince = now
+		pp.idleSince = now
+	}
+	pp.on = on
+	if !on {
+		pp.playing = false
+	}
+}
+
+// setPlaying records whether the product is playing. It's ignored while
+// the product is in standby, as products may send progress after going to
+// standby; only a new source turns them back on.
+func (pp *powerProduct) setPlaying(playing bool, now time.Time) {
+	if !pp.on {
+		return
+	}
+	if pp.playing && !playing {
+		pp.idleSince = now
+	}
+	pp.playing = playing
+}
+
+// onTime returns how long the product has been on in total.
+func (pp *powerProduct) onTime(now time.Time) time.Duration {
+	if pp.on {
+		return pp.onTotal + now.Sub(pp.onSince)
+	}
+	return pp.onTotal
+}
+
+type powerPolicy struct {
+	idle        time.Duration
+	quietAfter  timeOfDay
+	quietBefore timeOfDay
+	quietVolume int
+	dryRun      bool
+
+	mu       sync.Mutex
+	products map[string]*powerProduct
+	quiet    bool
+}
+
+// reconcile asks a product whether it's on, and if so whether it's
+// playing, so the policy starts from the truth after a restart, or if
+// the product was turned on or off with its own buttons.
+func (pol *powerPolicy) reconcile(ctx context.Context, pp *powerProduct) {
+	ctx, cancel := context.WithTimeout(ctx, defaultProductTimeout)
+	defer cancel()
+	state, err := pp.Client.BeoDevice.GetState(ctx)
+	if err != nil {
+		log.Printf("%s: failed to get power state: %v", pp.Name, err)
+		return
+	}
+	on := state == models.PowerStateOn
+	playing := false
+	if on {
+		if progress, err := pp.Client.BeoZone.GetProgress(ctx); err == nil {
+			playing = progress.State == models.StatePlay
+		}
+	}
+	now := time.Now()
+	pol.mu.Lock()
+	defer pol.mu.Unlock()
+	pp.setOn(on, now)
+	if on {
+		pp.setPlaying(playing, now)
+	}
+}
+
+func (pol *powerPolicy) handle(ctx context.Context, p *watchedProduct, n *models.Notification) {
+	v, err := n.Decode()
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	pol.mu.Lock()
+	defer pol.mu.Unlock()
+	pp := pol.products[p.Addr]
+	switch d := v.(type) {
+	case *models.ProgressInformationData:
+		pp.setPlaying(d.State == models.StatePlay, now)
+	case *models.SourceData:
+		// A SOURCE notification without a primary source means the
+		// product has gone to standby.
+		pp.setOn(d.Primary != "", now)
+		if d.PrimaryExperience.State != "" {
+			pp.setPlaying(d.PrimaryExperience.State == models.StatePlay, now)
+		}
+	case *models.VolumeData:
+		if pol.quiet && d.Speaker.Level > pol.quietVolume {
+			go pol.limitVolume(ctx, pp, d.Speaker.Level)
+		}
+	}
+}
+
+func (pol *powerPolicy) limitVolume(ctx context.Context, pp *powerProduct, level int) {
+	if pol.dryRun {
+		log.Printf("%s: would lower volume from %d to %d for quiet hours", pp.Name, level, pol.quietVolume)
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, defaultProductTimeout)
+	defer cancel()
+	if err := pp.Client.BeoZone.SetVolume(ctx, pol.quietVolume); err != nil {
+		log.Printf("%s: failed to lower volume: %v", pp.Name, err)
+		return
+	}
+	log.Printf("%s: lowered volume from %d to %d for quiet hours", pp.Name, level, pol.quietVolume)
+}
+
+func (pol *powerPolicy) standby(ctx context.Context, pp *powerProduct, idle time.Duration) {
+	if pol.dryRun {
+		log.Printf("%s: would go to standby after %s idle", pp.Name, idle.Round(time.Second))
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, defaultProductTimeout)
+	defer cancel()
+	if err := pp.Client.BeoDevice.Standby(ctx); err != nil {
+		log.Printf("%s: failed to go to standby: %v", pp.Name, err)
+		return
+	}
+	log.Printf("%s: standby after %s idle", pp.Name, idle.Round(time.Second))
+}
+
+// check puts idle products into standby, and lowers the volume on
+// products that are on when quiet hours start.
+func (pol *powerPolicy) check(ctx context.Context, now time.Time) {
+	pol.mu.Lock()
+	defer pol.mu.Unlock()
+	wasQuiet := pol.quiet
+	pol.quiet = pol.quietAfter.Set && inWindow(pol.quietAfter, pol.quietBefore, now)
+	if pol.quiet && !wasQuiet {
+		log.Printf("Quiet hours started, limiting volume to %d", pol.quietVolume)
+	}
+	for _, pp := range pol.products {
+		if pp.on && pol.quiet && !wasQuiet {
+			go func(pp *powerProduct) {
+				ctx, cancel := context.WithTimeout(ctx, defaultProductTimeout)
+				defer cancel()
+				if level, err := pp.Client.BeoZone.GetVolume(ctx); err == nil && level > pol.quietVolume {
+					pol.limitVolume(ctx, pp, level)
+				}
+			}(pp)
+		}
+		if pol.idle <= 0 || !pp.on || pp.playing {
+			continue
+		}
+		if idle := now.Sub(pp.idleSince); idle >= pol.idle {
+			if !pol.dryRun {
+				pp.setOn(false, now)
+			} else {
+				pp.idleSince = now
+			}
+			go pol.standby(ctx, pp, idle)
+		}
+	}
+}
+
+func (pol *powerPolicy) printReport(now time.Time) {
+	pol.mu.Lock()
+	defer pol.mu.Unlock()
+	var products []*powerProduct
+	for _, pp := range pol.products {
+		products = append(products, pp)
+	}
+	sort.Slice(products, func(i, j int) bool { return products[i].Name < products[j].Name })
+	tw := new(tabwriter.Writer)
+	tw.Init(os.Stdout, 8, 4, 1, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "PRODUCT\tSTATE\tPLAYING\tON TIME")
+	for _, pp := range products {
+		state := "standby"
+		if pp.on {
+			state = "on"
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%t\t%s\n", pp.Name, state, pp.playing, pp.onTime(now).Round(time.Second))
+	}
+	_ = tw.Flush()
+}
+
+func doPowerPolicy(c *cli.Context) error {
+	pol := &powerPolicy{
+		idle:        c.Duration("idle"),
+		quietVolume: c.Int("quiet-volume"),
+		dryRun:      c.Bool("dry-run"),
+		products:    make(map[string]*powerProduct),
+	}
+	if s := c.String("quiet-hours"); s != "" {
+		var err error
+		if pol.quietAfter, pol.quietBefore, err = parseTimeWindow(s); err != nil {
+			return err
+		}
+	}
+	if pol.idle <= 0 && !pol.quietAfter.Set {
+		return fmt.Errorf("nothing to do, use --idle and/or --quiet-hours")
+	}
+	reconcileInterval, err := intervalFlag(c, "reconcile-interval")
+	if err != nil {
+		return err
+	}
+	reportInterval, err := intervalFlag(c, "report-interval")
+	if err != nil {
+		return err
+	}
+	products, err := getWatchedProducts(c.StringSlice("product"))
+	if err != nil {
+		return err
+	}
+	for _, p := range products {
+		pol.products[p.Addr] = &powerProduct{watchedProduct: p}
+	}
+	ctx := c.Context
+	reconcileAll := func() {
+		var wg sync.WaitGroup
+		for _, pp := range pol.products {
+			wg.Add(1)
+			go func(pp *powerProduct) {
+				defer wg.Done()
+				pol.reconcile(ctx, pp)
+			}(pp)
+		}
+		wg.Wait()
+	}
+	reconcileAll()
+	pol.check(ctx, time.Now())
+	log.Printf("Enforcing power policy on %d products", len(products))
+	interval := 30 * time.Second
+	if pol.idle > 0 && pol.idle/4 < interval {
+		interval = pol.idle / 4
+	}
+	go func() {
+		tick := time.NewTicker(interval)
+		defer tick.Stop()
+		reconcileTick := time.NewTicker(reconcileInterval)
+		defer reconcileTick.Stop()
+		reportTick := time.NewTicker(reportInterval)
+		defer reportTick.Stop()
+		for {
+			select {
+			case now := <-tick.C:
+				pol.check(ctx, now)
+			case <-reconcileTick.C:
+				reconcileAll()
+			case now := <-reportTick.C:
+				pol.printReport(now)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	watchProducts(ctx, products, func(p *watchedProduct, n *models.Notification) {
+		pol.handle(ctx, p, n)
+	})
+	pol.printReport(time.Now())
+	return nil
+}
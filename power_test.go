@@ -0,0 +1,254 @@
+// Copyright (c) 2020-2024 Andrew Stormont
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"beoutil/clients/beoremote"
+	"beoutil/clients/beoremote/models"
+)
+
+// fakePowerDevice reports each call to Standby on calls.
+type fakePowerDevice struct {
+	beoremote.BeoDevice
+	name  string
+	calls chan string
+}
+
+func (d *fakePowerDevice) Standby(ctx context.Context) error {
+	d.calls <- d.name + " standby"
+	return nil
+}
+
+// fakeVolumeZone has a fixed volume, and reports each call to SetVolume
+// on calls.
+type fakeVolumeZone struct {
+	beoremote.BeoZone
+	name  string
+	level int
+	calls chan string
+}
+
+func (z *fakeVolumeZone) GetVolume(ctx context.Context) (int, error) {
+	return z.level, nil
+}
+
+func (z *fakeVolumeZone) SetVolume(ctx context.Context, level int) error {
+	z.calls <- fmt.Sprintf("%s volume %d", z.name, level)
+	return nil
+}
+
+// newTestPowerPolicy returns a policy managing a product for each volume
+// level given, named "p0", "p1" and so on, and the channel their calls
+// are reported on. Calls are made from goroutines started by the policy.
+func newTestPowerPolicy(t *testing.T, levels ...int) (*powerPolicy, chan string) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	calls := make(chan string, 10)
+	pol := &powerPolicy{products: make(map[string]*powerProduct)}
+	for i, level := range levels {
+		name := fmt.Sprintf("p%d", i)
+		br := &beoremote.Client{
+			BeoDevice: &fakePowerDevice{name: name, calls: calls},
+			BeoZone:   &fakeVolumeZone{name: name, level: level, calls: calls},
+		}
+		pol.products[name] = &powerProduct{watchedProduct: &watchedProduct{Addr: name, Name: name, Client: br}}
+	}
+	return pol, calls
+}
+
+func expectCall(t *testing.T, calls <-chan string, want string) {
+	t.Helper()
+	select {
+	case got := <-calls:
+		if got != want {
+			t.Errorf("got call %q, want %q", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no call, want %q", want)
+	}
+}
+
+// expectNoCall fails if a call is made within a short while.
+func expectNoCall(t *testing.T, calls <-chan string) {
+	t.Helper()
+	select {
+	case got := <-calls:
+		t.Errorf("unexpected call %q", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestPowerProductOnTime(t *testing.T) {
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	at := func(m int) time.Time { return start.Add(time.Duration(m) * time.Minute) }
+	var pp powerProduct
+	steps := []struct {
+		at     int
+		on     bool
+		onTime time.Duration
+	}{
+		{0, true, 0},
+		{10, true, 10 * time.Minute},
+		{30, false, 30 * time.Minute},
+		{45, false, 30 * time.Minute},
+		{60, true, 30 * time.Minute},
+		{75, true, 45 * time.Minute},
+	}
+	for _, s := range steps {
+		pp.setOn(s.on, at(s.at))
+		if pp.on != s.on {
+			t.Errorf("%dm: on = %t, want %t", s.at, pp.on, s.on)
+		}
+		if got := pp.onTime(at(s.at)); got != s.onTime {
+			t.Errorf("%dm: onTime() = %s, want %s", s.at, got, s.onTime)
+		}
+	}
+	if !pp.onSince.Equal(at(60)) || !pp.idleSince.Equal(at(60)) {
+		t.Errorf("onSince, idleSince = %s, %s, want both %s", pp.onSince, pp.idleSince, at(60))
+	}
+	if got := pp.onTime(at(90)); got != time.Hour {
+		t.Errorf("onTime() while on = %s, want 1h", got)
+	}
+}
+
+func TestPowerProductSetPlaying(t *testing.T) {
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	at := func(m int) time.Time { return start.Add(time.Duration(m) * time.Minute) }
+	var pp powerProduct
+	pp.setPlaying(true, at(0))
+	if pp.on || pp.playing {
+		t.Fatalf("setPlaying in standby: on = %t, playing = %t, want both false", pp.on, pp.playing)
+	}
+	pp.setOn(true, at(1))
+	pp.setPlaying(true, at(2))
+	if !pp.playing || !pp.idleSince.Equal(at(1)) {
+		t.Errorf("playing = %t, idleSince = %s, want true, %s", pp.playing, pp.idleSince, at(1))
+	}
+	pp.setPlaying(false, at(20))
+	if pp.playing || !pp.idleSince.Equal(at(20)) {
+		t.Errorf("playing = %t, idleSince = %s, want false, %s", pp.playing, pp.idleSince, at(20))
+	}
+	pp.setPlaying(false, at(25))
+	if !pp.idleSince.Equal(at(20)) {
+		t.Errorf("idleSince = %s after staying stopped, want %s", pp.idleSince, at(20))
+	}
+	pp.setPlaying(true, at(30))
+	pp.setOn(false, at(40))
+	if pp.playing {
+		t.Error("still playing after standby")
+	}
+}
+
+func TestPowerPolicyHandle(t *testing.T) {
+	pol, _ := newTestPowerPolicy(t, 0)
+	pp := pol.products["p0"]
+	steps := []struct {
+		name        string
+		n           *models.Notification
+		on, playing bool
+	}{
+		{"stale progress in standby", progressNotification(models.StatePlay), false, false},
+		{"source", sourceNotification("DEEZER"), true, true},
+		{"pause", progressNotification(models.StatePause), true, false},
+		{"play", progressNotification(models.StatePlay), true, true},
+		{"standby", sourceNotification(""), false, false},
+		{"stale progress after standby", progressNotification(models.StatePlay), false, false},
+	}
+	for _, s := range steps {
+		pol.handle(context.Background(), pp.watchedProduct, s.n)
+		if pp.on != s.on || pp.playing != s.playing {
+			t.Errorf("%s: on = %t, playing = %t, want %t, %t", s.name, pp.on, pp.playing, s.on, s.playing)
+		}
+	}
+}
+
+func TestPowerPolicyCheckIdle(t *testing.T) {
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	for _, dryRun := range []bool{false, true} {
+		pol, calls := newTestPowerPolicy(t, 0, 0, 0)
+		pol.idle = 30 * time.Minute
+		pol.dryRun = dryRun
+		idle, playing, off := pol.products["p0"], pol.products["p1"], pol.products["p2"]
+		idle.setOn(true, start)
+		playing.setOn(true, start)
+		playing.setPlaying(true, start)
+		pol.check(context.Background(), start.Add(pol.idle-time.Second))
+		expectNoCall(t, calls)
+		now := start.Add(pol.idle)
+		pol.check(context.Background(), now)
+		if !dryRun {
+			expectCall(t, calls, "p0 standby")
+			if idle.on {
+				t.Error("idle product still on after standby")
+			}
+		} else if !idle.on || !idle.idleSince.Equal(now) {
+			t.Errorf("dry run: on = %t, idleSince = %s, want true, %s", idle.on, idle.idleSince, now)
+		}
+		expectNoCall(t, calls)
+		if !playing.on || off.on {
+			t.Errorf("playing.on = %t, off.on = %t, want true, false", playing.on, off.on)
+		}
+		// A dry run starts counting again, rather than logging every check.
+		pol.check(context.Background(), now.Add(time.Minute))
+		expectNoCall(t, calls)
+	}
+}
+
+func TestPowerPolicyCheckQuietHours(t *testing.T) {
+	pol, calls := newTestPowerPolicy(t, 40, 10, 40)
+	pol.quietAfter, pol.quietBefore = testTimeOfDay("22:00"), testTimeOfDay("07:00")
+	pol.quietVolume = 20
+	start := testTime("12:00")
+	pol.products["p0"].setOn(true, start)
+	pol.products["p1"].setOn(true, start)
+	pol.check(context.Background(), testTime("21:59"))
+	if pol.quiet {
+		t.Error("quiet before 22:00")
+	}
+	expectNoCall(t, calls)
+	pol.check(context.Background(), testTime("22:00"))
+	if !pol.quiet {
+		t.Error("not quiet at 22:00")
+	}
+	// Only p0 is on and too loud.
+	expectCall(t, calls, "p0 volume 20")
+	expectNoCall(t, calls)
+	pol.check(context.Background(), testTime("22:01"))
+	expectNoCall(t, calls)
+	// Turning it up during quiet hours is undone.
+	pol.handle(context.Background(), pol.products["p1"].watchedProduct,
+		testNotification(models.NotificationTypeVolume, `{"speaker": {"level": 35}}`))
+	expectCall(t, calls, "p1 volume 20")
+	pol.check(context.Background(), testTime("07:00"))
+	if pol.quiet {
+		t.Error("still quiet at 07:00")
+	}
+	pol.handle(context.Background(), pol.products["p1"].watchedProduct,
+		testNotification(models.NotificationTypeVolume, `{"speaker": {"level": 35}}`))
+	expectNoCall(t, calls)
+}
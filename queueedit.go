@@ -0,0 +1,299 @@
+// Copyright (c) 2020-2024 Andrew Stormont
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"beoutil/clients/beoremote"
+	"beoutil/clients/beoremote/models"
+
+	"github.com/urfave/cli/v2"
+)
+
+// queueOp is a single edit applied to a play queue. If Remove is set
+// the item is removed, otherwise it is moved before Before.
+type queueOp struct {
+	Item   models.PlayQueueItem
+	Before models.PlayQueueItem
+	Remove bool
+}
+
+func plid(id models.PlayQueueItemID) string {
+	return strings.TrimPrefix(string(id), "plid-")
+}
+
+func queueItemName(qi models.PlayQueueItem) string {
+	switch {
+	case qi.Track != nil:
+		return qi.Track.Name
+	case qi.Station != nil:
+		return qi.Station.Name
+	}
+	return "-"
+}
+
+func queueItemArtist(qi models.PlayQueueItem) string {
+	if qi.Track == nil {
+		return ""
+	}
+	if qi.Track.ArtistName != "" {
+		return qi.Track.ArtistName
+	}
+	if len(qi.Track.Artist) > 0 {
+		return qi.Track.Artist[0].Name
+	}
+	return ""
+}
+
+// planQueueEdit returns the remove and move operations needed to turn
+// current into target, which must be made up of items from current.
+// Items in the longest run that's already in the right order stay where
+// they are, so the number of moves is as small as possible.
+func planQueueEdit(current, target []models.PlayQueueItem) []queueOp {
+	var ops []queueOp
+	wanted := make(map[models.PlayQueueItemID]bool, len(target))
+	for _, qi := range target {
+		wanted[qi.Id] = true
+	}
+	pos := make(map[models.PlayQueueItemID]int, len(target))
+	for _, qi := range current {
+		if !wanted[qi.Id] {
+			ops = append(ops, queueOp{Item: qi, Remove: true})
+			continue
+		}
+		pos[qi.Id] = len(pos)
+	}
+	n := len(target)
+	if n == 0 {
+		return ops
+	}
+	// Find the longest increasing subsequence of current positions that
+	// ends with the last target item. The queue API can only move items
+	// before another item, so the last item must never need to move.
+	length := make([]int, n)
+	prev := make([]int, n)
+	for i := 0; i < n; i++ {
+		length[i], prev[i] = 1, -1
+		for j := 0; j < i; j++ {
+			if pos[target[j].Id] < pos[target[i].Id] && length[j]+1 > length[i] {
+				length[i], prev[i] = length[j]+1, j
+			}
+		}
+	}
+	keep := make([]bool, n)
+	for i := n - 1; i >= 0; i = prev[i] {
+		keep[i] = true
+	}
+	for i := n - 2; i >= 0; i-- {
+		if !keep[i] {
+			ops = append(ops, queueOp{Item: target[i], Before: target[i+1]})
+		}
+	}
+	return ops
+}
+
+func applyQueueOps(ctx context.Context, z beoremote.BeoZone, ops []queueOp) error {
+	for _, op := range ops {
+		var err error
+		if op.Remove {
+			err = z.RemoveQueueItem(ctx, plid(op.Item.Id))
+		} else {
+			err = z.MoveQueueItem(ctx, plid(op.Item.Id), plid(op.Before.Id))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func printQueueOps(ops []queueOp, target []models.PlayQueueItem, playNow models.PlayQueueItemID) {
+	tw := new(tabwriter.Writer)
+	tw.Init(os.Stdout, 8, 4, 1, ' ', 0)
+	for _, op := range ops {
+		if op.Remove {
+			_, _ = fmt.Fprintf(tw, "-\t%s\t%s\t%s\n",
+				plid(op.Item.Id), queueItemName(op.Item), queueItemArtist(op.Item))
+		} else {
+			_, _ = fmt.Fprintf(tw, "~\t%s\t%s\t%s\tbefore %s\n",
+				plid(op.Item.Id), queueItemName(op.Item), queueItemArtist(op.Item), plid(op.Before.Id))
+		}
+	}
+	_ = tw.Flush()
+	fmt.Println()
+	tw.Init(os.Stdout, 8, 4, 1, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "PTR\tPLID\tTRACK\tARTIST")
+	for _, qi := range target {
+		marker := ""
+		if qi.Id == playNow {
+			marker = "------>"
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n",
+			marker, plid(qi.Id), queueItemName(qi), queueItemArtist(qi))
+	}
+	_ = tw.Flush()
+}
+
+// playNowIndex returns the index of the item being played, or 0 if the
+// product didn't tell us.
+func playNowIndex(q *models.PlayQueue) int {
+	for i, qi := range q.PlayQueueItem {
+		if qi.Id == q.PlayNowId {
+			return i
+		}
+	}
+	return 0
+}
+
+type queueEditFunc func(c *cli.Context, q *models.PlayQueue) ([]models.PlayQueueItem, error)
+
+func queueEditAction(edit queueEditFunc) cli.ActionFunc {
+	return func(c *cli.Context) error {
+		args := productArgs(c, 1)
+		br, err := getClient(args.First())
+		if err != nil {
+			return err
+		}
+		q, err := beoremote.GetFullPlayQueue(c.Context, br.BeoZone)
+		if err != nil {
+			return err
+		}
+		if len(q.PlayQueueItem) == 0 {
+			_, _ = fmt.Printf("Queue empty.\n")
+			return nil
+		}
+		if q.PlayNowId == "" {
+			q.PlayNowId = q.PlayQueueItem[0].Id
+		}
+		target, err := edit(c, q)
+		if err != nil {
+			return err
+		}
+		ops := planQueueEdit(q.PlayQueueItem, target)
+		if c.Bool("dry-run") {
+			printQueueOps(ops, target, q.PlayNowId)
+			return nil
+		}
+		if err = applyQueueOps(c.Context, br.BeoZone, ops); err != nil {
+			return err
+		}
+		_, _ = fmt.Fprintf(os.Stderr, "Applied %d changes.\n", len(ops))
+		return nil
+	}
+}
+
+func editDedupe(_ *cli.Context, q *models.PlayQueue) ([]models.PlayQueueItem, error) {
+	// Keep the first copy of each track, unless a later copy is
+	// being played, in which case keep that one instead.
+	keep := make(map[int]models.PlayQueueItemID)
+	for _, qi := range q.PlayQueueItem {
+		if qi.Track == nil || qi.Track.Deezer == nil {
+			continue
+		}
+		if _, ok := keep[qi.Track.Deezer.Id]; !ok || qi.Id == q.PlayNowId {
+			keep[qi.Track.Deezer.Id] = qi.Id
+		}
+	}
+	var target []models.PlayQueueItem
+	for _, qi := range q.PlayQueueItem {
+		if qi.Track != nil && qi.Track.Deezer != nil && keep[qi.Track.Deezer.Id] != qi.Id {
+			continue
+		}
+		target = append(target, qi)
+	}
+	return target, nil
+}
+
+func editSort(c *cli.Context, q *models.PlayQueue) ([]models.PlayQueueItem, error) {
+	keys := strings.Split(c.String("by"), ",")
+	for _, k := range keys {
+		switch k {
+		case "artist", "album", "title":
+		default:
+			return nil, fmt.Errorf("unknown sort key: %q", k)
+		}
+	}
+	field := func(qi models.PlayQueueItem, key string) string {
+		switch key {
+		case "artist":
+			return strings.ToLower(queueItemArtist(qi))
+		case "album":
+			if qi.Track != nil {
+				return strings.ToLower(qi.Track.Album)
+			}
+			return ""
+		}
+		return strings.ToLower(queueItemName(qi))
+	}
+	ptr := playNowIndex(q)
+	target := append([]models.PlayQueueItem(nil), q.PlayQueueItem...)
+	upcoming := target[ptr+1:]
+	sort.SliceStable(upcoming, func(i, j int) bool {
+		for _, k := range keys {
+			a, b := field(upcoming[i], k), field(upcoming[j], k)
+			if a != b {
+				return a < b
+			}
+		}
+		return false
+	})
+	return target, nil
+}
+
+func editTrim(c *cli.Context, q *models.PlayQueue) ([]models.PlayQueueItem, error) {
+	keep := c.Int("keep")
+	if keep < 0 {
+		return nil, errors.New("--keep must not be negative")
+	}
+	end := playNowIndex(q) + 1 + keep
+	if end > len(q.PlayQueueItem) {
+		end = len(q.PlayQueueItem)
+	}
+	return q.PlayQueueItem[:end], nil
+}
+
+func editRemovePlayed(_ *cli.Context, q *models.PlayQueue) ([]models.PlayQueueItem, error) {
+	return q.PlayQueueItem[playNowIndex(q):], nil
+}
+
+func editShuffle(c *cli.Context, q *models.PlayQueue) ([]models.PlayQueueItem, error) {
+	seed := c.Int64("seed")
+	if !c.IsSet("seed") {
+		seed = time.Now().UnixNano()
+		_, _ = fmt.Fprintf(os.Stderr, "Using seed %d.\n", seed)
+	}
+	ptr := playNowIndex(q)
+	target := append([]models.PlayQueueItem(nil), q.PlayQueueItem...)
+	upcoming := target[ptr+1:]
+	rand.New(rand.NewSource(seed)).Shuffle(len(upcoming), func(i, j int) {
+		upcoming[i], upcoming[j] = upcoming[j], upcoming[i]
+	})
+	return target, nil
+}
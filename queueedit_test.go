@@ -0,0 +1,106 @@
+// Copyright (c) 2020-2024 Andrew Stormont
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"beoutil/clients/beoremote/models"
+)
+
+func testQueue(ids string) []models.PlayQueueItem {
+	var q []models.PlayQueueItem
+	for _, id := range strings.Fields(ids) {
+		q = append(q, models.PlayQueueItem{Id: models.PlayQueueItemID("plid-" + id)})
+	}
+	return q
+}
+
+func queueIDs(q []models.PlayQueueItem) string {
+	var ids []string
+	for _, qi := range q {
+		ids = append(ids, plid(qi.Id))
+	}
+	return strings.Join(ids, " ")
+}
+
+// applyTestOps applies ops to q the way a product would.
+func applyTestOps(q []models.PlayQueueItem, ops []queueOp) []models.PlayQueueItem {
+	for _, op := range ops {
+		i := 0
+		for q[i].Id != op.Item.Id {
+			i++
+		}
+		q = append(q[:i:i], q[i+1:]...)
+		if op.Remove {
+			continue
+		}
+		j := 0
+		for q[j].Id != op.Before.Id {
+			j++
+		}
+		q = append(q[:j:j], append([]models.PlayQueueItem{op.Item}, q[j:]...)...)
+	}
+	return q
+}
+
+func TestPlanQueueEdit(t *testing.T) {
+	tests := []struct {
+		name    string
+		current string
+		target  string
+		removes int
+		moves   int
+	}{
+		{"unchanged", "1 2 3 4", "1 2 3 4", 0, 0},
+		{"empty target", "1 2 3", "", 3, 0},
+		{"remove", "1 2 3 4", "1 3", 2, 0},
+		{"reverse", "1 2 3 4", "4 3 2 1", 0, 3},
+		{"move one to front", "1 2 3 4 5", "5 1 2 3 4", 0, 1},
+		// The last item can never move, so everything else moves before it.
+		{"move one to end", "1 2 3 4 5", "2 3 4 5 1", 0, 4},
+		{"swap", "1 2 3 4", "1 3 2 4", 0, 1},
+		{"remove and move", "1 2 3 4 5", "3 1 5", 2, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			current, target := testQueue(tt.current), testQueue(tt.target)
+			ops := planQueueEdit(current, target)
+			removes, moves := 0, 0
+			for _, op := range ops {
+				if op.Remove {
+					removes++
+				} else {
+					moves++
+				}
+			}
+			if removes != tt.removes || moves != tt.moves {
+				t.Errorf("got %d removes and %d moves, want %d and %d", removes, moves, tt.removes, tt.moves)
+			}
+			got := applyTestOps(append([]models.PlayQueueItem(nil), current...), ops)
+			if !reflect.DeepEqual(queueIDs(got), queueIDs(target)) {
+				t.Errorf("queue is %q, want %q", queueIDs(got), queueIDs(target))
+			}
+		})
+	}
+}
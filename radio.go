@@ -0,0 +1,249 @@
+// Copyright (c) 2020-2024 Andrew Stormont
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"beoutil/clients/beoremote"
+	"beoutil/clients/beoremote/models"
+
+	"github.com/urfave/cli/v2"
+)
+
+func printStations(stations []models.Station) {
+	if len(stations) == 0 {
+		_, _ = fmt.Fprintln(os.Stderr, "No stations found.")
+		return
+	}
+	tw := new(tabwriter.Writer)
+	tw.Init(os.Stdout, 8, 4, 1, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "ID\tNAME")
+	for _, s := range stations {
+		_, _ = fmt.Fprintf(tw, "%s\t%s\n", s.Id, s.Name)
+	}
+	_ = tw.Flush()
+}
+
+func doSearchStations(c *cli.Context) error {
+	args := productArgs(c, 2)
+	br, err := getClient(args.First())
+	if err != nil {
+		return err
+	}
+	l, err := br.BeoContent.SearchStations(c.Context, args.Get(1), c.Int("offset"), c.Int("limit"))
+	if err != nil {
+		return err
+	}
+	printStations(l.Station)
+	return nil
+}
+
+func doListStations(c *cli.Context) error {
+	args := productArgs(c, 1)
+	br, err := getClient(args.First())
+	if err != nil {
+		return err
+	}
+	l, err := br.BeoContent.GetStations(c.Context, c.Int("offset"), c.Int("limit"))
+	if err != nil {
+		return err
+	}
+	printStations(l.Station)
+	return nil
+}
+
+func getFavouritesPath() (string, error) {
+	return getStatePath("stations.json", ".beoutil-stations")
+}
+
+func loadFavouriteStations() ([]models.Station, error) {
+	path, err := getFavouritesPath()
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var stations []models.Station
+	if err = json.Unmarshal(b, &stations); err != nil {
+		return nil, err
+	}
+	return stations, nil
+}
+
+func saveFavouriteStations(stations []models.Station) error {
+	path, err := getFavouritesPath()
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(stations)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0644)
+}
+
+// findFavouriteStation looks up a favourite by ID or by name, ignoring case.
+func findFavouriteStation(stations []models.Station, s string) (int, bool) {
+	for i, f := range stations {
+		if f.Id == s || strings.EqualFold(f.Name, s) {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+func doListFavouriteStations(c *cli.Context) error {
+	if c.NArg() != 0 {
+		cli.ShowSubcommandHelpAndExit(c, 1)
+	}
+	stations, err := loadFavouriteStations()
+	if err != nil {
+		return err
+	}
+	printStations(stations)
+	return nil
+}
+
+func doAddFavouriteStation(c *cli.Context) error {
+	args := productArgs(c, 2)
+	stations, err := loadFavouriteStations()
+	if err != nil {
+		return err
+	}
+	if _, ok := findFavouriteStation(stations, args.Get(1)); ok {
+		return nil
+	}
+	br, err := getClient(args.First())
+	if err != nil {
+		return err
+	}
+	s, err := br.BeoContent.GetStation(c.Context, args.Get(1))
+	if err != nil {
+		return err
+	}
+	return saveFavouriteStations(append(stations, *s))
+}
+
+func doRemoveFavouriteStation(c *cli.Context) error {
+	args := c.Args()
+	if args.Len() != 1 {
+		cli.ShowSubcommandHelpAndExit(c, 1)
+	}
+	stations, err := loadFavouriteStations()
+	if err != nil {
+		return err
+	}
+	i, ok := findFavouriteStation(stations, args.First())
+	if !ok {
+		return fmt.Errorf("no favourite station %q", args.First())
+	}
+	return saveFavouriteStations(append(stations[:i], stations[i+1:]...))
+}
+
+func doQueueStation(c *cli.Context) error {
+	args := productArgs(c, 2)
+	pos := queuePosition(c)
+	br, err := getClient(args.First())
+	if err != nil {
+		return err
+	}
+	stations, err := loadFavouriteStations()
+	if err != nil {
+		return err
+	}
+	var s *models.Station
+	if i, ok := findFavouriteStation(stations, args.Get(1)); ok {
+		s = &stations[i]
+	} else if s, err = br.BeoContent.GetStation(c.Context, args.Get(1)); err != nil {
+		return err
+	}
+	if s.BeoRadio.StationId == "" {
+		s.BeoRadio.StationId = s.Id
+	}
+	if pos.When == beoremote.Now {
+		// We clear the queue to match what the B&O app does.
+		if err = br.BeoZone.ClearPlayQueue(c.Context); err != nil {
+			return err
+		}
+	}
+	qi := models.PlayQueueItem{
+		Behaviour: models.Planned,
+		Station:   s,
+	}
+	return br.BeoZone.AddQueueItem(c.Context, qi, pos)
+}
+
+// getNowPlaying returns the now playing notification for the product, which
+// will be either NOW_PLAYING_STORED_MUSIC or NOW_PLAYING_NET_RADIO.
+func getNowPlaying(ctx context.Context, z beoremote.BeoZone) (*models.Notification, error) {
+	return z.WaitForNotification(ctx,
+		models.NotificationTypeNowPlayingStoredMusic,
+		models.NotificationTypeNowPlayingNetRadio)
+}
+
+func doNowPlaying(c *cli.Context) error {
+	args := productArgs(c, 1)
+	br, err := getClient(args.First())
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(c.Context, c.Duration("timeout"))
+	defer cancel()
+	n, err := getNowPlaying(ctx, br.BeoZone)
+	if errors.Is(err, context.DeadlineExceeded) {
+		_, _ = fmt.Fprintln(os.Stderr, "Nothing playing.")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	switch n.Type {
+	case models.NotificationTypeNowPlayingNetRadio:
+		var d models.NowPlayingNetRadioData
+		if err = json.Unmarshal(n.Data, &d); err != nil {
+			return err
+		}
+		fmt.Printf("Station:\t%s\n", d.Name)
+		fmt.Printf("Station ID:\t%s\n", d.StationID)
+		fmt.Printf("Description:\t%s\n", d.LiveDescription)
+	default:
+		var d models.NowPlayingStoredMusicData
+		if err = json.Unmarshal(n.Data, &d); err != nil {
+			return err
+		}
+		fmt.Printf("Track:\t%s\n", d.Name)
+		fmt.Printf("Artist:\t%s\n", d.Artist)
+		fmt.Printf("Album:\t%s\n", d.Album)
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+// Copyright (c) 2020-2024 Andrew Stormont
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"beoutil/clients/beoremote/models"
+)
+
+// setTestHome points the config and home directories at a temporary
+// directory so that state files don't touch the real ones.
+func setTestHome(t *testing.T) string {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, ".config"))
+	return home
+}
+
+func TestFavouriteStations(t *testing.T) {
+	setTestHome(t)
+	stations, err := loadFavouriteStations()
+	if err != nil || stations != nil {
+		t.Fatalf("loadFavouriteStations() = %v, %v, want nil, nil", stations, err)
+	}
+	want := []models.Station{
+		{Id: "s24861", Name: "BBC Radio 4", BeoRadio: models.BeoRadio{StationId: "s24861"}},
+		{Id: "s17077", Name: "Radio Paradise", Image: []models.Image{{URL: "http://example.com/rp.png"}}},
+	}
+	if err = saveFavouriteStations(want); err != nil {
+		t.Fatal(err)
+	}
+	got, err := loadFavouriteStations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("loadFavouriteStations() = %+v, want %+v", got, want)
+	}
+}
+
+func TestFavouriteStationsMigrate(t *testing.T) {
+	home := setTestHome(t)
+	legacy := filepath.Join(home, ".beoutil-stations")
+	if err := os.WriteFile(legacy, []byte(`[{"id":"s1","name":"Old"}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := loadFavouriteStations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Id != "s1" {
+		t.Errorf("loadFavouriteStations() = %+v, want station s1", got)
+	}
+	if _, err = os.Stat(legacy); !os.IsNotExist(err) {
+		t.Errorf("legacy file still exists: %v", err)
+	}
+}
+
+func TestFavouriteStationsInvalid(t *testing.T) {
+	setTestHome(t)
+	path, err := getFavouritesPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(path, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = loadFavouriteStations(); err == nil {
+		t.Error("loadFavouriteStations() succeeded on invalid JSON")
+	}
+}
+
+func TestFindFavouriteStation(t *testing.T) {
+	stations := []models.Station{
+		{Id: "s24861", Name: "BBC Radio 4"},
+		{Id: "s17077", Name: "Radio Paradise"},
+	}
+	tests := []struct {
+		s     string
+		index int
+		ok    bool
+	}{
+		{"s24861", 0, true},
+		{"s17077", 1, true},
+		{"Radio Paradise", 1, true},
+		{"bbc radio 4", 0, true},
+		{"S24861", -1, false},
+		{"Radio", -1, false},
+		{"", -1, false},
+	}
+	for _, tt := range tests {
+		i, ok := findFavouriteStation(stations, tt.s)
+		if i != tt.index || ok != tt.ok {
+			t.Errorf("findFavouriteStation(%q) = %d, %v, want %d, %v", tt.s, i, ok, tt.index, tt.ok)
+		}
+	}
+}
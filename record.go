@@ -0,0 +1,264 @@
+// Copyright (c) 2020-2024 Andrew Stormont
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"beoutil/clients/beoremote/models"
+
+	"github.com/urfave/cli/v2"
+)
+
+// recordedNotification is a line of a recording made by watch --record.
+type recordedNotification struct {
+	Received time.Time       `json:"received"`
+	Product  string          `json:"product"`
+	Addr     string          `json:"addr"`
+	Raw      json.RawMessage `json:"raw"` // Raw is the notification as the product sent it, quoted if it isn't JSON.
+}
+
+// notificationRecorder appends notifications to a recording.
+type notificationRecorder struct {
+	f       *os.File
+	product *watchedProduct
+}
+
+func newNotificationRecorder(path string, p *watchedProduct) (*notificationRecorder, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &notificationRecorder{f: f, product: p}, nil
+}
+
+func (r *notificationRecorder) Record(raw []byte, received time.Time) error {
+	if !json.Valid(raw) {
+		s, err := json.Marshal(string(raw))
+		if err != nil {
+			return err
+		}
+		raw = s
+	}
+	b, err := json.Marshal(recordedNotification{
+		Received: received,
+		Product:  r.product.Name,
+		Addr:     r.product.Addr,
+		Raw:      raw,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = r.f.Write(append(b, '\n'))
+	return err
+}
+
+func (r *notificationRecorder) Close() error {
+	return r.f.Close()
+}
+
+// notificationMetrics counts notifications and plays per product.
+type notificationMetrics struct {
+	products map[string]*productMetrics
+}
+
+type productMetrics struct {
+	Name     string
+	Types    map[models.NotificationType]int
+	Errors   int
+	Plays    int
+	Listened int
+	tracker  *playTracker
+}
+
+func newNotificationMetrics() *notificationMetrics {
+	return &notificationMetrics{products: make(map[string]*productMetrics)}
+}
+
+func (m *notificationMetrics) handle(p *watchedProduct, n *models.Notification, now time.Time) {
+	pm, ok := m.products[p.Addr]
+	if !ok {
+		pm = &productMetrics{
+			Name:    p.Name,
+			Types:   make(map[models.NotificationType]int),
+			tracker: newPlayTracker(p),
+		}
+		pm.tracker.OnEnd = func(play *historyPlay) {
+			pm.Plays++
+			pm.Listened += play.Listened
+		}
+		m.products[p.Addr] = pm
+	}
+	pm.Types[n.Type]++
+	if _, err := n.Decode(); err != nil {
+		pm.Errors++
+	}
+	pm.tracker.Handle(n, now)
+}
+
+// print ends any plays in progress at now and prints the metrics.
+func (m *notificationMetrics) print(now time.Time) {
+	var products []*productMetrics
+	for _, pm := range m.products {
+		pm.tracker.end(now)
+		products = append(products, pm)
+	}
+	sort.Slice(products, func(i, j int) bool {
+		return products[i].Name < products[j].Name
+	})
+	tw := new(tabwriter.Writer)
+	tw.Init(os.Stdout, 8, 4, 1, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "PRODUCT\tTYPE\tCOUNT")
+	for _, pm := range products {
+		var types []string
+		for t := range pm.Types {
+			types = append(types, string(t))
+		}
+		sort.Strings(types)
+		for _, t := range types {
+			_, _ = fmt.Fprintf(tw, "%s\t%s\t%d\n", pm.Name, t, pm.Types[models.NotificationType(t)])
+		}
+	}
+	_ = tw.Flush()
+	fmt.Println()
+	tw.Init(os.Stdout, 8, 4, 1, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "PRODUCT\tDECODE ERRORS\tPLAYS\tLISTENED")
+	for _, pm := range products {
+		_, _ = fmt.Fprintf(tw, "%s\t%d\t%d\t%s\n", pm.Name, pm.Errors, pm.Plays, formatListened(pm.Listened))
+	}
+	_ = tw.Flush()
+}
+
+// replaySleep waits for d, or until ctx is done.
+func replaySleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// doReplay feeds a recording through the same decoding and consumers as
+// live notifications. Automation rules are always run as a dry run, as
+// there may be no products to run them against.
+func doReplay(c *cli.Context) error {
+	if c.NArg() != 1 {
+		cli.ShowSubcommandHelpAndExit(c, 1)
+	}
+	speed := c.Float64("speed")
+	if speed < 0 {
+		return errors.New("--speed can't be negative")
+	}
+	f, err := os.Open(c.Args().First())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var au *automation
+	if c.IsSet("automate") {
+		path := c.String("automate")
+		rules, err := loadAutomationRules(path)
+		if err != nil {
+			return err
+		}
+		log.Printf("Loaded %d rules from %s", len(rules), path)
+		au = newAutomation(rules, true)
+	}
+	var metrics *notificationMetrics
+	if c.Bool("metrics") {
+		metrics = newNotificationMetrics()
+	}
+	only := make(map[string]bool)
+	for _, p := range c.StringSlice("product") {
+		addr, err := resolveProduct(p)
+		if err != nil {
+			return err
+		}
+		only[addr] = true
+	}
+	products := make(map[string]*watchedProduct)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	var last time.Time
+	line := 0
+	for scanner.Scan() {
+		line++
+		var rec recordedNotification
+		if err = json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		if len(only) > 0 && !only[rec.Addr] {
+			continue
+		}
+		if speed > 0 && !last.IsZero() {
+			if replaySleep(c.Context, time.Duration(float64(rec.Received.Sub(last))/speed)) != nil {
+				break
+			}
+		}
+		last = rec.Received
+		p, ok := products[rec.Addr]
+		if !ok {
+			p = &watchedProduct{Addr: rec.Addr, Name: rec.Product}
+			products[rec.Addr] = p
+		}
+		var n models.NotificationWrapper
+		if err = json.Unmarshal(rec.Raw, &n); err != nil {
+			fmt.Printf("Error: line %d: %s\n\n", line, err)
+			continue
+		}
+		if !c.Bool("quiet") {
+			fmt.Printf("Received: %s\n", rec.Received.Format(time.RFC3339Nano))
+			fmt.Printf("Product: %s\n", p.Name)
+			printNotification(&n.Notification)
+		}
+		if au != nil {
+			au.handle(c.Context, p, &n.Notification, rec.Received)
+		}
+		if metrics != nil {
+			metrics.handle(p, &n.Notification, rec.Received)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+	if au != nil {
+		au.wg.Wait()
+	}
+	if metrics != nil {
+		metrics.print(last)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+// Copyright (c) 2020-2024 Andrew Stormont
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNotificationRecorder(t *testing.T) {
+	tests := []struct {
+		name   string
+		raw    string
+		quoted bool
+	}{
+		{"notification", `{"notification":{"type":"VOLUME"}}`, false},
+		{"truncated", `{"notification": {"type": "VOL`, true},
+		{"not json", "<html>", true},
+		{"empty", "", true},
+	}
+	path := filepath.Join(t.TempDir(), "recording.ndjson")
+	r, err := newNotificationRecorder(path, &watchedProduct{Addr: "127.0.0.1", Name: "Kitchen"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC)
+	for _, tt := range tests {
+		if err = r.Record([]byte(tt.raw), received); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+	}
+	if err = r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = f.Close() }()
+	scanner := bufio.NewScanner(f)
+	for _, tt := range tests {
+		if !scanner.Scan() {
+			t.Fatalf("%s: not recorded", tt.name)
+		}
+		var rec recordedNotification
+		if err = json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		raw := string(rec.Raw)
+		if tt.quoted {
+			if err = json.Unmarshal(rec.Raw, &raw); err != nil {
+				t.Errorf("%s: raw %s isn't quoted: %v", tt.name, rec.Raw, err)
+			}
+		}
+		if raw != tt.raw || rec.Product != "Kitchen" || !rec.Received.Equal(received) {
+			t.Errorf("%s: got %+v, want raw %s", tt.name, rec, tt.raw)
+		}
+	}
+}
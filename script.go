@@ -0,0 +1,335 @@
+// Copyright (c) 2020-2024 Andrew Stormont
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"beoutil/clients/beoremote/models"
+
+	"github.com/urfave/cli/v2"
+)
+
+// scriptExit is raised in place of exiting the process
+// when a command fails to parse its arguments.
+type scriptExit int
+
+// scriptLine is the outcome of running a line of a script.
+type scriptLine struct {
+	Number  int
+	Command string
+	Err     error
+	Elapsed time.Duration
+}
+
+type script struct {
+	app         *cli.App
+	globals     []string
+	vars        map[string]string
+	stopOnError bool
+}
+
+// splitScriptLine splits a line into words like a shell would, honouring
+// quotes and backslash escapes, and expanding $NAME and ${NAME} outside
+// of single quotes. Variables set by the script take precedence over
+// environment variables.
+func (s *script) splitScriptLine(line string) ([]string, error) {
+	var (
+		words   []string
+		word    strings.Builder
+		inWord  bool
+		quote   rune
+		escaped bool
+	)
+	runes := []rune(line)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped, inWord = true, true
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote == 0 && (r == '"' || r == '\''):
+			quote, inWord = r, true
+		case r == '$' && quote != '\'':
+			name, n := scanVariable(runes[i+1:])
+			if n == 0 {
+				word.WriteRune(r)
+			} else {
+				word.WriteString(s.lookupVariable(name))
+				i += n
+			}
+			inWord = true
+		case quote == 0 && (r == ' ' || r == '\t'):
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		case quote == 0 && r == '#' && !inWord:
+			i = len(runes)
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, errors.New("unterminated quote or escape")
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// scanVariable returns the name of the variable at the start of runes,
+// and how many runes it took up.
+func scanVariable(runes []rune) (string, int) {
+	isName := func(r rune) bool {
+		return r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9')
+	}
+	if len(runes) > 0 && runes[0] == '{' {
+		for i := 1; i < len(runes); i++ {
+			if runes[i] == '}' {
+				return string(runes[1:i]), i + 1
+			}
+		}
+		return "", 0
+	}
+	n := 0
+	for n < len(runes) && isName(runes[n]) {
+		n++
+	}
+	return string(runes[:n]), n
+}
+
+func (s *script) lookupVariable(name string) string {
+	if v, ok := s.vars[name]; ok {
+		return v
+	}
+	return os.Getenv(name)
+}
+
+// runCommand runs a beoutil command, turning any attempt to exit into
+// an error so that one bad line doesn't end the whole script.
+func (s *script) runCommand(ctx context.Context, args []string) (err error) {
+	exiter := cli.OsExiter
+	cli.OsExiter = func(code int) {
+		panic(scriptExit(code))
+	}
+	defer func() {
+		cli.OsExiter = exiter
+		if r := recover(); r != nil {
+			code, ok := r.(scriptExit)
+			if !ok {
+				panic(r)
+			}
+			err = fmt.Errorf("invalid usage (exit status %d)", int(code))
+		}
+	}()
+	argv := append([]string{s.app.Name}, s.globals...)
+	return s.app.RunContext(ctx, append(argv, args...))
+}
+
+// waitFor waits for a notification from a product that matches the given
+// conditions, e.g. "wait-for kitchen type=PROGRESS_INFORMATION state=play".
+// A timeout=<duration> condition limits how long to wait.
+func (s *script) waitFor(ctx context.Context, args []string) error {
+	if len(args) < 2 {
+		return errors.New("usage: wait-for <product> <key=value>... [timeout=<duration>]")
+	}
+	timeout := time.Minute
+	var conds []string
+	for _, a := range args[1:] {
+		if strings.HasPrefix(a, "timeout=") {
+			d, err := time.ParseDuration(strings.TrimPrefix(a, "timeout="))
+			if err != nil {
+				return err
+			}
+			timeout = d
+			continue
+		}
+		conds = append(conds, a)
+	}
+	cs, err := parseNotificationConditions(conds)
+	if err != nil {
+		return err
+	}
+	br, err := getClient(args[0])
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	matched := false
+	err = subscribe(ctx, br, func(n *models.Notification) bool {
+		matched = matchNotification(n, cs)
+		return !matched
+	})
+	if matched {
+		return nil
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("no matching notification after %s", timeout)
+	}
+	return err
+}
+
+// runLine runs a single line of a script.
+func (s *script) runLine(ctx context.Context, words []string) error {
+	switch words[0] {
+	case "set":
+		if len(words) != 3 {
+			return errors.New("usage: set <name> <value>")
+		}
+		s.vars[words[1]] = words[2]
+		return nil
+	case "on-error":
+		if len(words) != 2 || (words[1] != "continue" && words[1] != "abort") {
+			return errors.New("usage: on-error continue|abort")
+		}
+		s.stopOnError = words[1] == "abort"
+		return nil
+	case "wait":
+		if len(words) != 2 {
+			return errors.New("usage: wait <duration>")
+		}
+		d, err := time.ParseDuration(words[1])
+		if err != nil {
+			return err
+		}
+		select {
+		case <-time.After(d):
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	case "wait-for":
+		return s.waitFor(ctx, words[1:])
+	}
+	return s.runCommand(ctx, words)
+}
+
+func (s *script) run(ctx context.Context, r io.Reader) ([]scriptLine, error) {
+	var results []scriptLine
+	scanner := bufio.NewScanner(r)
+	number := 0
+	for scanner.Scan() {
+		number++
+		words, err := s.splitScriptLine(scanner.Text())
+		if err == nil && len(words) == 0 {
+			continue
+		}
+		start := time.Now()
+		if err == nil {
+			err = s.runLine(ctx, words)
+		}
+		results = append(results, scriptLine{
+			Number:  number,
+			Command: strings.TrimSpace(scanner.Text()),
+			Err:     err,
+			Elapsed: time.Since(start),
+		})
+		if err != nil && (s.stopOnError || ctx.Err() != nil) {
+			break
+		}
+	}
+	return results, scanner.Err()
+}
+
+func printScriptResults(results []scriptLine) {
+	tw := new(tabwriter.Writer)
+	tw.Init(os.Stderr, 8, 4, 1, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "LINE\tCOMMAND\tRESULT\tTIME")
+	for _, r := range results {
+		result := "ok"
+		if r.Err != nil {
+			result = "error: " + r.Err.Error()
+		}
+		_, _ = fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", r.Number, r.Command, result, r.Elapsed.Round(time.Millisecond))
+	}
+	_ = tw.Flush()
+}
+
+func doRun(c *cli.Context) error {
+	args := c.Args()
+	if args.Len() != 1 {
+		cli.ShowSubcommandHelpAndExit(c, 1)
+	}
+	if onError := c.String("on-error"); onError != "continue" && onError != "abort" {
+		return fmt.Errorf("invalid --on-error value: %s", onError)
+	}
+	var r io.Reader = os.Stdin
+	if args.First() != "-" {
+		f, err := os.Open(args.First())
+		if err != nil {
+			return err
+		}
+		defer func() { _ = f.Close() }()
+		r = f
+	}
+	s := &script{
+		app:         c.App,
+		vars:        make(map[string]string),
+		stopOnError: c.String("on-error") == "abort",
+	}
+	// Commands in the script run with the same global flags as run itself.
+	for _, name := range []string{"config", "profile", "cache-ttl"} {
+		if c.IsSet(name) {
+			s.globals = append(s.globals, "--"+name, c.String(name))
+		}
+	}
+	if c.Bool("no-cache") {
+		s.globals = append(s.globals, "--no-cache")
+	}
+	results, err := s.run(c.Context, r)
+	if err != nil {
+		return err
+	}
+	if !c.Bool("quiet") {
+		printScriptResults(results)
+	}
+	var failed int
+	var last error
+	for _, r := range results {
+		if r.Err != nil {
+			failed++
+			last = r.Err
+		}
+	}
+	if failed == 1 {
+		return fmt.Errorf("line failed: %w", last)
+	} else if failed > 1 {
+		return fmt.Errorf("%d lines failed, last error: %w", failed, last)
+	}
+	return nil
+}
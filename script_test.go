@@ -0,0 +1,74 @@
+// Copyright (c) 2020-2024 Andrew Stormont
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitScriptLine(t *testing.T) {
+	t.Setenv("BEOUTIL_TEST_ROOM", "lounge")
+	t.Setenv("BEOUTIL_TEST_VOLUME", "10")
+	s := &script{vars: map[string]string{
+		"room":                "kitchen",
+		"BEOUTIL_TEST_VOLUME": "25",
+	}}
+	tests := []struct {
+		line    string
+		want    []string
+		wantErr bool
+	}{
+		{line: "", want: nil},
+		{line: "   \t ", want: nil},
+		{line: "set-volume kitchen 20", want: []string{"set-volume", "kitchen", "20"}},
+		{line: "  pause\t kitchen  ", want: []string{"pause", "kitchen"}},
+		{line: `queue-station "Radio 4"`, want: []string{"queue-station", "Radio 4"}},
+		{line: `queue-station 'Radio "4"'`, want: []string{"queue-station", `Radio "4"`}},
+		{line: `a"b c"d`, want: []string{"ab cd"}},
+		{line: `say Radio\ 4 \"x\"`, want: []string{"say", "Radio 4", `"x"`}},
+		{line: `''`, want: []string{""}},
+		{line: "# a comment", want: nil},
+		{line: "pause kitchen # trailing", want: []string{"pause", "kitchen"}},
+		{line: "echo a#b '#c'", want: []string{"echo", "a#b", "#c"}},
+		{line: "pause $room", want: []string{"pause", "kitchen"}},
+		{line: "pause ${room}s", want: []string{"pause", "kitchens"}},
+		{line: `pause "$room one"`, want: []string{"pause", "kitchen one"}},
+		{line: `pause '$room'`, want: []string{"pause", "$room"}},
+		{line: `pause \$room`, want: []string{"pause", "$room"}},
+		{line: "pause $BEOUTIL_TEST_ROOM", want: []string{"pause", "lounge"}},
+		{line: "set-volume x $BEOUTIL_TEST_VOLUME", want: []string{"set-volume", "x", "25"}},
+		{line: "cost $ 5", want: []string{"cost", "$", "5"}},
+		{line: "pause ${room", want: []string{"pause", "${room"}},
+		{line: `pause "kitchen`, wantErr: true},
+		{line: `pause 'kitchen`, wantErr: true},
+		{line: `pause kitchen\`, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := s.splitScriptLine(tt.line)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("splitScriptLine(%q) error = %v, wantErr %t", tt.line, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitScriptLine(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
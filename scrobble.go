@@ -0,0 +1,287 @@
+// Copyright (c) 2020-2024 Andrew Stormont
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"beoutil/clients/beoremote/models"
+	"beoutil/clients/listenbrainz"
+	lbModels "beoutil/clients/listenbrainz/models"
+
+	"github.com/urfave/cli/v2"
+)
+
+// scrobbleThreshold is how long a track must be played for to count as
+// a listen: half the track, or four minutes, whichever is shorter. ok is
+// false for tracks of 30 seconds or less, which never count. A duration
+// of zero means it isn't known.
+func scrobbleThreshold(duration int) (threshold int, ok bool) {
+	const max = 4 * 60
+	switch {
+	case duration <= 0:
+		return max, true
+	case duration <= 30:
+		return 0, false
+	case duration/2 < max:
+		return duration / 2, true
+	}
+	return max, true
+}
+
+// isDeezerPlay reports whether p is a Deezer track, rather than radio or
+// music from another source. If the source isn't known, the track ID
+// must look like a Deezer one.
+func isDeezerPlay(p *historyPlay) bool {
+	if p.Station != "" {
+		return false
+	}
+	if p.sourceType != "" && !strings.EqualFold(p.sourceType, "DEEZER") {
+		return false
+	}
+	id, err := strconv.ParseUint(p.TrackID, 10, 64)
+	return err == nil && id > 0
+}
+
+func toTrackMetadata(p *historyPlay) lbModels.TrackMetadata {
+	return lbModels.TrackMetadata{
+		ArtistName:  p.Artist,
+		TrackName:   p.Track,
+		ReleaseName: p.Album,
+		AdditionalInfo: lbModels.AdditionalInfo{
+			DurationMs:       p.Duration * 1000,
+			OriginURL:        "https://www.deezer.com/track/" + p.TrackID,
+			MusicService:     "deezer.com",
+			MediaPlayer:      "Bang & Olufsen",
+			SubmissionClient: "beoutil",
+		},
+	}
+}
+
+// scrobbleQueue holds listens that haven't been submitted yet. It's kept
+// on disk, so listens made while the server can't be reached are
+// submitted later, even if beoutil is restarted in the meantime.
+type scrobbleQueue struct {
+	mu     sync.Mutex
+	path   string
+	client *listenbrainz.Client
+}
+
+func (q *scrobbleQueue) load() ([]lbModels.Listen, error) {
+	b, err := os.ReadFile(q.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var listens []lbModels.Listen
+	if err = json.Unmarshal(b, &listens); err != nil {
+		return nil, err
+	}
+	return listens, nil
+}
+
+func (q *scrobbleQueue) save(listens []lbModels.Listen) error {
+	if len(listens) == 0 {
+		err := os.Remove(q.path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	b, err := json.Marshal(listens)
+	if err != nil {
+		return err
+	}
+	tmp := q.path + ".tmp"
+	if err = os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, q.path)
+}
+
+// Add queues a listen and tries to submit everything queued.
+func (q *scrobbleQueue) Add(ctx context.Context, l lbModels.Listen) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	listens, err := q.load()
+	if err != nil {
+		return err
+	}
+	if err = q.save(append(listens, l)); err != nil {
+		return err
+	}
+	return q.flush(ctx)
+}
+
+// Flush submits everything queued.
+func (q *scrobbleQueue) Flush(ctx context.Context) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.flush(ctx)
+}
+
+func (q *scrobbleQueue) flush(ctx context.Context) error {
+	listens, err := q.load()
+	if err != nil || len(listens) == 0 {
+		return err
+	}
+	for len(listens) > 0 {
+		n := len(listens)
+		if n > listenbrainz.MaxListensPerSubmission {
+			n = listenbrainz.MaxListensPerSubmission
+		}
+		ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		err = q.client.SubmitListens(ctx, listens[:n])
+		cancel()
+		var lbErr *listenbrainz.Error
+		if errors.As(err, &lbErr) && lbErr.StatusCode == 400 {
+			// The server will never accept these, so don't keep trying.
+			log.Printf("Dropping %d listens: %v", n, err)
+		} else if err != nil {
+			_ = q.save(listens)
+			return err
+		}
+		listens = listens[n:]
+	}
+	return q.save(nil)
+}
+
+func getScrobbleQueuePath() (string, error) {
+	dir, err := getConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "listenbrainz-queue.json"), nil
+}
+
+func doScrobble(c *cli.Context) error {
+	endpoint := config.ListenBrainz.Endpoint
+	if c.IsSet("endpoint") || endpoint == "" {
+		endpoint = c.String("endpoint")
+	}
+	token := config.ListenBrainz.Token
+	if c.IsSet("token") || token == "" {
+		token = c.String("token")
+	}
+	if token == "" {
+		return errors.New("no ListenBrainz token, use --token or set listenbrainz.token in the config file")
+	}
+	retryInterval, err := intervalFlag(c, "retry-interval")
+	if err != nil {
+		return err
+	}
+	path, err := getScrobbleQueuePath()
+	if err != nil {
+		return err
+	}
+	products, err := getWatchedProducts(c.StringSlice("product"))
+	if err != nil {
+		return err
+	}
+	client := listenbrainz.NewClient(endpoint, token)
+	ctx := c.Context
+	if user, err := client.ValidateToken(ctx); err != nil {
+		var lbErr *listenbrainz.Error
+		if errors.As(err, &lbErr) && !lbErr.Temporary() {
+			return err
+		}
+		// Carry on, listens are queued until the server is back.
+		log.Printf("Failed to validate token: %v", err)
+	} else {
+		log.Printf("Scrobbling to %s as %s", endpoint, user)
+	}
+	q := &scrobbleQueue{path: path, client: client}
+	go func() {
+		ticker := time.NewTicker(retryInterval)
+		defer ticker.Stop()
+		for {
+			if err := q.Flush(ctx); err != nil {
+				log.Printf("Failed to submit queued listens: %v", err)
+			}
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	var (
+		mu      sync.Mutex
+		pending sync.WaitGroup
+	)
+	trackers := make(map[string]*playTracker)
+	for _, p := range products {
+		t := newPlayTracker(p)
+		t.OnStart = func(p *historyPlay) {
+			if !isDeezerPlay(p) {
+				return
+			}
+			go func(track lbModels.TrackMetadata) {
+				ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+				defer cancel()
+				if err := client.PlayingNow(ctx, track); err != nil {
+					log.Printf("Failed to submit now playing: %v", err)
+				}
+			}(toTrackMetadata(p))
+		}
+		t.OnEnd = func(p *historyPlay) {
+			if !isDeezerPlay(p) {
+				return
+			}
+			if threshold, ok := scrobbleThreshold(p.Duration); !ok || p.Listened < threshold {
+				return
+			}
+			log.Printf("%s: %s - %s", p.Product, p.Artist, p.Track)
+			pending.Add(1)
+			go func(l lbModels.Listen) {
+				defer pending.Done()
+				if err := q.Add(ctx, l); err != nil {
+					log.Printf("Failed to submit listen, queued for later: %v", err)
+				}
+			}(lbModels.Listen{ListenedAt: p.Start.Unix(), TrackMetadata: toTrackMetadata(p)})
+		}
+		trackers[p.Addr] = t
+	}
+	watchProducts(ctx, products, func(p *watchedProduct, n *models.Notification) {
+		mu.Lock()
+		defer mu.Unlock()
+		trackers[p.Addr].Handle(n, time.Now())
+	})
+	// Anything playing long enough when we were stopped still counts,
+	// and is queued for next time.
+	now := time.Now()
+	for _, t := range trackers {
+		t.end(now)
+	}
+	pending.Wait()
+	return nil
+}
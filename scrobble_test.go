@@ -0,0 +1,232 @@
+// Copyright (c) 2020-2024 Andrew Stormont
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"beoutil/clients/beoremote/models"
+	"beoutil/clients/listenbrainz"
+	lbModels "beoutil/clients/listenbrainz/models"
+)
+
+func TestScrobbleThreshold(t *testing.T) {
+	tests := []struct {
+		duration  int
+		threshold int
+		ok        bool
+	}{
+		{duration: 0, threshold: 240, ok: true},
+		{duration: 10, ok: false},
+		{duration: 30, ok: false},
+		{duration: 31, threshold: 15, ok: true},
+		{duration: 200, threshold: 100, ok: true},
+		{duration: 480, threshold: 240, ok: true},
+		{duration: 3600, threshold: 240, ok: true},
+	}
+	for _, tt := range tests {
+		threshold, ok := scrobbleThreshold(tt.duration)
+		if ok != tt.ok || (ok && threshold != tt.threshold) {
+			t.Errorf("scrobbleThreshold(%d) = %d, %t, want %d, %t", tt.duration, threshold, ok, tt.threshold, tt.ok)
+		}
+	}
+}
+
+func TestIsDeezerPlay(t *testing.T) {
+	tests := []struct {
+		name string
+		play historyPlay
+		want bool
+	}{
+		{"deezer id", historyPlay{TrackID: "3135556"}, true},
+		{"deezer source", historyPlay{TrackID: "3135556", sourceType: "DEEZER"}, true},
+		{"other source", historyPlay{TrackID: "3135556", sourceType: "DLNA"}, false},
+		{"dlna id", historyPlay{TrackID: "1$4$27"}, false},
+		{"tidal style id", historyPlay{TrackID: "tidal:12345"}, false},
+		{"no id", historyPlay{}, false},
+		{"zero id", historyPlay{TrackID: "0"}, false},
+		{"radio", historyPlay{TrackID: "3135556", Station: "Radio 4"}, false},
+	}
+	for _, tt := range tests {
+		if got := isDeezerPlay(&tt.play); got != tt.want {
+			t.Errorf("%s: isDeezerPlay() = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPlayTrackerSourceType(t *testing.T) {
+	var started []*historyPlay
+	tr := newPlayTracker(&watchedProduct{Addr: "127.0.0.1", Name: "Kitchen"})
+	tr.OnStart = func(p *historyPlay) { started = append(started, p) }
+	now := time.Now()
+	for _, n := range []models.Notification{
+		{Type: models.NotificationTypeSource, Data: json.RawMessage(
+			`{"primaryExperience": {"source": {"sourceType": {"type": "DLNA"}}}}`)},
+		{Type: models.NotificationTypeNowPlayingStoredMusic, Data: json.RawMessage(
+			`{"name": "Schism", "trackId": "12", "playQueueItemId": "plid-1"}`)},
+		{Type: models.NotificationTypeSource, Data: json.RawMessage(
+			`{"primaryExperience": {"source": {"sourceType": {"type": "DEEZER"}}}}`)},
+		{Type: models.NotificationTypeNowPlayingStoredMusic, Data: json.RawMessage(
+			`{"name": "Schism", "trackId": "3135556", "playQueueItemId": "plid-2"}`)},
+	} {
+		tr.Handle(&n, now)
+	}
+	if len(started) != 2 {
+		t.Fatalf("got %d plays, want 2", len(started))
+	}
+	if isDeezerPlay(started[0]) || !isDeezerPlay(started[1]) {
+		t.Errorf("got source types %q and %q", started[0].sourceType, started[1].sourceType)
+	}
+}
+
+// fakeListenBrainz records the listens submitted to it, or fails them
+// with status if it's set.
+type fakeListenBrainz struct {
+	mu          sync.Mutex
+	status      int
+	submissions [][]lbModels.Listen
+}
+
+func (f *fakeListenBrainz) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.status != 0 {
+		w.WriteHeader(f.status)
+		_, _ = w.Write([]byte(`{"code": 0, "error": "failed"}`))
+		return
+	}
+	var s lbModels.Submission
+	if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	f.submissions = append(f.submissions, s.Payload)
+	_, _ = w.Write([]byte(`{"status": "ok"}`))
+}
+
+func (f *fakeListenBrainz) setStatus(status int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.status = status
+}
+
+func (f *fakeListenBrainz) submitted() []int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var sizes []int
+	for _, s := range f.submissions {
+		sizes = append(sizes, len(s))
+	}
+	return sizes
+}
+
+func newTestScrobbleQueue(t *testing.T) (*scrobbleQueue, *fakeListenBrainz) {
+	t.Helper()
+	f := &fakeListenBrainz{}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return &scrobbleQueue{
+		path:   filepath.Join(t.TempDir(), "queue.json"),
+		client: listenbrainz.NewClient(srv.URL, "secret"),
+	}, f
+}
+
+func testListen(i int) lbModels.Listen {
+	return lbModels.Listen{ListenedAt: int64(1700000000 + i), TrackMetadata: lbModels.TrackMetadata{
+		ArtistName: "Tool",
+		TrackName:  "Schism",
+	}}
+}
+
+func TestScrobbleQueueSubmits(t *testing.T) {
+	q, f := newTestScrobbleQueue(t)
+	if err := q.Add(context.Background(), testListen(0)); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.submitted(); len(got) != 1 || got[0] != 1 {
+		t.Errorf("submitted %v, want [1]", got)
+	}
+	if _, err := os.Stat(q.path); !os.IsNotExist(err) {
+		t.Errorf("queue file left behind: %v", err)
+	}
+}
+
+func TestScrobbleQueueRetries(t *testing.T) {
+	q, f := newTestScrobbleQueue(t)
+	f.setStatus(http.StatusServiceUnavailable)
+	for i := 0; i < 3; i++ {
+		if err := q.Add(context.Background(), testListen(i)); err == nil {
+			t.Fatal("expected an error while the server is down")
+		}
+	}
+	listens, err := q.load()
+	if err != nil || len(listens) != 3 {
+		t.Fatalf("queued %d listens, %v, want 3", len(listens), err)
+	}
+	// A new queue on the same file picks up where the last one left off,
+	// as if beoutil had been restarted.
+	q = &scrobbleQueue{path: q.path, client: q.client}
+	f.setStatus(0)
+	if err = q.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.submitted(); len(got) != 1 || got[0] != 3 {
+		t.Errorf("submitted %v, want [3]", got)
+	}
+	if listens, _ = q.load(); len(listens) != 0 {
+		t.Errorf("%d listens still queued", len(listens))
+	}
+}
+
+func TestScrobbleQueueDropsRejected(t *testing.T) {
+	q, f := newTestScrobbleQueue(t)
+	f.setStatus(http.StatusBadRequest)
+	if err := q.Add(context.Background(), testListen(0)); err != nil {
+		t.Fatal(err)
+	}
+	if listens, _ := q.load(); len(listens) != 0 {
+		t.Errorf("%d rejected listens still queued", len(listens))
+	}
+}
+
+func TestScrobbleQueueBatches(t *testing.T) {
+	q, f := newTestScrobbleQueue(t)
+	var listens []lbModels.Listen
+	for i := 0; i < listenbrainz.MaxListensPerSubmission+5; i++ {
+		listens = append(listens, testListen(i))
+	}
+	if err := q.save(listens); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.submitted(); len(got) != 2 || got[0] != listenbrainz.MaxListensPerSubmission || got[1] != 5 {
+		t.Errorf("submitted %v, want [%d 5]", got, listenbrainz.MaxListensPerSubmission)
+	}
+}
@@ -0,0 +1,196 @@
+// Copyright (c) 2020-2024 Andrew Stormont
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"beoutil/clients/beoremote"
+	"beoutil/clients/beoremote/models"
+
+	"github.com/urfave/cli/v2"
+)
+
+// streamFlags are the flags of commands that control a stream.
+func streamFlags(flags ...cli.Flag) []cli.Flag {
+	return append(append(flags,
+		&cli.BoolFlag{
+			Name:    "group",
+			Aliases: []string{"g"},
+			Usage:   "Also run against every product listening to the same experience",
+		},
+		&cli.BoolFlag{
+			Name:  "all",
+			Usage: "Run against every product that's playing",
+		},
+	), fanOutFlags()...)
+}
+
+// streamAction is like targetAction, except --all only targets products
+// that are playing, and --group extends the targets to every product
+// sharing their primary experience.
+func streamAction(fn targetFunc) cli.ActionFunc {
+	return func(c *cli.Context) error {
+		var targets, args []string
+		var err error
+		if c.Bool("all") {
+			if c.NArg() != 0 {
+				cli.ShowSubcommandHelpAndExit(c, 1)
+			}
+			if targets, err = getPlayingProducts(c); err != nil {
+				return err
+			}
+			if len(targets) == 0 {
+				fmt.Println("Nothing is playing.")
+				return nil
+			}
+		} else if targets, args, err = resolveTargets(c, 0); err != nil {
+			return err
+		}
+		if c.Bool("group") {
+			if targets, err = getGroups(c, targets); err != nil {
+				return err
+			}
+		}
+		return runTargets(c, os.Stdout, targets, args, fn)
+	}
+}
+
+// getPlayingProducts returns the address of every cached product whose
+// primary experience is playing.
+func getPlayingProducts(c *cli.Context) ([]string, error) {
+	products, err := getAllSystemProducts(c.Context, c.Int("workers"), c.Duration("product-timeout"))
+	if err != nil {
+		return nil, err
+	}
+	var targets []string
+	for _, p := range products {
+		pe := p.PrimaryExperience
+		if pe != nil && pe.State == models.StatePlay && len(p.IPs) > 0 {
+			targets = append(targets, p.IPs[0].String())
+		}
+	}
+	return targets, nil
+}
+
+// getGroups returns targets along with the leader and listeners of their
+// primary experiences. Products missing from the cache are skipped with
+// a warning.
+func getGroups(c *cli.Context, targets []string) ([]string, error) {
+	cached, err := getCachedProducts()
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	var group []string
+	add := func(addr string) {
+		if !seen[addr] {
+			seen[addr] = true
+			group = append(group, addr)
+		}
+	}
+	addJid := func(jid models.Jid) {
+		if jid == "" {
+			return
+		}
+		if p, ok := cached[jid]; ok && len(p.IPs) > 0 {
+			add(p.IPs[0].String())
+		} else {
+			_, _ = fmt.Fprintf(os.Stderr, "Skipping %s, it isn't in the product cache.\n", jid)
+		}
+	}
+	for _, t := range targets {
+		add(t)
+		br, err := getClient(t)
+		if err != nil {
+			return nil, err
+		}
+		ctx, cancel := context.WithTimeout(c.Context, c.Duration("product-timeout"))
+		r, err := br.BeoZone.GetActiveSources(ctx)
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+		pe := r.PrimaryExperience
+		addJid(pe.Product.Jid)
+		for _, l := range pe.ListenerList.Listener {
+			addJid(l.Jid)
+		}
+	}
+	return group, nil
+}
+
+// doToggle pauses the stream if it's playing, otherwise plays it, and
+// returns the state the product ends up in. Products that don't report
+// their progress in time are assumed not to be playing.
+func doToggle(ctx context.Context, c *cli.Context, br *beoremote.Client, _ []string) (string, error) {
+	timeout := c.Duration("timeout")
+	pctx, cancel := context.WithTimeout(ctx, timeout)
+	progress, err := br.BeoZone.GetProgress(pctx)
+	cancel()
+	playing := false
+	switch {
+	case errors.Is(err, beoremote.ErrTimeout) || errors.Is(err, context.DeadlineExceeded):
+	case err != nil:
+		return "", err
+	default:
+		playing = progress.State == models.StatePlay
+	}
+	want := models.StatePause
+	if playing {
+		err = br.BeoZone.Pause(ctx)
+	} else {
+		want = models.StatePlay
+		err = br.BeoZone.Play(ctx)
+	}
+	if err != nil {
+		return "", err
+	}
+	state, err := waitForState(ctx, br, want, timeout)
+	return string(state), err
+}
+
+// waitForState waits for a product to report the stream state want. If
+// it doesn't within timeout, the last state reported is returned.
+func waitForState(ctx context.Context, br *beoremote.Client, want models.State, timeout time.Duration) (models.State, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	var last models.State
+	err := subscribe(ctx, br, func(n *models.Notification) bool {
+		if n.Type != models.NotificationTypeProgressInformation {
+			return true
+		}
+		var d models.ProgressInformationData
+		if json.Unmarshal(n.Data, &d) == nil {
+			last = d.State
+		}
+		return last != want
+	})
+	if last != "" {
+		return last, nil
+	}
+	return last, err
+}
@@ -0,0 +1,222 @@
+// Copyright (c) 2020-2024 Andrew Stormont
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"net"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"beoutil/clients/beoremote"
+	"beoutil/clients/beoremote/models"
+	"beoutil/clients/rest"
+
+	"github.com/urfave/cli/v2"
+)
+
+// fakeStreamZone is a product whose stream can be played and paused. It
+// reports its state on the notification stream when asked.
+type fakeStreamZone struct {
+	beoremote.BeoZone
+	mu          sync.Mutex
+	state       models.State
+	progressErr error // progressErr is returned by GetProgress if set.
+	hang        bool  // hang makes GetProgress wait until it's cancelled.
+	stuck       bool  // stuck stops Play and Pause changing the state.
+	calls       []string
+	sources     *models.ActiveSourcesResponse
+}
+
+func (z *fakeStreamZone) GetProgress(ctx context.Context) (*models.ProgressInformationData, error) {
+	if z.hang {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	if z.progressErr != nil {
+		return nil, z.progressErr
+	}
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	return &models.ProgressInformationData{State: z.state}, nil
+}
+
+func (z *fakeStreamZone) setState(call string, state models.State) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	z.calls = append(z.calls, call)
+	if !z.stuck {
+		z.state = state
+	}
+}
+
+func (z *fakeStreamZone) Play(ctx context.Context) error {
+	z.setState("play", models.StatePlay)
+	return nil
+}
+
+func (z *fakeStreamZone) Pause(ctx context.Context) error {
+	z.setState("pause", models.StatePause)
+	return nil
+}
+
+func (z *fakeStreamZone) OpenNotificationStream(ctx context.Context) (<-chan rest.Event, error) {
+	z.mu.Lock()
+	data, _ := json.Marshal(models.ProgressInformationData{State: z.state})
+	z.mu.Unlock()
+	b, _ := json.Marshal(models.NotificationWrapper{Notification: models.Notification{
+		Type: models.NotificationTypeProgressInformation,
+		Data: data,
+	}})
+	events := make(chan rest.Event, 1)
+	events <- rest.Event{Value: b}
+	go func() {
+		<-ctx.Done()
+		close(events)
+	}()
+	return events, nil
+}
+
+func (z *fakeStreamZone) GetActiveSources(ctx context.Context) (*models.ActiveSourcesResponse, error) {
+	return z.sources, nil
+}
+
+// useFakeClient makes getClient return a client using z for addr.
+func useFakeClient(t *testing.T, addr string, z beoremote.BeoZone) *beoremote.Client {
+	br := &beoremote.Client{BeoZone: z}
+	resolveMu.Lock()
+	clients[addr] = br
+	resolveMu.Unlock()
+	t.Cleanup(func() {
+		resolveMu.Lock()
+		delete(clients, addr)
+		resolveMu.Unlock()
+	})
+	return br
+}
+
+func newTestStreamContext(timeout time.Duration) *cli.Context {
+	set := flag.NewFlagSet("test", flag.ContinueOnError)
+	set.Duration("timeout", timeout, "")
+	set.Duration("product-timeout", time.Second, "")
+	return cli.NewContext(cli.NewApp(), set, nil)
+}
+
+func TestDoToggle(t *testing.T) {
+	tests := []struct {
+		name    string
+		zone    *fakeStreamZone
+		want    string
+		calls   []string
+		wantErr error
+	}{
+		{"playing", &fakeStreamZone{state: models.StatePlay}, "pause", []string{"pause"}, nil},
+		{"paused", &fakeStreamZone{state: models.StatePause}, "play", []string{"play"}, nil},
+		{"stopped", &fakeStreamZone{state: models.StateStop}, "play", []string{"play"}, nil},
+		{"progress times out", &fakeStreamZone{state: models.StatePause, hang: true}, "play", []string{"play"}, nil},
+		{"progress timeout error", &fakeStreamZone{state: models.StatePause,
+			progressErr: &beoremote.Error{Kind: beoremote.ErrTimeout, Err: errors.New("504")}}, "play", []string{"play"}, nil},
+		{"standby", &fakeStreamZone{progressErr: &beoremote.Error{Kind: beoremote.ErrStandby, Err: errors.New("503")}},
+			"", nil, beoremote.ErrStandby},
+		{"state doesn't change", &fakeStreamZone{state: models.StatePause, stuck: true}, "pause", []string{"play"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestStreamContext(50 * time.Millisecond)
+			br := &beoremote.Client{BeoZone: tt.zone}
+			got, err := doToggle(context.Background(), c, br, nil)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("doToggle() error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("doToggle() = %q, want %q", got, tt.want)
+			}
+			if !reflect.DeepEqual(tt.zone.calls, tt.calls) {
+				t.Errorf("calls = %q, want %q", tt.zone.calls, tt.calls)
+			}
+		})
+	}
+}
+
+func TestGetGroups(t *testing.T) {
+	setTestHome(t)
+	const (
+		kitchen = "192.0.2.1"
+		lounge  = "192.0.2.2"
+		bedroom = "192.0.2.3"
+		office  = "192.0.2.4"
+	)
+	cache := map[models.Jid]*ProductDetails{
+		"1@products.bang-olufsen.com": {Name: "Kitchen", IPs: []net.IP{net.ParseIP(kitchen)}},
+		"2@products.bang-olufsen.com": {Name: "Lounge", IPs: []net.IP{net.ParseIP(lounge)}},
+		"3@products.bang-olufsen.com": {Name: "Bedroom", IPs: []net.IP{net.ParseIP(bedroom)}},
+		"4@products.bang-olufsen.com": {Name: "Office", IPs: []net.IP{net.ParseIP(office)}},
+	}
+	b, err := json.Marshal(cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path, err := getCachePath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(path, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	experience := func(leader models.Jid, listeners ...models.Jid) *fakeStreamZone {
+		pe := models.PrimaryExperience{Product: models.ShortProduct{Jid: leader}}
+		for _, l := range listeners {
+			pe.ListenerList.Listener = append(pe.ListenerList.Listener, models.Listener{Jid: l})
+		}
+		return &fakeStreamZone{sources: &models.ActiveSourcesResponse{PrimaryExperience: pe}}
+	}
+	// The kitchen is listening to the lounge, along with the bedroom and
+	// a product that isn't cached. The office is on its own.
+	useFakeClient(t, kitchen, experience("2@products.bang-olufsen.com",
+		"1@products.bang-olufsen.com", "3@products.bang-olufsen.com", "9@products.bang-olufsen.com"))
+	useFakeClient(t, bedroom, experience("2@products.bang-olufsen.com",
+		"1@products.bang-olufsen.com", "3@products.bang-olufsen.com"))
+	useFakeClient(t, office, experience(""))
+	tests := []struct {
+		targets []string
+		want    []string
+	}{
+		{[]string{kitchen}, []string{kitchen, lounge, bedroom}},
+		{[]string{kitchen, bedroom}, []string{kitchen, lounge, bedroom}},
+		{[]string{office}, []string{office}},
+		{[]string{office, bedroom}, []string{office, bedroom, lounge, kitchen}},
+	}
+	c := newTestStreamContext(time.Second)
+	for _, tt := range tests {
+		got, err := getGroups(c, tt.targets)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("getGroups(%q) = %q, want %q", tt.targets, got, tt.want)
+		}
+	}
+}
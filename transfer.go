@@ -0,0 +1,150 @@
+// Copyright (c) 2020-2024 Andrew Stormont
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"beoutil/clients/beoremote"
+	"beoutil/clients/beoremote/models"
+
+	"github.com/urfave/cli/v2"
+)
+
+// copyQueueItem returns a copy of a queue item suitable for
+// adding to another product's queue.
+func copyQueueItem(qi models.PlayQueueItem) models.PlayQueueItem {
+	return models.PlayQueueItem{
+		Behaviour: models.Planned,
+		Track:     qi.Track,
+		Station:   qi.Station,
+	}
+}
+
+// addQueueItems appends items to the end of a product's queue. Runs of
+// Deezer tracks are added in a single request, which is much faster than
+// adding them one at a time.
+func addQueueItems(ctx context.Context, z beoremote.BeoZone, items []models.PlayQueueItem) error {
+	var deezerRun []models.PlayQueueItem
+	flush := func() error {
+		if len(deezerRun) == 0 {
+			return nil
+		}
+		err := z.AddDeezerTracks(ctx, deezerRun, beoremote.At(beoremote.Last))
+		deezerRun = nil
+		return err
+	}
+	for _, qi := range items {
+		qi = copyQueueItem(qi)
+		if qi.Track != nil && qi.Track.Deezer != nil {
+			deezerRun = append(deezerRun, qi)
+			continue
+		}
+		if err := flush(); err != nil {
+			return err
+		}
+		if err := z.AddQueueItem(ctx, qi, beoremote.At(beoremote.Last)); err != nil {
+			return err
+		}
+	}
+	return flush()
+}
+
+func doTransferQueue(c *cli.Context) error {
+	args := c.Args()
+	if args.Len() != 2 {
+		cli.ShowSubcommandHelpAndExit(c, 1)
+	}
+	if c.Bool("stop") && c.Bool("standby") {
+		return errors.New("--stop and --standby are mutually exclusive")
+	}
+	from, err := getClient(args.Get(0))
+	if err != nil {
+		return err
+	}
+	to, err := getClient(args.Get(1))
+	if err != nil {
+		return err
+	}
+	q, err := beoremote.GetFullPlayQueue(c.Context, from.BeoZone)
+	if err != nil {
+		return err
+	}
+	if len(q.PlayQueueItem) == 0 {
+		return errors.New("source queue is empty")
+	}
+	ptr := 0
+	for i, qi := range q.PlayQueueItem {
+		if qi.Id == q.PlayNowId {
+			ptr = i
+			break
+		}
+	}
+	// The position is only available from the notification stream, and
+	// only while something is playing, so don't wait long for it.
+	position := 0
+	pctx, cancel := context.WithTimeout(c.Context, c.Duration("timeout"))
+	p, err := from.BeoZone.GetProgress(pctx)
+	cancel()
+	if err == nil && p.PlayQueueItemID == q.PlayQueueItem[ptr].Id {
+		position = p.Position
+	}
+	if err = to.BeoZone.ClearPlayQueue(c.Context); err != nil {
+		return err
+	}
+	if err = addQueueItems(c.Context, to.BeoZone, q.PlayQueueItem); err != nil {
+		return err
+	}
+	nq, err := beoremote.GetFullPlayQueue(c.Context, to.BeoZone)
+	if err != nil {
+		return err
+	}
+	if len(nq.PlayQueueItem) != len(q.PlayQueueItem) {
+		return fmt.Errorf("expected %d queue items on target, found %d",
+			len(q.PlayQueueItem), len(nq.PlayQueueItem))
+	}
+	if q.Repeat != "" && q.Repeat != models.RepeatUnknown {
+		if err = to.BeoZone.SetQueueRepeat(c.Context, q.Repeat); err != nil {
+			return err
+		}
+	}
+	if q.Random != "" && q.Random != models.RandomUnknown {
+		if err = to.BeoZone.SetQueueRandom(c.Context, q.Random); err != nil {
+			return err
+		}
+	}
+	id := strings.TrimPrefix(string(nq.PlayQueueItem[ptr].Id), "plid-")
+	if err = to.BeoZone.SetPlayPointer(c.Context, id, position); err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(os.Stderr, "Transferred %d items.\n", len(q.PlayQueueItem))
+	if c.Bool("stop") {
+		return from.BeoZone.Stop(c.Context)
+	}
+	if c.Bool("standby") {
+		return from.BeoDevice.Standby(c.Context)
+	}
+	return nil
+}
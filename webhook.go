@@ -0,0 +1,407 @@
+// Copyright (c) 2020-2024 Andrew Stormont
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"text/template"
+	"time"
+
+	"beoutil/clients/beoremote/models"
+
+	"github.com/urfave/cli/v2"
+)
+
+// webhookEvent is the default payload POSTed for each notification, and
+// the data given to payload templates.
+type webhookEvent struct {
+	Product    string                  `json:"product"`
+	Addr       string                  `json:"addr"`
+	Type       models.NotificationType `json:"type"`
+	Kind       string                  `json:"kind"`
+	Timestamp  string                  `json:"timestamp"`
+	ReceivedAt time.Time               `json:"receivedAt"`
+	Data       json.RawMessage         `json:"data"`
+
+	n *models.Notification
+}
+
+// Field returns a field of the notification's data, e.g. "speaker.level",
+// for use in templates.
+func (e *webhookEvent) Field(key string) string {
+	v, _ := notificationField(e.n, key)
+	return v
+}
+
+var webhookTemplateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// webhookDelivery is a payload waiting to be sent. Deliveries that fail
+// are written to the spool directory as JSON, and retried from there.
+type webhookDelivery struct {
+	URL       string    `json:"url"`
+	Type      string    `json:"type"`
+	Body      string    `json:"body"`
+	Signature string    `json:"signature,omitempty"`
+	Created   time.Time `json:"created"`
+}
+
+// errPermanent marks a delivery that will never succeed, so isn't retried.
+var errPermanent = errors.New("rejected")
+
+type webhook struct {
+	config   WebhookConfig
+	products map[string]bool
+	match    []notificationCondition
+	template *template.Template
+	queue    chan *webhookDelivery
+}
+
+func newWebhook(wc WebhookConfig) (*webhook, error) {
+	if wc.URL == "" {
+		return nil, errors.New("webhook has no url")
+	}
+	w := &webhook{config: wc, queue: make(chan *webhookDelivery, 100)}
+	if len(wc.Products) > 0 {
+		w.products = make(map[string]bool)
+		for _, p := range wc.Products {
+			addr, err := resolveProduct(p)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", wc.URL, err)
+			}
+			w.products[addr] = true
+		}
+	}
+	var err error
+	if w.match, err = parseNotificationConditions(wc.Match); err != nil {
+		return nil, fmt.Errorf("%s: %w", wc.URL, err)
+	}
+	if wc.Template != "" {
+		w.template, err = template.New(wc.URL).Funcs(webhookTemplateFuncs).Parse(wc.Template)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+func sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// payload builds the delivery for a notification, or returns nil if this
+// webhook isn't interested in it.
+func (w *webhook) payload(p *watchedProduct, n *models.Notification) (*webhookDelivery, error) {
+	if w.products != nil && !w.products[p.Addr] {
+		return nil, nil
+	}
+	if !matchNotification(n, w.match) {
+		return nil, nil
+	}
+	e := &webhookEvent{
+		Product:    p.Name,
+		Addr:       p.Addr,
+		Type:       n.Type,
+		Kind:       n.Kind,
+		Timestamp:  n.Timestamp,
+		ReceivedAt: time.Now(),
+		Data:       n.Data,
+		n:          n,
+	}
+	var body []byte
+	if w.template != nil {
+		var b bytes.Buffer
+		if err := w.template.Execute(&b, e); err != nil {
+			return nil, err
+		}
+		body = b.Bytes()
+	} else {
+		var err error
+		if body, err = json.Marshal(e); err != nil {
+			return nil, err
+		}
+	}
+	d := &webhookDelivery{URL: w.config.URL, Type: string(n.Type), Body: string(body), Created: e.ReceivedAt}
+	if w.config.Secret != "" {
+		d.Signature = sign(w.config.Secret, body)
+	}
+	return d, nil
+}
+
+type webhookSender struct {
+	client     *http.Client
+	attempts   int
+	retryDelay time.Duration // retryDelay is the delay before the first retry, which doubles after each one.
+	spoolDir   string
+}
+
+// send makes a single attempt at a delivery.
+func (s *webhookSender) send(ctx context.Context, d *webhookDelivery) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewBufferString(d.Body))
+	if err != nil {
+		return fmt.Errorf("%w: %v", errPermanent, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "beoutil")
+	req.Header.Set("X-Beoutil-Event", d.Type)
+	if d.Signature != "" {
+		req.Header.Set("X-Beoutil-Signature", d.Signature)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusTooManyRequests:
+		return errors.New(resp.Status)
+	case resp.StatusCode >= 400 && resp.StatusCode < 500:
+		return fmt.Errorf("%w: %s", errPermanent, resp.Status)
+	}
+	return errors.New(resp.Status)
+}
+
+// deliver sends a delivery, retrying with increasing delays. Deliveries
+// that still fail are spooled to disk.
+func (s *webhookSender) deliver(ctx context.Context, d *webhookDelivery) {
+	delay := s.retryDelay
+	var err error
+	for attempt := 1; attempt <= s.attempts; attempt++ {
+		if err = s.send(ctx, d); err == nil || errors.Is(err, errPermanent) {
+			break
+		}
+		if attempt == s.attempts {
+			break
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			attempt = s.attempts
+		}
+		delay *= 2
+	}
+	if err == nil {
+		return
+	}
+	if errors.Is(err, errPermanent) {
+		log.Printf("%s: dropping %s notification: %v", d.URL, d.Type, err)
+		return
+	}
+	log.Printf("%s: failed to deliver %s notification: %v", d.URL, d.Type, err)
+	if err = s.spool(d); err != nil {
+		log.Printf("%s: failed to spool notification: %v", d.URL, err)
+	}
+}
+
+func (s *webhookSender) spool(d *webhookDelivery) error {
+	b, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(s.spoolDir, 0700); err != nil {
+		return err
+	}
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+	name := fmt.Sprintf("%d-%s.json", d.Created.UnixNano(), hex.EncodeToString(suffix))
+	return os.WriteFile(filepath.Join(s.spoolDir, name), b, 0600)
+}
+
+// flushSpool retries spooled deliveries, oldest first. Deliveries to a
+// URL that fails again are left for next time.
+func (s *webhookSender) flushSpool(ctx context.Context) {
+	entries, err := os.ReadDir(s.spoolDir)
+	if err != nil {
+		return
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	failed := make(map[string]bool)
+	for _, entry := range entries {
+		path := filepath.Join(s.spoolDir, entry.Name())
+		b, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var d webhookDelivery
+		if err = json.Unmarshal(b, &d); err != nil {
+			log.Printf("%s: %v", path, err)
+			continue
+		}
+		if failed[d.URL] {
+			continue
+		}
+		if err = s.send(ctx, &d); err != nil && !errors.Is(err, errPermanent) {
+			failed[d.URL] = true
+			continue
+		}
+		if err != nil {
+			log.Printf("%s: dropping spooled %s notification: %v", d.URL, d.Type, err)
+		}
+		_ = os.Remove(path)
+	}
+}
+
+func getWebhooks(c *cli.Context) ([]*webhook, error) {
+	configs := config.Webhooks
+	if urls := c.StringSlice("url"); len(urls) > 0 {
+		configs = nil
+		for _, u := range urls {
+			wc := WebhookConfig{
+				URL:    u,
+				Match:  c.StringSlice("match"),
+				Secret: c.String("secret"),
+			}
+			if path := c.String("template"); path != "" {
+				b, err := os.ReadFile(path)
+				if err != nil {
+					return nil, err
+				}
+				wc.Template = string(b)
+			}
+			configs = append(configs, wc)
+		}
+	}
+	if len(configs) == 0 {
+		return nil, errors.New("no webhooks configured, use --url or add webhooks to the config file")
+	}
+	var hooks []*webhook
+	for _, wc := range configs {
+		w, err := newWebhook(wc)
+		if err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, w)
+	}
+	return hooks, nil
+}
+
+func getSpoolDir(c *cli.Context) (string, error) {
+	if dir := c.String("spool-dir"); dir != "" {
+		return dir, nil
+	}
+	dir, err := getConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "spool", "webhook"), nil
+}
+
+func doWebhook(c *cli.Context) error {
+	hooks, err := getWebhooks(c)
+	if err != nil {
+		return err
+	}
+	spoolDir, err := getSpoolDir(c)
+	if err != nil {
+		return err
+	}
+	spoolInterval, err := intervalFlag(c, "spool-interval")
+	if err != nil {
+		return err
+	}
+	products, err := getWatchedProducts(c.StringSlice("product"))
+	if err != nil {
+		return err
+	}
+	s := &webhookSender{
+		client:     &http.Client{Timeout: c.Duration("timeout")},
+		attempts:   c.Int("attempts"),
+		retryDelay: time.Second,
+		spoolDir:   spoolDir,
+	}
+	if s.attempts < 1 {
+		s.attempts = 1
+	}
+	ctx := c.Context
+	// Each webhook has its own sender, so a slow URL doesn't hold up
+	// the others, and notifications arrive in order.
+	var wg sync.WaitGroup
+	for _, w := range hooks {
+		wg.Add(1)
+		go func(w *webhook) {
+			defer wg.Done()
+			for d := range w.queue {
+				s.deliver(ctx, d)
+			}
+		}(w)
+	}
+	go func() {
+		ticker := time.NewTicker(spoolInterval)
+		defer ticker.Stop()
+		for {
+			s.flushSpool(ctx)
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	log.Printf("Forwarding notifications from %d products to %d webhooks", len(products), len(hooks))
+	watchProducts(ctx, products, func(p *watchedProduct, n *models.Notification) {
+		for _, w := range hooks {
+			d, err := w.payload(p, n)
+			if err != nil {
+				log.Printf("%s: %v", w.config.URL, err)
+				continue
+			}
+			if d == nil {
+				continue
+			}
+			select {
+			case w.queue <- d:
+			default:
+				// The URL can't keep up, so skip straight to the spool.
+				if err = s.spool(d); err != nil {
+					log.Printf("%s: failed to spool notification: %v", d.URL, err)
+				}
+			}
+		}
+	})
+	// Anything still queued is spooled on the way out.
+	for _, w := range hooks {
+		close(w.queue)
+	}
+	wg.Wait()
+	return nil
+}
@@ -0,0 +1,218 @@
+// Copyright (c) 2020-2024 Andrew Stormont
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"beoutil/clients/beoremote/models"
+)
+
+// rfc4231Signature is the HMAC-SHA256 of "what do ya want for nothing?"
+// keyed with "Jefe", from RFC 4231 test case 2.
+const rfc4231Signature = "sha256=5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843"
+
+func TestSign(t *testing.T) {
+	if got := sign("Jefe", []byte("what do ya want for nothing?")); got != rfc4231Signature {
+		t.Errorf("sign() = %q, want %q", got, rfc4231Signature)
+	}
+}
+
+// webhookServer records the requests made to it, and responds to each
+// with the next of its statuses, or 200 once they run out.
+type webhookServer struct {
+	mu       sync.Mutex
+	statuses []int
+	headers  []http.Header
+	bodies   []string
+}
+
+func (s *webhookServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b, _ := io.ReadAll(r.Body)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.headers = append(s.headers, r.Header)
+	s.bodies = append(s.bodies, string(b))
+	status := http.StatusOK
+	if len(s.statuses) > 0 {
+		status, s.statuses = s.statuses[0], s.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func newTestWebhookServer(t *testing.T, statuses ...int) (*webhookServer, string) {
+	s := &webhookServer{statuses: statuses}
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+	return s, srv.URL
+}
+
+func newTestWebhookSender(t *testing.T) *webhookSender {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return &webhookSender{
+		client:     &http.Client{Timeout: 5 * time.Second},
+		attempts:   3,
+		retryDelay: time.Millisecond,
+		spoolDir:   t.TempDir(),
+	}
+}
+
+// spooled returns the bodies of the deliveries in the spool, oldest first.
+func spooled(t *testing.T, dir string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ds []webhookDelivery
+	for _, e := range entries {
+		b, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var d webhookDelivery
+		if err = json.Unmarshal(b, &d); err != nil {
+			t.Fatal(err)
+		}
+		ds = append(ds, d)
+	}
+	sort.Slice(ds, func(i, j int) bool { return ds[i].Created.Before(ds[j].Created) })
+	var bodies []string
+	for _, d := range ds {
+		bodies = append(bodies, d.Body)
+	}
+	return bodies
+}
+
+func TestWebhookSignatureHeader(t *testing.T) {
+	srv, url := newTestWebhookServer(t)
+	s := newTestWebhookSender(t)
+	w, err := newWebhook(WebhookConfig{URL: url, Secret: "Jefe", Template: "what do ya want for nothing?"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := &models.Notification{Type: models.NotificationTypeVolume, Data: json.RawMessage(`{}`)}
+	d, err := w.payload(&watchedProduct{Addr: "192.0.2.1", Name: "Kitchen"}, n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.send(context.Background(), d); err != nil {
+		t.Fatal(err)
+	}
+	if got := srv.headers[0].Get("X-Beoutil-Signature"); got != rfc4231Signature {
+		t.Errorf("X-Beoutil-Signature = %q, want %q", got, rfc4231Signature)
+	}
+	if got := srv.headers[0].Get("X-Beoutil-Event"); got != "VOLUME" {
+		t.Errorf("X-Beoutil-Event = %q, want VOLUME", got)
+	}
+	if srv.bodies[0] != "what do ya want for nothing?" {
+		t.Errorf("body = %q, want the template output", srv.bodies[0])
+	}
+}
+
+func TestWebhookDeliver(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		requests int
+		spooled  bool
+	}{
+		{"ok", nil, 1, false},
+		{"bad request", []int{http.StatusBadRequest}, 1, false},
+		{"not found", []int{http.StatusNotFound}, 1, false},
+		{"unauthorized", []int{http.StatusUnauthorized}, 1, false},
+		{"server error then ok", []int{http.StatusInternalServerError}, 2, false},
+		{"too many requests then ok", []int{http.StatusTooManyRequests, http.StatusTooManyRequests}, 3, false},
+		{"request timeout then ok", []int{http.StatusRequestTimeout}, 2, false},
+		{"server errors then bad request", []int{http.StatusBadGateway, http.StatusBadRequest}, 2, false},
+		{"server errors", []int{500, 502, 503}, 3, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, url := newTestWebhookServer(t, tt.statuses...)
+			s := newTestWebhookSender(t)
+			d := &webhookDelivery{URL: url, Type: "VOLUME", Body: `{"level":20}`, Created: time.Now()}
+			s.deliver(context.Background(), d)
+			if len(srv.bodies) != tt.requests {
+				t.Errorf("got %d requests, want %d", len(srv.bodies), tt.requests)
+			}
+			got := spooled(t, s.spoolDir)
+			if tt.spooled && (len(got) != 1 || got[0] != d.Body) {
+				t.Errorf("spooled %q, want the delivery", got)
+			}
+			if !tt.spooled && len(got) != 0 {
+				t.Errorf("spooled %q, want nothing", got)
+			}
+		})
+	}
+}
+
+func TestWebhookDeliverUnreachable(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+	s := newTestWebhookSender(t)
+	s.deliver(context.Background(), &webhookDelivery{URL: srv.URL, Type: "VOLUME", Body: "{}", Created: time.Now()})
+	if got := spooled(t, s.spoolDir); len(got) != 1 {
+		t.Errorf("spooled %q, want the delivery", got)
+	}
+}
+
+func TestWebhookFlushSpool(t *testing.T) {
+	good, goodURL := newTestWebhookServer(t, http.StatusOK, http.StatusBadRequest)
+	bad, badURL := newTestWebhookServer(t, http.StatusServiceUnavailable)
+	s := newTestWebhookSender(t)
+	start := time.Now()
+	for i, url := range []string{goodURL, badURL, goodURL, badURL, goodURL} {
+		d := &webhookDelivery{URL: url, Type: "VOLUME", Body: string(rune('1' + i)), Created: start.Add(time.Duration(i) * time.Second)}
+		if err := s.spool(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.flushSpool(context.Background())
+	// Everything to the good URL is replayed in order, and the 400 is
+	// dropped. The bad URL is only tried once, and its deliveries stay.
+	if got := good.bodies; len(got) != 3 || got[0] != "1" || got[1] != "3" || got[2] != "5" {
+		t.Errorf("good URL got %q, want [1 3 5]", got)
+	}
+	if got := bad.bodies; len(got) != 1 || got[0] != "2" {
+		t.Errorf("bad URL got %q, want [2]", got)
+	}
+	if got := spooled(t, s.spoolDir); len(got) != 2 || got[0] != "2" || got[1] != "4" {
+		t.Errorf("spool has %q, want [2 4]", got)
+	}
+	s.flushSpool(context.Background())
+	if got := bad.bodies; len(got) != 3 || got[1] != "2" || got[2] != "4" {
+		t.Errorf("bad URL got %q, want [2 2 4]", got)
+	}
+	if got := spooled(t, s.spoolDir); len(got) != 0 {
+		t.Errorf("spool has %q, want it empty", got)
+	}
+}